@@ -60,6 +60,10 @@ function App() {
         game.registry.events.on("levelUp", (data: any) => {
             setLevelUpData(data);
         });
+        // a resumed session's player is already in the world
+        game.registry.events.on("resumed", () => {
+            setShowIntroModal(false);
+        });
 
         const checkGameOver = () => {
             const gameOver = game.registry.get("gameOver");
@@ -77,6 +81,7 @@ function App() {
         return () => {
             if (gameRef.current) {
                 gameRef.current.registry.events.off("levelUp");
+                gameRef.current.registry.events.off("resumed");
                 gameRef.current.registry.destroy(); // Destroy registry
                 gameRef.current.destroy(true); // Destroy game
                 const canvas = document.querySelector("#phaser-game canvas");
@@ -9,9 +9,10 @@ export function DebugInfo({ gameRef }: DebugInfoProps) {
     const [localFPS, setLocalFPS] = useState(0);
     const [serverFPS, setServerFPS] = useState(0);
     const [ping, setPing] = useState(0);
+    const [jitter, setJitter] = useState(0);
     const [lastPingTime, setLastPingTime] = useState(0);
 
-    // Get local FPS from Phaser
+    // Get local FPS from Phaser and the server measured latency
     useEffect(() => {
         if (!gameRef.current) return;
 
@@ -19,6 +20,12 @@ export function DebugInfo({ gameRef }: DebugInfoProps) {
             const game = gameRef.current;
             if (game) {
                 setLocalFPS(Math.round(game.loop.actualFps));
+
+                const latency = game.registry.get("latency");
+                if (latency) {
+                    setPing(Math.round(latency.smoothedRttMs));
+                    setJitter(Math.round(latency.jitterMs));
+                }
             }
         }, 500);
 
@@ -49,6 +56,8 @@ export function DebugInfo({ gameRef }: DebugInfoProps) {
             Server FPS: {serverFPS}
             <br />
             Ping: {ping}ms
+            <br />
+            Jitter: {jitter}ms
         </div>
     );
 }
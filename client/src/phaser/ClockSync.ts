@@ -0,0 +1,55 @@
+// Estimates the server's clock from clockSync exchanges, see
+// server/clock.go. Each reply gives one NTP style sample; the sample with the
+// smallest round trip of the last few is the least skewed by queueing, so
+// its offset is the one used.
+
+const MAX_SAMPLES = 8;
+
+interface ClockSample {
+    offset: number;
+    rtt: number;
+}
+
+export class ClockSync {
+    private samples: ClockSample[] = [];
+    private offset = 0;
+    private epoch = 0;
+    private tickIntervalMs = 100;
+
+    request(ws: WebSocket) {
+        ws.send(
+            JSON.stringify({
+                type: "clockSync",
+                data: { clientTime: Date.now() },
+            })
+        );
+    }
+
+    handleReply(reply: any) {
+        const received = Date.now();
+        const { clientTime, serverReceiveTime, serverSendTime } = reply;
+        this.samples.push({
+            offset:
+                (serverReceiveTime - clientTime + (serverSendTime - received)) /
+                2,
+            rtt: received - clientTime - (serverSendTime - serverReceiveTime),
+        });
+        while (this.samples.length > MAX_SAMPLES) {
+            this.samples.shift();
+        }
+        const best = this.samples.reduce((a, b) => (b.rtt < a.rtt ? b : a));
+        this.offset = best.offset;
+        this.epoch = reply.epoch;
+        this.tickIntervalMs = reply.tickIntervalMs;
+    }
+
+    // current server time in unix milliseconds
+    serverNow() {
+        return Date.now() + this.offset;
+    }
+
+    // server time a snapshot tick describes
+    tickTime(tick: number) {
+        return this.epoch + tick * this.tickIntervalMs;
+    }
+}
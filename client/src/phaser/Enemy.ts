@@ -148,15 +148,20 @@ export class EnemyManager {
             }
         }
 
-        if (hp <= 0 && this.enemies[enemyId]) {
-            enemy = this.enemies[enemyId];
-            if (enemy.bodySprite && enemy.shadowSprite && enemy.hpBar) {
-                this.pools.enemy.body.killAndHide(enemy.bodySprite);
-                this.pools.enemy.shadow.killAndHide(enemy.shadowSprite);
-                this.pools.enemy.statBar.killAndHide(enemy.hpBar);
-            }
-            delete this.enemies[enemyId];
+        if (hp <= 0) {
+            this.removeEnemy(enemyId);
+        }
+    }
+
+    removeEnemy(enemyId: string) {
+        const enemy = this.enemies[enemyId];
+        if (!enemy) return;
+        if (enemy.bodySprite && enemy.shadowSprite && enemy.hpBar) {
+            this.pools.enemy.body.killAndHide(enemy.bodySprite);
+            this.pools.enemy.shadow.killAndHide(enemy.shadowSprite);
+            this.pools.enemy.statBar.killAndHide(enemy.hpBar);
         }
+        delete this.enemies[enemyId];
     }
 
     interpolateEnemies() {
@@ -171,7 +176,7 @@ export class EnemyManager {
                 continue;
             if (enemy.positionBuffer.length === 0) continue;
 
-            const targetTime = Date.now() - 110;
+            const targetTime = (this.scene as GameScene).getServerTime() - 110;
             const buffer = enemy.positionBuffer;
 
             if (buffer.length < 2) {
@@ -5,6 +5,9 @@ import { PlayerManager } from "./Player";
 import { EnemyManager } from "./Enemy";
 import { PoolManager, PoolManager as PoolManagerType } from "./Pools";
 import { TilemapZone, ActiveZoneList } from "./interfaces";
+import { SnapshotReceiver } from "./Snapshots";
+import { ClockSync } from "./ClockSync";
+import { WIRE_SUBPROTOCOLS, decodeMsgpack } from "./Msgpack";
 
 const GAME_WIDTH = 1920;
 const GAME_HEIGHT = 1200;
@@ -12,6 +15,27 @@ const GAME_HEIGHT = 1200;
 const tileSize = 32;
 const zoneSize = 256;
 
+// must be supported by the server, see server/protocol.go
+const PROTOCOL_VERSION = 2;
+
+const CLOCK_SYNC_INTERVAL_MS = 5000;
+
+const SERVER_URL = "ws://localhost:8080/ws";
+
+// the resume token of the last welcome, kept for the tab so a dropped
+// connection or a reload gets the same session and player back, see
+// server/resume.go
+const RESUME_TOKEN_KEY = "resumeToken";
+// reconnecting waits a little longer after every attempt, the server keeps
+// a dropped session for a minute
+const RECONNECT_DELAY_MS = 1000;
+const MAX_RECONNECT_ATTEMPTS = 8;
+
+// inputs are sent once per server tick, see TickInterval in server/main.go
+const INPUT_INTERVAL_S = 0.1;
+// must match PlayerMoveSpeed in server/main.go
+const PLAYER_MOVE_SPEED = 6.22 * 32;
+
 export class GameScene extends Phaser.Scene {
     private playerManager!: PlayerManager;
     private enemyManager!: EnemyManager;
@@ -26,9 +50,20 @@ export class GameScene extends Phaser.Scene {
     };
     private tickTimer = 0;
     private isConnected = false;
+    private reconnectAttempts = 0;
+    private clockSyncTimer?: Phaser.Time.TimerEvent;
     private keyState = { W: false, A: false, S: false, D: false, SPACE: false };
     private activeZoneList!: ActiveZoneList;
     private tilemapZones: { [id: string]: TilemapZone } = {};
+    private snapshotReceiver = new SnapshotReceiver();
+    private clockSync = new ClockSync();
+    // inputs sent but not yet applied by the server, to be replayed on top of
+    // the server's position when reconciling predicted movement
+    private inputSeq = 0;
+    private pendingInputs: {
+        seq: number;
+        keys: { W: boolean; A: boolean; S: boolean; D: boolean; SPACE: boolean };
+    }[] = [];
 
     constructor() {
         super("GameScene");
@@ -108,17 +143,50 @@ export class GameScene extends Phaser.Scene {
         return this.playerManager ? this.playerManager.getLocalPlayerID() : "";
     }
 
+    // server time estimated from clockSync, used as the interpolation clock
+    getServerTime() {
+        return this.clockSync.serverNow();
+    }
+
     getPlayers() {
         return this.playerManager ? this.playerManager.getPlayers() : null;
     }
 
     startWebSocketConnection() {
-        this.ws = new WebSocket("ws://localhost:8080/ws");
+        const resumeToken = sessionStorage.getItem(RESUME_TOKEN_KEY);
+        const url = resumeToken
+            ? `${SERVER_URL}?resume=${encodeURIComponent(resumeToken)}`
+            : SERVER_URL;
+        // the server picks MessagePack, sent as binary frames, when offered
+        this.ws = new WebSocket(url, WIRE_SUBPROTOCOLS);
+        this.ws.binaryType = "arraybuffer";
+        this.ws.onerror = (e) => console.error("WebSocket error:", e);
+        this.ws.onclose = (event: CloseEvent) => {
+            console.log("WebSocket closed:", event.code, event.reason);
+            this.isConnected = false;
+            this.clockSyncTimer?.remove();
+            if (this.reconnect()) return;
+            if (this.playerManager.getLocalPlayerID()) {
+                this.playerManager.removePlayer(
+                    this.playerManager.getLocalPlayerID()
+                );
+            }
+            this.shutdownAndCleanup(); // Ensure full cleanup on close
+        };
         this.ws.onopen = () => {
             console.log("WebSocket opened");
             this.isConnected = true;
+            this.clockSync.request(this.ws);
+            this.clockSyncTimer = this.time.addEvent({
+                delay: CLOCK_SYNC_INTERVAL_MS,
+                loop: true,
+                callback: () => this.clockSync.request(this.ws),
+            });
             this.ws.onmessage = (event) => {
-                const messages = JSON.parse(event.data);
+                const messages =
+                    typeof event.data === "string"
+                        ? JSON.parse(event.data)
+                        : decodeMsgpack(event.data);
                 if (!Array.isArray(messages)) {
                     console.error(
                         "Unexpected WebSocket message format:",
@@ -132,21 +200,54 @@ export class GameScene extends Phaser.Scene {
                         case "welcome":
                             this.handleWelcome(msg.data);
                             break;
+                        case "resumeRejected":
+                            this.handleResumeRejected(msg.data);
+                            break;
                         case "activeZones":
                             this.handleActiveZoneList(msg.data);
                             break;
                         case "playerUpdate":
                             this.playerManager.addOrUpdatePlayer(msg.data);
+                            if (msg.data.lastInputSeq) {
+                                this.acknowledgeInputs(msg.data.lastInputSeq);
+                            }
                             break;
                         case "enemyUpdate":
                             this.enemyManager.addOrUpdateEnemy(msg.data);
                             break;
+                        case "snapshot":
+                            this.handleSnapshot(msg.data);
+                            break;
+                        case "entityEnter":
+                            this.handleEntityEnter(msg.data);
+                            break;
+                        case "entityLeave":
+                            this.handleEntityLeave(msg.data);
+                            break;
                         case "abilityEffect":
                             this.handleAbilityEffect(msg.data);
                             break;
                         case "telegraphWarning":
                             this.handleTelegraphWarning(msg.data);
                             break;
+                        case "teleport":
+                            this.handleTeleport(msg.data);
+                            break;
+                        case "portalLocked":
+                            console.log(
+                                `Portal ${msg.data.portal} needs level ${msg.data.requiredLevel}`
+                            );
+                            break;
+                        case "error":
+                            console.warn("Server rejected message:", msg.data);
+                            break;
+                        case "clockSync":
+                            this.clockSync.handleReply(msg.data);
+                            break;
+                        case "latency":
+                            // server measured round trip, shown in DebugInfo
+                            this.game.registry.set("latency", msg.data);
+                            break;
                         default:
                             console.log("No event handler for: ", msg);
                     }
@@ -154,21 +255,31 @@ export class GameScene extends Phaser.Scene {
                     this.enemyManager.resetRezoneBatchCounter();
                 });
             };
-            this.ws.onerror = (e) => console.error("WebSocket error:", e);
-            this.ws.onclose = (event: CloseEvent) => {
-                console.log("WebSocket closed:", event.code, event.reason);
-                if (this.playerManager.getLocalPlayerID()) {
-                    this.playerManager.removePlayer(
-                        this.playerManager.getLocalPlayerID()
-                    );
-                }
-                this.shutdownAndCleanup(); // Ensure full cleanup on close
-            };
         };
     }
 
+    // opens a new connection presenting the resume token after the last one
+    // dropped. Returns false when there is no session to get back or it has
+    // been tried for too long.
+    reconnect() {
+        if (
+            !sessionStorage.getItem(RESUME_TOKEN_KEY) ||
+            this.reconnectAttempts >= MAX_RECONNECT_ATTEMPTS
+        ) {
+            sessionStorage.removeItem(RESUME_TOKEN_KEY);
+            return false;
+        }
+        this.reconnectAttempts++;
+        console.log(`Reconnecting, attempt ${this.reconnectAttempts}`);
+        this.time.delayedCall(RECONNECT_DELAY_MS * this.reconnectAttempts, () =>
+            this.startWebSocketConnection()
+        );
+        return true;
+    }
+
     stopWebSocket() {
         if (this.ws) {
+            this.ws.onopen = null;
             this.ws.onmessage = null;
             this.ws.onclose = null;
             this.ws.onerror = null;
@@ -183,11 +294,72 @@ export class GameScene extends Phaser.Scene {
         this.ws.send(
             JSON.stringify({
                 type: "spawnPlayerCharacter",
-                data: playableCharacter,
+                data: { ...playableCharacter, protocolVersion: PROTOCOL_VERSION },
             })
         );
     }
 
+    handleSnapshot(snapshot: any) {
+        const applied = this.snapshotReceiver.apply(snapshot);
+        if (!applied) return;
+        this.ws.send(JSON.stringify({ type: "ack", data: { seq: snapshot.seq } }));
+
+        applied.players.forEach((player) =>
+            this.playerManager.addOrUpdatePlayer(player)
+        );
+        applied.enemies.forEach((enemy) =>
+            this.enemyManager.addOrUpdateEnemy(enemy)
+        );
+        // after the local player's position is updated, as it is replayed on
+        if (snapshot.lastInputSeq) {
+            this.acknowledgeInputs(snapshot.lastInputSeq);
+        }
+        // entities going out of view are removed by entityLeave
+    }
+
+    // the server only replicates entities near the local player, see
+    // server/aoi.go
+    handleEntityEnter(event: any) {
+        if (event.kind === "player") {
+            this.playerManager.addOrUpdatePlayer(event.state);
+        } else if (event.kind === "enemy") {
+            this.enemyManager.addOrUpdateEnemy(event.state);
+        }
+    }
+
+    handleEntityLeave(event: any) {
+        if (event.kind === "player") {
+            if (event.id !== this.playerManager.getLocalPlayerID()) {
+                this.playerManager.removePlayer(event.id);
+            }
+        } else if (event.kind === "enemy") {
+            this.enemyManager.removeEnemy(event.id);
+        }
+    }
+
+    // portals move the local player anywhere in the world, see
+    // server/portal.go
+    handleTeleport(event: any) {
+        this.playerManager.teleportPlayer(
+            this.getLocalPlayerID(),
+            event.x,
+            event.y
+        );
+    }
+
+    // the server applies one input per tick and acknowledges the last one
+    // applied along with the position it led to, the local player is put
+    // back there and moved by the inputs still in flight
+    acknowledgeInputs(lastInputSeq: number) {
+        this.pendingInputs = this.pendingInputs.filter(
+            (input) => input.seq > lastInputSeq
+        );
+        this.playerManager.reconcileLocalPlayer(
+            this.pendingInputs.map((input) => input.keys),
+            PLAYER_MOVE_SPEED * INPUT_INTERVAL_S
+        );
+    }
+
     handleTelegraphWarning(telegraphWarning: any) {
         const { casterId, impactX, impactY, radius, duration } =
             telegraphWarning;
@@ -217,7 +389,15 @@ export class GameScene extends Phaser.Scene {
     }
 
     handleWelcome(datum: any) {
-        const { playerId, zones } = datum;
+        const { playerId, zones, resumeToken, resumed } = datum;
+        // every welcome carries a new token, the previous one no longer works
+        sessionStorage.setItem(RESUME_TOKEN_KEY, resumeToken);
+        this.reconnectAttempts = 0;
+        if (resumed) {
+            // the player is still in the world, no need to spawn it again
+            this.game.registry.events.emit("resumed");
+        }
+
         this.playerManager.setLocalPlayerID(playerId);
         this.playerManager.addOrUpdatePlayer({ playerId, ...datum });
         let maxX = 0;
@@ -225,7 +405,10 @@ export class GameScene extends Phaser.Scene {
 
         zones.forEach((zone: any) => {
             const { id, tilemapRef, worldX, worldY } = zone;
-            this.createTilemapZone(id, tilemapRef, worldX, worldY);
+            // zones were already made for the connection being resumed
+            if (!this.tilemapZones[id]) {
+                this.createTilemapZone(id, tilemapRef, worldX, worldY);
+            }
             if (worldX > maxX) maxX = worldX;
             if (worldY > maxY) maxY = worldY;
         });
@@ -238,6 +421,19 @@ export class GameScene extends Phaser.Scene {
         );
     }
 
+    // the session expired before the reconnect, the server started a new one
+    // and the player has to spawn again
+    handleResumeRejected(datum: any) {
+        console.log("Session could not be resumed:", datum.reason);
+        sessionStorage.removeItem(RESUME_TOKEN_KEY);
+        this.reconnectAttempts = 0;
+        if (this.playerManager.getLocalPlayerID()) {
+            this.playerManager.removePlayer(
+                this.playerManager.getLocalPlayerID()
+            );
+        }
+    }
+
     handleActiveZoneList(datum: any) {
         const { currentZoneId, xAxisZoneId, yAxisZoneId, diagonalZoneId } =
             datum;
@@ -520,7 +716,7 @@ export class GameScene extends Phaser.Scene {
             this.isConnected &&
             this.playerManager.getLocalPlayerID()
         ) {
-            this.tickTimer += 0.1;
+            this.tickTimer += INPUT_INTERVAL_S;
             this.keyState = {
                 W: this.keys.W.isDown,
                 A: this.keys.A.isDown,
@@ -528,9 +724,15 @@ export class GameScene extends Phaser.Scene {
                 D: this.keys.D.isDown,
                 SPACE: this.keys.SPACE.isDown,
             };
+            this.inputSeq++;
+            this.pendingInputs.push({ seq: this.inputSeq, keys: this.keyState });
+            this.playerManager.predictLocalPlayer(
+                this.keyState,
+                PLAYER_MOVE_SPEED * INPUT_INTERVAL_S
+            );
             const message = JSON.stringify({
                 type: "input",
-                data: { keys: this.keyState },
+                data: { keys: this.keyState, seq: this.inputSeq },
             });
             try {
                 this.ws.send(message);
@@ -0,0 +1,139 @@
+// Decodes the binary frames of the MessagePack wire format, see
+// server/wire.go. Covers what the server's encoder writes: nil, booleans,
+// integers, floats, strings, arrays and maps with string keys, decoded to
+// the same values JSON.parse would give for the JSON frames. Binary data is
+// the exception: it arrives as a Uint8Array where JSON carries a base64
+// string.
+
+// offered to the server in order of preference, see server/wire.go
+export const WIRE_SUBPROTOCOLS = ["mmorpg.msgpack.v1", "mmorpg.json.v1"];
+
+const textDecoder = new TextDecoder();
+
+class MsgpackReader {
+    private view: DataView;
+    private offset = 0;
+
+    constructor(private bytes: Uint8Array) {
+        this.view = new DataView(
+            bytes.buffer,
+            bytes.byteOffset,
+            bytes.byteLength
+        );
+    }
+
+    done() {
+        return this.offset === this.bytes.length;
+    }
+
+    private advance(size: number) {
+        const at = this.offset;
+        if (at + size > this.bytes.length) {
+            throw new Error("msgpack: unexpected end of data");
+        }
+        this.offset += size;
+        return at;
+    }
+
+    private uint(size: number) {
+        const at = this.advance(size);
+        switch (size) {
+            case 1:
+                return this.view.getUint8(at);
+            case 2:
+                return this.view.getUint16(at);
+            case 4:
+                return this.view.getUint32(at);
+            default:
+                return Number(this.view.getBigUint64(at));
+        }
+    }
+
+    private string(length: number) {
+        const at = this.advance(length);
+        return textDecoder.decode(this.bytes.subarray(at, at + length));
+    }
+
+    private bin(length: number) {
+        const at = this.advance(length);
+        return this.bytes.slice(at, at + length);
+    }
+
+    private array(length: number) {
+        const array: any[] = [];
+        for (let i = 0; i < length; i++) array.push(this.read());
+        return array;
+    }
+
+    private map(length: number) {
+        const object: { [key: string]: any } = {};
+        for (let i = 0; i < length; i++) {
+            const key = this.read();
+            if (typeof key !== "string") {
+                throw new Error("msgpack: map keys must be strings");
+            }
+            object[key] = this.read();
+        }
+        return object;
+    }
+
+    read(): any {
+        const tag = this.uint(1);
+        if (tag <= 0x7f) return tag;
+        if (tag >= 0xe0) return tag - 0x100;
+        if ((tag & 0xe0) === 0xa0) return this.string(tag & 0x1f);
+        if ((tag & 0xf0) === 0x90) return this.array(tag & 0x0f);
+        if ((tag & 0xf0) === 0x80) return this.map(tag & 0x0f);
+
+        switch (tag) {
+            case 0xc0:
+                return null;
+            case 0xc2:
+                return false;
+            case 0xc3:
+                return true;
+            case 0xc4:
+            case 0xc5:
+            case 0xc6:
+                return this.bin(this.uint(1 << (tag - 0xc4)));
+            case 0xca:
+                return this.view.getFloat32(this.advance(4));
+            case 0xcb:
+                return this.view.getFloat64(this.advance(8));
+            case 0xcc:
+            case 0xcd:
+            case 0xce:
+            case 0xcf:
+                return this.uint(1 << (tag - 0xcc));
+            case 0xd0:
+                return this.view.getInt8(this.advance(1));
+            case 0xd1:
+                return this.view.getInt16(this.advance(2));
+            case 0xd2:
+                return this.view.getInt32(this.advance(4));
+            case 0xd3:
+                return Number(this.view.getBigInt64(this.advance(8)));
+            case 0xd9:
+            case 0xda:
+            case 0xdb:
+                return this.string(this.uint(1 << (tag - 0xd9)));
+            case 0xdc:
+            case 0xdd:
+                return this.array(this.uint(2 << (tag - 0xdc)));
+            case 0xde:
+            case 0xdf:
+                return this.map(this.uint(2 << (tag - 0xde)));
+        }
+        throw new Error(`msgpack: unsupported type 0x${tag.toString(16)}`);
+    }
+}
+
+// decodes a single MessagePack value filling the whole frame
+export function decodeMsgpack(frame: ArrayBuffer): any {
+    const reader = new MsgpackReader(new Uint8Array(frame));
+    const value = reader.read();
+    if (!reader.done()) {
+        throw new Error("msgpack: unexpected data after value");
+    }
+    return value;
+}
@@ -1,5 +1,6 @@
 import Phaser from "phaser";
 import { alphaFlashSprite, isOnScreen } from "./Utils";
+import { GameScene } from "./GameScene";
 
 export interface PositionUpdate {
     x: number;
@@ -9,6 +10,14 @@ export interface PositionUpdate {
     timestamp: number;
 }
 
+// the movement keys of an input, see server/protocol.go
+export interface MovementKeys {
+    W: boolean;
+    A: boolean;
+    S: boolean;
+    D: boolean;
+}
+
 export interface Player {
     bodySprite: Phaser.GameObjects.Sprite;
     flashSprite: Phaser.GameObjects.Sprite;
@@ -26,8 +35,31 @@ export interface Player {
     gameXpOnCurrentLevel: number;
     gameXpTotalForNextLevel: number;
     isDestroying?: boolean;
+    // the local player is drawn where its inputs take it rather than
+    // interpolated, from the last position the server acknowledged
+    serverPosition?: { x: number; y: number };
+    predictedPosition?: { x: number; y: number };
+}
+
+// moves a position the way the server applies an input, walls aside
+function applyKeys(
+    position: { x: number; y: number },
+    keys: MovementKeys,
+    step: number
+) {
+    let dx = 0;
+    let dy = 0;
+    if (keys.W) dy = -step;
+    if (keys.S) dy = step;
+    if (keys.A) dx = -step;
+    if (keys.D) dx = step;
+    return { x: position.x + dx, y: position.y + dy };
 }
 
+// share of the way to its predicted position the local player moves each
+// frame, smoothing out the input rate and corrections
+const PREDICTION_SMOOTHING = 0.3;
+
 export class PlayerManager {
     private scene: Phaser.Scene;
     private players: { [id: string]: Player } = {};
@@ -129,6 +161,12 @@ export class PlayerManager {
 
             if (this.players[playerId]) {
                 const player = this.players[playerId];
+                if (playerId === this.localPlayerID) {
+                    player.serverPosition = { x, y };
+                    if (!player.predictedPosition) {
+                        player.predictedPosition = { x, y };
+                    }
+                }
                 player.positionBuffer.push({ x, y, timestamp });
                 while (player.positionBuffer.length > 10) {
                     player.positionBuffer.shift();
@@ -245,13 +283,64 @@ export class PlayerManager {
         console.log(`Player ${id} fully removed`);
     }
 
+    // a teleported player jumps to where it went instead of being
+    // interpolated across the world
+    teleportPlayer(playerId: string, x: number, y: number) {
+        const player = this.players[playerId];
+        if (!player || player.isDestroying) return;
+        player.positionBuffer = [];
+        if (player.predictedPosition) {
+            player.serverPosition = { x, y };
+            player.predictedPosition = { x, y };
+        }
+        player.bodySprite.setPosition(x, y);
+        player.flashSprite.setPosition(x, y);
+        player.shadowSprite.setPosition(x, y);
+        if (this.followedPlayerID === playerId) {
+            this.scene.cameras.main.centerOn(x, y);
+        }
+    }
+
+    // moves the local player by an input as soon as it is sent
+    predictLocalPlayer(keys: MovementKeys, step: number) {
+        const player = this.players[this.localPlayerID];
+        if (!player?.predictedPosition) return;
+        player.predictedPosition = applyKeys(
+            player.predictedPosition,
+            keys,
+            step
+        );
+    }
+
+    // replays the inputs the server hasn't applied yet on top of where it
+    // says the local player is
+    reconcileLocalPlayer(pending: MovementKeys[], step: number) {
+        const player = this.players[this.localPlayerID];
+        if (!player?.serverPosition) return;
+        player.predictedPosition = pending.reduce(
+            (position, keys) => applyKeys(position, keys, step),
+            player.serverPosition
+        );
+    }
+
     interpolatePlayers() {
         for (const id in this.players) {
             const player = this.players[id];
             if (player.isDestroying) continue;
+
+            const predicted = player.predictedPosition;
+            if (id === this.localPlayerID && predicted) {
+                const { x, y } = player.bodySprite;
+                const nextX = x + (predicted.x - x) * PREDICTION_SMOOTHING;
+                const nextY = y + (predicted.y - y) * PREDICTION_SMOOTHING;
+                player.bodySprite.setPosition(nextX, nextY);
+                player.flashSprite.setPosition(nextX, nextY);
+                player.shadowSprite.setPosition(nextX, nextY);
+                continue;
+            }
             if (player.positionBuffer.length === 0) continue;
 
-            const targetTime = Date.now() - 110;
+            const targetTime = (this.scene as GameScene).getServerTime() - 110;
             const buffer = player.positionBuffer;
 
             if (buffer.length < 2) {
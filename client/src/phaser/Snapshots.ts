@@ -0,0 +1,76 @@
+// Rebuilds full entity state from the server's delta snapshots, see
+// server/delta.go. Every snapshot is applied on top of the state of its
+// baseline, so the states of recent snapshots are kept around.
+
+// must match SnapshotHistorySize in server/delta.go
+const SNAPSHOT_HISTORY_SIZE = 32;
+
+type EntityState = { [field: string]: any };
+
+export interface SnapshotState {
+    players: Map<string, EntityState>;
+    enemies: Map<string, EntityState>;
+}
+
+export interface AppliedSnapshot {
+    // full state of the entities the snapshot updated, with timestamps
+    // filled in from their zone. Distant entities are only updated every
+    // few ticks, the others in view are where they were last reported.
+    players: EntityState[];
+    enemies: EntityState[];
+}
+
+export class SnapshotReceiver {
+    private history = new Map<number, SnapshotState>();
+
+    // Returns null when the baseline is unknown, the server falls back to a
+    // complete snapshot once it stops getting acks
+    apply(snapshot: any): AppliedSnapshot | null {
+        let base: SnapshotState = { players: new Map(), enemies: new Map() };
+        if (snapshot.baseline !== 0) {
+            const known = this.history.get(snapshot.baseline);
+            if (!known) return null;
+            base = known;
+        }
+
+        const state: SnapshotState = {
+            players: new Map(base.players),
+            enemies: new Map(base.enemies),
+        };
+        for (const id of snapshot.removedPlayers ?? []) state.players.delete(id);
+        for (const id of snapshot.removedEnemies ?? []) state.enemies.delete(id);
+        for (const changes of snapshot.players ?? []) {
+            state.players.set(changes.playerId, {
+                ...state.players.get(changes.playerId),
+                ...changes,
+            });
+        }
+        for (const changes of snapshot.enemies ?? []) {
+            state.enemies.set(changes.enemyId, {
+                ...state.enemies.get(changes.enemyId),
+                ...changes,
+            });
+        }
+
+        this.history.set(snapshot.seq, state);
+        this.history.delete(snapshot.seq - SNAPSHOT_HISTORY_SIZE);
+
+        const timestamps = new Map<number, number>();
+        for (const zone of snapshot.zones) {
+            timestamps.set(zone.zoneId, zone.timestamp);
+        }
+        const withTimestamp = (entity: EntityState) => ({
+            ...entity,
+            timestamp: timestamps.get(entity.zoneId),
+        });
+
+        return {
+            players: (snapshot.players ?? []).map((changes: EntityState) =>
+                withTimestamp(state.players.get(changes.playerId)!)
+            ),
+            enemies: (snapshot.enemies ?? []).map((changes: EntityState) =>
+                withTimestamp(state.enemies.get(changes.enemyId)!)
+            ),
+        };
+    }
+}
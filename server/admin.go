@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// handleAdminSessions lists every session with its connection metadata and
+// latency. Only answers requests from the local machine.
+func (gs *GameServer) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if !isLoopbackRequest(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	sessions := gs.Sessions.All()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(infos); err != nil {
+		log.Printf("Error writing admin sessions response: %v", err)
+	}
+}
+
+// isLoopbackRequest reports whether a request came from the local machine
+func isLoopbackRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
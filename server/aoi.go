@@ -0,0 +1,220 @@
+package main
+
+import (
+	"log"
+)
+
+// Area of interest
+//
+// A client is only told about entities within InterestRadius of its player,
+// wherever they are in the world: every zone whose bounds reach into that
+// circle is queried through the spatial index of its latest snapshot, so
+// crossing a zone border changes nothing. Entities stay in view until they
+// are InterestHysteresis beyond the radius, so ones hovering at the edge
+// don't flicker in and out.
+//
+// Changes to the set of visible entities are announced with reliable
+// entityEnter and entityLeave messages ahead of the tick's entity state,
+// which only covers entities in view.
+
+// InterestRadius is how far a player sees, overridable from the command line.
+// The default comfortably covers a 1920x1200 viewport.
+var InterestRadius float32 = 1600
+
+// InterestHysteresis is how far past InterestRadius a visible entity has to
+// go before it leaves the view
+const InterestHysteresis float32 = 160
+
+// InterestCellSize is the cell size of the snapshot spatial indexes
+const InterestCellSize float32 = 512
+
+// Entity kinds in entityEnter and entityLeave messages
+const (
+	EntityKindPlayer = "player"
+	EntityKindEnemy  = "enemy"
+)
+
+// Server to client interest messages
+const (
+	MsgEntityEnter = "entityEnter"
+	MsgEntityLeave = "entityLeave"
+)
+
+// EntityEvent is the data of entityEnter and entityLeave messages
+type EntityEvent struct {
+	Kind  string      `json:"kind"`
+	ID    string      `json:"id"`
+	State interface{} `json:"state,omitempty"` // the entity's update, entityEnter only
+}
+
+// InterestView is the entity state one client sees in a tick. The updates
+// point into zone snapshots and must not be modified.
+type InterestView struct {
+	Zones   []*ZoneSnapshot
+	Players map[string]*PlayerUpdate
+	Enemies map[string]*EnemyUpdate
+}
+
+// interestSet is a connection's current view. Only used by the worker of the
+// zone owning the player.
+type interestSet struct {
+	view InterestView
+}
+
+// interestZones returns the snapshots of every zone reaching into the
+// interest circle around x, y
+func (gs *GameServer) interestZones(x, y float32) []*ZoneSnapshot {
+	radius := InterestRadius + InterestHysteresis
+	zones := make([]*ZoneSnapshot, 0, 4)
+	for _, zone := range gs.Zones {
+		if zone.distanceSquaredTo(x, y) <= radius*radius {
+			zones = append(zones, zone.Snapshot())
+		}
+	}
+	return zones
+}
+
+// update works out what a player at x, y sees in the given zones, returning
+// the new view and the enter and leave messages for the changes since the
+// view last adopted, which the caller does once the messages are queued.
+// inWorld tells players being handed between zones, who can be missing from
+// every snapshot for a tick, from players that are gone.
+func (s *interestSet) update(zones []*ZoneSnapshot, x, y float32, inWorld func(playerID string) bool) (InterestView, []Message) {
+	previous := s.view
+	view := InterestView{
+		Zones:   zones,
+		Players: make(map[string]*PlayerUpdate, len(previous.Players)),
+		Enemies: make(map[string]*EnemyUpdate, len(previous.Enemies)),
+	}
+	enterRadiusSquared := InterestRadius * InterestRadius
+	for _, zone := range zones {
+		if zone.playerGrid == nil {
+			// placeholder published before the zone's first tick
+			continue
+		}
+		zone.playerGrid.QueryRadius(x, y, InterestRadius+InterestHysteresis, func(i int, distanceSquared float32) {
+			update := &zone.Players[i]
+			if _, visible := previous.Players[update.PlayerID]; visible || distanceSquared <= enterRadiusSquared {
+				view.Players[update.PlayerID] = update
+			}
+		})
+		zone.enemyGrid.QueryRadius(x, y, InterestRadius+InterestHysteresis, func(i int, distanceSquared float32) {
+			update := &zone.Enemies[i]
+			if _, visible := previous.Enemies[update.EnemyID]; visible || distanceSquared <= enterRadiusSquared {
+				view.Enemies[update.EnemyID] = update
+			}
+		})
+	}
+
+	// keep players in transit where they were last seen
+	for id, update := range previous.Players {
+		if _, visible := view.Players[id]; !visible && !playerInSnapshots(zones, id) && inWorld(id) {
+			view.Players[id] = update
+		}
+	}
+
+	var events []Message
+	for id, update := range view.Players {
+		if _, visible := previous.Players[id]; !visible {
+			events = append(events, Message{Type: MsgEntityEnter, Data: EntityEvent{Kind: EntityKindPlayer, ID: id, State: update}})
+		}
+	}
+	for id, update := range view.Enemies {
+		if _, visible := previous.Enemies[id]; !visible {
+			events = append(events, Message{Type: MsgEntityEnter, Data: EntityEvent{Kind: EntityKindEnemy, ID: id, State: update}})
+		}
+	}
+	for id := range previous.Players {
+		if _, visible := view.Players[id]; !visible {
+			events = append(events, Message{Type: MsgEntityLeave, Data: EntityEvent{Kind: EntityKindPlayer, ID: id}})
+		}
+	}
+	for id := range previous.Enemies {
+		if _, visible := view.Enemies[id]; !visible {
+			events = append(events, Message{Type: MsgEntityLeave, Data: EntityEvent{Kind: EntityKindEnemy, ID: id}})
+		}
+	}
+
+	return view, events
+}
+
+// sendEntityState queues batch for a player's client followed by the entity
+// state in the player's view: as a delta snapshot for clients that support
+// them, as individual updates otherwise. Enter and leave messages for the
+// view go out reliably first, and distant entities only every few ticks
+// within the client's byte budget, see lod.go. Called by the zone worker
+// owning the player.
+func (gs *GameServer) sendEntityState(session *Session, player *Player, batch []Message, tick uint64) bool {
+	client := session.Client()
+	if client == nil {
+		return true
+	}
+
+	inWorld := func(playerID string) bool {
+		_, inWorld := gs.Sessions.Zone(playerID)
+		return inWorld
+	}
+	view, events := client.interest.update(gs.interestZones(player.X, player.Y), player.X, player.Y, inWorld)
+	if !client.Send(events) {
+		return false
+	}
+	client.interest.view = view
+	hold := client.replication.plan(view, session.ID, player.X, player.Y, tick, ClientByteBudget)
+
+	if session.ProtocolVersion() >= DeltaSnapshotVersion {
+		frame, held := client.delta.encode(view, hold)
+		frame.LastInputSeq = player.LastInputSeq
+		batch = append(batch, Message{Type: MsgSnapshot, Data: frame})
+		if !client.SendSnapshot(batch) {
+			return false
+		}
+		client.replication.record(view, held, tick)
+		return true
+	}
+
+	// everyone seeing a zone gets the same bytes for its updates, so they are
+	// copied from the snapshot encoded once per tick. Players in transit
+	// between zones are left where the client last saw them.
+	if own, found := view.Players[session.ID]; found {
+		update := *own
+		update.LastInputSeq = player.LastInputSeq
+		batch = append(batch, Message{Type: "playerUpdate", Data: &update})
+	}
+	encoded := client.encodedScratch[:0]
+	for _, zone := range view.Zones {
+		chunks := zone.encoded(client.Codec())
+		if chunks.err != nil {
+			log.Printf("Error encoding snapshot of Zone %d: %v", zone.ZoneID, chunks.err)
+			return false
+		}
+		for i := range zone.Players {
+			id := zone.Players[i].PlayerID
+			if view.Players[id] == &zone.Players[i] && !hold.players[id] && id != session.ID {
+				encoded = append(encoded, chunks.players[i])
+			}
+		}
+		for i := range zone.Enemies {
+			id := zone.Enemies[i].EnemyID
+			if view.Enemies[id] == &zone.Enemies[i] && !hold.enemies[id] {
+				encoded = append(encoded, chunks.enemies[i])
+			}
+		}
+	}
+	client.encodedScratch = encoded[:0]
+	if !client.SendSnapshotEncoded(batch, encoded) {
+		return false
+	}
+	client.replication.record(view, hold, tick)
+	return true
+}
+
+func playerInSnapshots(zones []*ZoneSnapshot, playerID string) bool {
+	for _, zone := range zones {
+		for i := range zone.Players {
+			if zone.Players[i].PlayerID == playerID {
+				return true
+			}
+		}
+	}
+	return false
+}
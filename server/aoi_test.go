@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestInterestEnterLeave walks a player up to another one, dithers on the
+// edge of the interest radius and walks away again, and checks the other
+// player enters and leaves the view exactly once
+func TestInterestEnterLeave(t *testing.T) {
+	zone := benchmarkZone(rand.New(rand.NewSource(1)), 1, 0, 0)
+	zone.addPlayer(NewPlayer("other", 1, 4000, 1000))
+
+	// up to the other player, back and forth across the radius on the way,
+	// and away again
+	edge := 4000 - InterestRadius
+	var path []float32
+	for x := float32(0); x <= 4000; x += 40 {
+		path = append(path, x)
+		if x == edge {
+			for i := 0; i < 10; i++ {
+				path = append(path, edge-InterestHysteresis/2, edge+InterestHysteresis/2)
+			}
+		}
+	}
+	for x := float32(4000); x >= 0; x -= 40 {
+		path = append(path, x)
+	}
+
+	var set interestSet
+	enters, leaves := 0, 0
+	inWorld := func(string) bool { return true }
+	for tick, x := range path {
+		snapshot := zone.publishSnapshot(uint64(tick+1), int64(tick+1))
+		view, events := set.update([]*ZoneSnapshot{snapshot}, x, 1000, inWorld)
+		set.view = view
+		for _, event := range events {
+			switch event.Type {
+			case MsgEntityEnter:
+				enters++
+			case MsgEntityLeave:
+				leaves++
+			}
+		}
+	}
+	if enters != 1 || leaves != 1 {
+		t.Errorf("%d enters and %d leaves, want one of each", enters, leaves)
+	}
+}
+
+// TestInterestViewAdoptedOnceQueued checks that a view whose enter messages
+// never went out is not taken as the client's, so the messages come again
+func TestInterestViewAdoptedOnceQueued(t *testing.T) {
+	zone := benchmarkZone(rand.New(rand.NewSource(1)), 1, 0, 0)
+	zone.addPlayer(NewPlayer("other", 1, 100, 100))
+	snapshot := zone.publishSnapshot(1, 1)
+	inWorld := func(string) bool { return true }
+
+	var set interestSet
+	if _, events := set.update([]*ZoneSnapshot{snapshot}, 0, 0, inWorld); len(events) != 1 {
+		t.Fatalf("%d events for a new player in view, want an enter", len(events))
+	}
+	view, events := set.update([]*ZoneSnapshot{snapshot}, 0, 0, inWorld)
+	if len(events) != 1 {
+		t.Fatalf("%d events after the enter was not queued, want it again", len(events))
+	}
+	set.view = view
+	if _, events := set.update([]*ZoneSnapshot{snapshot}, 0, 0, inWorld); len(events) != 0 {
+		t.Errorf("%d events once the view was adopted, want none", len(events))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Benchmark fixtures
+//
+// The fixtures the benchmarks next to the code they measure share. Run the
+// benchmarks with go test -bench . -benchmem, on a deployment box as well as
+// a developer machine to compare the numbers.
+
+// Size of the synthetic world the replication benchmarks encode: a player
+// sees four zones' worth of entities each tick
+const (
+	benchmarkZones          = 4
+	benchmarkPlayersPerZone = 25
+)
+
+// benchmarkWorld builds the zones one player sees, with random entities
+func benchmarkWorld(random *rand.Rand) []*Zone {
+	zones := make([]*Zone, 0, benchmarkZones)
+	for zoneID := 1; zoneID <= benchmarkZones; zoneID++ {
+		zones = append(zones, benchmarkZone(random, zoneID, benchmarkPlayersPerZone, NumEnemiesPerZone))
+	}
+	return zones
+}
+
+// benchmarkZone builds a zone with randomly placed players and enemies
+func benchmarkZone(random *rand.Rand, zoneID, players, enemies int) *Zone {
+	zone := &Zone{
+		ID:         zoneID,
+		Players:    make(map[string]*Player),
+		Enemies:    make(map[string]*Enemy),
+		PlayerGrid: NewSpatialHash[*Player](ZoneCellSize),
+		EnemyGrid:  NewSpatialHash[*Enemy](ZoneCellSize),
+	}
+	for i := 0; i < players; i++ {
+		zone.addPlayer(NewPlayer(fmt.Sprintf("session%032x", random.Int63()), zoneID,
+			random.Float32()*float32(ZoneWidthPixels), random.Float32()*float32(ZoneHeightPixels)))
+	}
+	for i := 0; i < enemies; i++ {
+		zone.addEnemy(NewEnemy(zoneID, random.Float32()*float32(ZoneWidthPixels), random.Float32()*float32(ZoneHeightPixels), "easy"))
+	}
+	return zone
+}
+
+// benchmarkSnapshots publishes a snapshot of every zone
+func benchmarkSnapshots(zones []*Zone, tick uint64) []*ZoneSnapshot {
+	clock := SimulationClock{Epoch: time.UnixMilli(1700000000000)}
+	snapshots := make([]*ZoneSnapshot, 0, len(zones))
+	for _, zone := range zones {
+		snapshots = append(snapshots, zone.publishSnapshot(tick, clock.TickTime(tick).UnixMilli()))
+	}
+	return snapshots
+}
+
+// benchmarkView is a view of every entity in the snapshots, as if the
+// interest radius covered them all
+func benchmarkView(snapshots []*ZoneSnapshot) InterestView {
+	view := InterestView{Zones: snapshots, Players: make(map[string]*PlayerUpdate), Enemies: make(map[string]*EnemyUpdate)}
+	for _, snapshot := range snapshots {
+		for i := range snapshot.Players {
+			view.Players[snapshot.Players[i].PlayerID] = &snapshot.Players[i]
+		}
+		for i := range snapshot.Enemies {
+			view.Enemies[snapshot.Enemies[i].EnemyID] = &snapshot.Enemies[i]
+		}
+	}
+	return view
+}
+
+// benchmarkTickBatch builds the snapshot batch one player receives per tick
+// in the original per-entity format
+func benchmarkTickBatch() []Message {
+	snapshots := benchmarkSnapshots(benchmarkWorld(rand.New(rand.NewSource(1))), 1)
+	batch := []Message{{Type: "activeZones", Data: ActiveZoneList{CurrentZoneID: 6, XAxisZoneID: 7, YAxisZoneID: 10, DiagonalZoneID: 11}}}
+	for _, snapshot := range snapshots {
+		for _, update := range snapshot.Players {
+			batch = append(batch, Message{Type: "playerUpdate", Data: update})
+		}
+		for _, update := range snapshot.Enemies {
+			batch = append(batch, Message{Type: "enemyUpdate", Data: update})
+		}
+	}
+	return batch
+}
+
+// benchmarkWalledWorld makes World a single zone strewn with short walls,
+// returning its size in tiles
+func benchmarkWalledWorld(random *rand.Rand) int {
+	World = WorldConfig{TilemapGrid: [][]string{{"bench"}}, TileSize: TileSize, ZoneSize: ZoneWidthPixels / TileSize}
+	size := World.ZoneSize
+	tilemap := &Tilemap{Width: size, Height: size, TileWidth: TileSize, TileHeight: TileSize, Collision: make([]bool, size*size)}
+	for i := 0; i < size*size/40; i++ {
+		x, y, length := random.Intn(size), random.Intn(size), 2+random.Intn(6)
+		for j := 0; j < length; j++ {
+			if random.Intn(2) == 0 && x+j < size {
+				tilemap.Collision[y*size+x+j] = true
+			} else if y+j < size {
+				tilemap.Collision[(y+j)*size+x] = true
+			}
+		}
+	}
+	World.Tilemaps = map[string]*Tilemap{"bench": tilemap}
+	return size
+}
+
+// benchmarkSink keeps benchmarked results from being optimised away
+var benchmarkSink int
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlowClientPolicy decides what happens when a client's send queue is full
+type SlowClientPolicy int
+
+const (
+	// DropStaleSnapshots evicts the oldest queued snapshot to make room.
+	// Reliable messages may queue ReliableHeadroom frames past the queue size,
+	// only clients that fall that far behind on them get disconnected.
+	DropStaleSnapshots SlowClientPolicy = iota
+	// DisconnectSlowClient closes the connection as soon as the queue is full
+	DisconnectSlowClient
+)
+
+// Outbound queue settings, overridable from the command line
+var (
+	SendQueueSize    = 64
+	ReliableHeadroom = 16
+	WriteWait        = 2 * time.Second
+	SlowClientAction = DropStaleSnapshots
+)
+
+// ParseSlowClientPolicy converts a command line value to a SlowClientPolicy
+func ParseSlowClientPolicy(value string) (SlowClientPolicy, error) {
+	switch value {
+	case "drop":
+		return DropStaleSnapshots, nil
+	case "disconnect":
+		return DisconnectSlowClient, nil
+	default:
+		return DropStaleSnapshots, fmt.Errorf("unknown slow client policy %q (expected drop or disconnect)", value)
+	}
+}
+
+// outboundFrame is one encoded websocket message waiting to be written
+type outboundFrame struct {
+	payload  []byte
+	snapshot bool // entity state that a newer snapshot makes obsolete
+}
+
+// Client owns a websocket connection. All writes go through a bounded queue
+// drained by a single writer goroutine, as gorilla/websocket only supports one
+// concurrent writer and a slow client must never block a zone tick.
+type Client struct {
+	SessionID string
+	conn      *websocket.Conn
+	codec     WireCodec
+
+	mu    sync.Mutex
+	queue []outboundFrame
+	wake  chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	latency latencyTracker
+
+	// entities in view, when each was last sent and the snapshots sent and
+	// acknowledged on this connection, see aoi.go, lod.go and delta.go
+	interest    interestSet
+	replication replicationSchedule
+	delta       deltaEncoder
+
+	// reused for the encoded updates of each snapshot frame
+	encodedScratch [][]byte
+}
+
+// NewClient wraps a connection and starts its writer goroutine. Frames are
+// encoded in the wire format negotiated during the upgrade.
+func NewClient(sessionID string, conn *websocket.Conn) *Client {
+	c := &Client{
+		SessionID: sessionID,
+		conn:      conn,
+		codec:     codecForSubprotocol(conn.Subprotocol()),
+		queue:     make([]outboundFrame, 0, SendQueueSize),
+		wake:      make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+	go c.writePump()
+	return c
+}
+
+// Send queues messages that must reach the client (events, welcome etc.)
+func (c *Client) Send(batch []Message) bool {
+	return c.sendBatch(batch, nil, false)
+}
+
+// SendSnapshot queues entity state which may be dropped if the client lags
+func (c *Client) SendSnapshot(batch []Message) bool {
+	return c.sendBatch(batch, nil, true)
+}
+
+// SendSnapshotEncoded queues entity state like SendSnapshot, followed by
+// messages already encoded in the client's wire format
+func (c *Client) SendSnapshotEncoded(batch []Message, encoded [][]byte) bool {
+	return c.sendBatch(batch, encoded, true)
+}
+
+func (c *Client) sendBatch(batch []Message, encoded [][]byte, snapshot bool) bool {
+	if len(batch) == 0 && len(encoded) == 0 {
+		return true
+	}
+	payload, err := c.codec.AppendBatch(getFrameBuffer(), batch, encoded)
+	if err != nil {
+		log.Printf("Error encoding batch for %s: %v", c.SessionID, err)
+		return false
+	}
+	return c.enqueue(outboundFrame{payload: payload, snapshot: snapshot})
+}
+
+// enqueue adds a frame to the send queue, applying the slow client policy
+// when the queue is full. Returns false if the frame was not queued.
+func (c *Client) enqueue(frame outboundFrame) bool {
+	c.mu.Lock()
+	if c.isClosed() {
+		c.mu.Unlock()
+		return false
+	}
+
+	limit := SendQueueSize
+	if SlowClientAction == DropStaleSnapshots && !frame.snapshot {
+		// a burst of events mustn't cost the client its connection
+		limit += ReliableHeadroom
+	}
+	if len(c.queue) >= limit {
+		switch {
+		case SlowClientAction == DropStaleSnapshots && c.evictOldestSnapshot():
+			// made room
+		case SlowClientAction == DropStaleSnapshots && frame.snapshot:
+			// nothing stale to evict, this snapshot is the one to go
+			c.mu.Unlock()
+			putFrameBuffer(frame.payload)
+			return false
+		default:
+			c.mu.Unlock()
+			putFrameBuffer(frame.payload)
+			log.Printf("Send queue full for %s, disconnecting slow client", c.SessionID)
+			c.Close()
+			return false
+		}
+	}
+
+	c.queue = append(c.queue, frame)
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// evictOldestSnapshot drops the oldest queued snapshot frame. Caller holds c.mu.
+func (c *Client) evictOldestSnapshot() bool {
+	for i, queued := range c.queue {
+		if queued.snapshot {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			putFrameBuffer(queued.payload)
+			return true
+		}
+	}
+	return false
+}
+
+// writePump is the only goroutine that writes to the connection
+func (c *Client) writePump() {
+	pingTicker := time.NewTicker(PingInterval)
+	defer pingTicker.Stop()
+	defer c.conn.Close()
+	defer c.releaseQueue()
+
+	var pending []outboundFrame
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-pingTicker.C:
+			if err := c.writePing(); err != nil {
+				log.Printf("Error pinging %s: %v", c.SessionID, err)
+				c.Close()
+				return
+			}
+			continue
+		case <-c.wake:
+		}
+
+		c.mu.Lock()
+		pending, c.queue = c.queue, pending[:0]
+		c.mu.Unlock()
+
+		for i, frame := range pending {
+			c.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if err := c.conn.WriteMessage(c.codec.FrameType(), frame.payload); err != nil {
+				log.Printf("Error writing to %s: %v", c.SessionID, err)
+				c.Close()
+				releaseFrames(pending[i:])
+				return
+			}
+			putFrameBuffer(frame.payload)
+		}
+	}
+}
+
+// releaseQueue returns the frames left queued once the writer has stopped to
+// the pool. The client is closed by then, so nothing is queued after it.
+func (c *Client) releaseQueue() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	releaseFrames(c.queue)
+	c.queue = nil
+}
+
+// releaseFrames returns the buffers of frames that won't be written
+func releaseFrames(frames []outboundFrame) {
+	for _, frame := range frames {
+		putFrameBuffer(frame.payload)
+	}
+}
+
+// Close shuts down the writer and the underlying connection. Safe to call
+// more than once and from any goroutine.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		// unblocks the reader in handleWebSocket
+		c.conn.Close()
+	})
+}
+
+// Done is closed once the client has been closed
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// AckSnapshot records the client's acknowledgement of a delta snapshot
+func (c *Client) AckSnapshot(seq uint64) bool {
+	return c.delta.ack(seq)
+}
+
+// Codec returns the wire format this client negotiated
+func (c *Client) Codec() WireCodec {
+	return c.codec
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
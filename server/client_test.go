@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient returns a client on a real connection whose queue nothing
+// drains, as if the other end had stopped reading
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &Client{
+		SessionID: "client",
+		conn:      conn,
+		codec:     jsonCodec{},
+		queue:     make([]outboundFrame, 0, SendQueueSize),
+		wake:      make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+}
+
+// TestSlowClientPolicy checks what each policy does with a frame for a full
+// send queue, and for one full past the headroom left for events
+func TestSlowClientPolicy(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+	defer func(policy SlowClientPolicy) { SlowClientAction = policy }(SlowClientAction)
+
+	tests := []struct {
+		name      string
+		policy    SlowClientPolicy
+		queued    bool // whether the queue is full of snapshots, else events
+		headroom  bool // whether the queue is ReliableHeadroom frames over full
+		snapshot  bool
+		sent      bool
+		connected bool
+	}{
+		{"drop evicts a stale snapshot", DropStaleSnapshots, true, false, true, true, true},
+		{"drop evicts a stale snapshot for an event", DropStaleSnapshots, true, false, false, true, true},
+		{"drop drops the new snapshot", DropStaleSnapshots, false, false, true, false, true},
+		{"drop queues an event in the headroom", DropStaleSnapshots, false, false, false, true, true},
+		{"drop disconnects past the headroom", DropStaleSnapshots, false, true, false, false, false},
+		{"disconnect on a snapshot", DisconnectSlowClient, true, false, true, false, false},
+		{"disconnect on an event", DisconnectSlowClient, true, false, false, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SlowClientAction = test.policy
+			client := newTestClient(t)
+			queued := SendQueueSize
+			if test.headroom {
+				queued += ReliableHeadroom
+			}
+			for i := 0; i < queued; i++ {
+				client.queue = append(client.queue, outboundFrame{payload: []byte("[]"), snapshot: test.queued})
+			}
+			if sent := client.enqueue(outboundFrame{payload: []byte("[]"), snapshot: test.snapshot}); sent != test.sent {
+				t.Errorf("frame queued: %v, want %v", sent, test.sent)
+			}
+			if connected := !client.isClosed(); connected != test.connected {
+				t.Errorf("client connected: %v, want %v", connected, test.connected)
+			}
+		})
+	}
+}
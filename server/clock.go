@@ -0,0 +1,71 @@
+package main
+
+import (
+	"time"
+)
+
+// Simulation clock
+//
+// Ticks are numbered globally from the moment the server starts: tick n is
+// due at Epoch + n*TickInterval in every zone. Zone workers wait for the next
+// tick boundary rather than running free, so snapshots from different zones
+// carrying the same tick describe the same moment even though each worker
+// finishes its tick at a slightly different time.
+//
+// Clients estimate the server's clock with clockSync requests, answered NTP
+// style with the times the request arrived and the reply left, and line up
+// snapshots from all zones on a single interpolation timeline.
+
+// SimulationClock maps between tick numbers and wall clock time
+type SimulationClock struct {
+	Epoch time.Time
+}
+
+// TickAt returns the tick in progress at t
+func (c SimulationClock) TickAt(t time.Time) uint64 {
+	if t.Before(c.Epoch) {
+		return 0
+	}
+	return uint64(t.Sub(c.Epoch) / TickInterval)
+}
+
+// TickTime returns when tick is due
+func (c SimulationClock) TickTime(tick uint64) time.Time {
+	return c.Epoch.Add(time.Duration(tick) * TickInterval)
+}
+
+// ClockSyncData is the payload of a clockSync request
+type ClockSyncData struct {
+	ClientTime float64 `json:"clientTime"` // client clock when sent, any unit the client likes
+}
+
+// ClockSyncReply is the data of the clockSync reply. Server times are unix
+// milliseconds with sub-millisecond precision.
+type ClockSyncReply struct {
+	ClientTime        float64 `json:"clientTime"` // echoed from the request
+	ServerReceiveTime float64 `json:"serverReceiveTime"`
+	ServerSendTime    float64 `json:"serverSendTime"`
+
+	// lets the client convert snapshot ticks to server time
+	Tick           uint64  `json:"tick"`
+	Epoch          int64   `json:"epoch"`
+	TickIntervalMs float64 `json:"tickIntervalMs"`
+}
+
+// handleClockSync answers a clockSync request straight from the connection's
+// reader goroutine, zone workers never see it
+func (gs *GameServer) handleClockSync(session *Session, request *ClockSyncData, receivedAt time.Time) {
+	now := time.Now()
+	session.Send([]Message{NewUnicastMessage(session.ID, MsgClockSync, ClockSyncReply{
+		ClientTime:        request.ClientTime,
+		ServerReceiveTime: unixMillis(receivedAt),
+		ServerSendTime:    unixMillis(now),
+		Tick:              gs.Clock.TickAt(now),
+		Epoch:             gs.Clock.Epoch.UnixMilli(),
+		TickIntervalMs:    float64(TickInterval) / float64(time.Millisecond),
+	})})
+}
+
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1000
+}
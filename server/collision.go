@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+)
+
+// Tile collision
+//
+// Walls come from the zones' tilemaps: every tile of a layer named in
+// CollisionLayers or with the collides layer property blocks movement, as
+// does any tile whose tileset gives it the collides property. So does
+// everything outside the world and in void zones.
+//
+// Maps are drawn one zone at a time and each is walled all around, so a wall
+// on the edge of a zone where it meets another zone is a seam and doesn't
+// block: zones join up and the walls hold at the edge of the world.
+//
+// Players and enemies collide with a small box around their feet. A move is
+// resolved one axis at a time, x then y, so an entity walking into a wall at
+// an angle slides along it instead of stopping. Collision data is read only
+// once loaded, any zone worker can test against any zone's walls.
+
+// CollisionLayers are the names of the tile layers that are walls,
+// overridable from the command line
+var CollisionLayers = []string{"border"}
+
+// Half the size of the box around an entity's feet that collides with walls
+const (
+	footprintHalfWidth  float32 = 8
+	footprintHalfHeight float32 = 4
+)
+
+// buildCollision marks the tiles that block movement
+func (t *Tilemap) buildCollision() {
+	solidGIDs := make(map[int]bool)
+	for _, tileset := range t.Tilesets {
+		for _, tile := range tileset.Tiles {
+			for _, property := range tile.Properties {
+				if collides, _ := property.Value.(bool); property.Name == "collides" && collides {
+					solidGIDs[tileset.FirstGID+tile.ID] = true
+				}
+			}
+		}
+	}
+
+	t.Collision = make([]bool, t.Width*t.Height)
+	t.EachLayer(func(layer *TilemapLayer) {
+		if layer.Type != "tilelayer" || layer.Width != t.Width || layer.Height != t.Height {
+			return
+		}
+		wall := layer.BoolProperty("collides")
+		for _, name := range CollisionLayers {
+			wall = wall || layer.Name == name
+		}
+		for i, gid := range layer.Data {
+			gid &= tileGIDMask
+			if gid != 0 && (wall || solidGIDs[gid]) {
+				t.Collision[i] = true
+			}
+		}
+	})
+}
+
+// zoneTilemapAt returns the tilemap of the zone at a grid position, and
+// false if there is no zone there. The tilemap is nil for zones whose maps
+// aren't loaded, they have no walls.
+func zoneTilemapAt(gridX, gridY int) (*Tilemap, bool) {
+	if gridY < 0 || gridY >= len(World.TilemapGrid) || gridX < 0 || gridX >= len(World.TilemapGrid[gridY]) {
+		return nil, false
+	}
+	ref := World.TilemapGrid[gridY][gridX]
+	if IsEmptyTilemapGridName(ref) {
+		return nil, false
+	}
+	return World.Tilemaps[ref], true
+}
+
+// solidTile reports whether the world tile at column x, row y blocks
+// movement
+func solidTile(x, y int) bool {
+	size := World.ZoneSize
+	if x < 0 || y < 0 || size == 0 {
+		return true
+	}
+	gridX, gridY := x/size, y/size
+	tilemap, exists := zoneTilemapAt(gridX, gridY)
+	if !exists {
+		return true
+	}
+	localX, localY := x%size, y%size
+	if tilemap == nil || !tilemap.Collision[localY*tilemap.Width+localX] {
+		return false
+	}
+
+	// walls on a seam with another zone are open
+	seam := func(dx, dy int) bool {
+		_, exists := zoneTilemapAt(gridX+dx, gridY+dy)
+		return exists
+	}
+	return !(localX == 0 && seam(-1, 0) || localX == size-1 && seam(1, 0) ||
+		localY == 0 && seam(0, -1) || localY == size-1 && seam(0, 1))
+}
+
+// footprintBlocked reports whether the feet of an entity at x, y touch a
+// wall
+func footprintBlocked(x, y float32) bool {
+	tile := float32(TileSize)
+	minX := int(math.Floor(float64((x - footprintHalfWidth) / tile)))
+	maxX := int(math.Floor(float64((x + footprintHalfWidth) / tile)))
+	minY := int(math.Floor(float64((y - footprintHalfHeight) / tile)))
+	maxY := int(math.Floor(float64((y + footprintHalfHeight) / tile)))
+	for ty := minY; ty <= maxY; ty++ {
+		for tx := minX; tx <= maxX; tx++ {
+			if solidTile(tx, ty) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// moveAndSlide moves an entity at x, y by dx, dy, stopping it against the
+// walls it runs into on each axis. Returns the new position and whether the
+// move was blocked along x and along y. Entities already stuck in a wall
+// move freely until they are out of it.
+func moveAndSlide(x, y, dx, dy float32) (newX, newY float32, blockedX, blockedY bool) {
+	// steps of at most half a tile can't jump over a wall
+	maxStep := float32(TileSize) / 2
+	steps := int(math.Ceil(float64(max(abs32(dx), abs32(dy)) / maxStep)))
+	if steps == 0 {
+		return x, y, false, false
+	}
+	stepX, stepY := dx/float32(steps), dy/float32(steps)
+	tile := float32(TileSize)
+	const gap = 0.01 // keeps a snapped footprint out of the wall's tile
+
+	for i := 0; i < steps; i++ {
+		stuck := footprintBlocked(x, y)
+		if stepX != 0 && !blockedX {
+			if stuck || !footprintBlocked(x+stepX, y) {
+				x += stepX
+			} else {
+				// stop against the side of the tile the footprint went into
+				if stepX > 0 {
+					x = float32(math.Floor(float64((x+stepX+footprintHalfWidth)/tile)))*tile - footprintHalfWidth - gap
+				} else {
+					x = float32(math.Floor(float64((x+stepX-footprintHalfWidth)/tile))+1)*tile + footprintHalfWidth + gap
+				}
+				blockedX = true
+			}
+		}
+		if stepY != 0 && !blockedY {
+			if stuck || !footprintBlocked(x, y+stepY) {
+				y += stepY
+			} else {
+				if stepY > 0 {
+					y = float32(math.Floor(float64((y+stepY+footprintHalfHeight)/tile)))*tile - footprintHalfHeight - gap
+				} else {
+					y = float32(math.Floor(float64((y+stepY-footprintHalfHeight)/tile))+1)*tile + footprintHalfHeight + gap
+				}
+				blockedY = true
+			}
+		}
+	}
+	return x, y, blockedX, blockedY
+}
+
+func abs32(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
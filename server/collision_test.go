@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// borderTilemap is a zone sized map walled all around by a border layer,
+// plus the given wall tiles inside
+func borderTilemap(walls ...TilePosition) *Tilemap {
+	size := World.ZoneSize
+	data := make([]int, size*size)
+	for i := range data {
+		if x, y := i%size, i/size; x == 0 || y == 0 || x == size-1 || y == size-1 {
+			data[i] = 1
+		}
+	}
+	for _, wall := range walls {
+		data[wall.Y*size+wall.X] = 1
+	}
+	tilemap := &Tilemap{Width: size, Height: size, TileWidth: TileSize, TileHeight: TileSize,
+		Layers: []TilemapLayer{{Name: "border", Type: "tilelayer", Width: size, Height: size, Data: data}}}
+	tilemap.buildCollision()
+	return tilemap
+}
+
+// TestSolidTileSeams checks that walls between two zones open up and the
+// ones on the edge of the world hold
+func TestSolidTileSeams(t *testing.T) {
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	tilemaps["a"], tilemaps["b"] = borderTilemap(TilePosition{X: 3, Y: 3}), borderTilemap()
+
+	for _, test := range []struct {
+		x, y  int
+		solid bool
+	}{
+		{0, 3, true},  // a's outer wall
+		{7, 3, false}, // a's wall on the seam with b
+		{8, 3, false}, // b's wall on the seam with a
+		{15, 3, true}, // b's outer wall
+		{3, 0, true},  // top of the world
+		{3, 7, true},  // bottom of the world
+		{3, 3, true},  // inside a
+		{4, 4, false}, // open ground
+		{-1, 3, true}, // off the world
+		{16, 3, true}, // off the world
+		{3, 8, true},  // off the world
+	} {
+		if got := solidTile(test.x, test.y); got != test.solid {
+			t.Errorf("tile %d,%d solid %v, want %v", test.x, test.y, got, test.solid)
+		}
+	}
+}
+
+// TestMoveAndSlide checks that moves stop against walls on the axis that
+// hits them and carry on along the other
+func TestMoveAndSlide(t *testing.T) {
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	tilemaps["a"], tilemaps["b"] = borderTilemap(TilePosition{X: 5, Y: 4}), borderTilemap()
+	const gap = 0.01
+
+	for _, test := range []struct {
+		name               string
+		x, y, dx, dy       float32
+		wantX, wantY       float32
+		blockedX, blockedY bool
+	}{
+		{"open ground", 100, 100, 20, 10, 120, 110, false, false},
+		{"into the left wall", 60, 100, -40, 0, 32 + footprintHalfWidth + gap, 100, true, false},
+		{"sliding along the top wall", 100, 50, 30, -40, 130, 32 + footprintHalfHeight + gap, false, true},
+		{"over the seam", 230, 100, 60, 0, 290, 100, false, false},
+		{"into the far wall", 460, 100, 40, 0, 480 - footprintHalfWidth - gap, 100, true, false},
+		{"no tunnelling through a wall", 100, 144, 200, 0, 160 - footprintHalfWidth - gap, 144, true, false},
+	} {
+		x, y, blockedX, blockedY := moveAndSlide(test.x, test.y, test.dx, test.dy)
+		if math.Abs(float64(x-test.wantX)) > 1e-3 || math.Abs(float64(y-test.wantY)) > 1e-3 || blockedX != test.blockedX || blockedY != test.blockedY {
+			t.Errorf("%s: ended at %g,%g blocked %v,%v, want %g,%g blocked %v,%v",
+				test.name, x, y, blockedX, blockedY, test.wantX, test.wantY, test.blockedX, test.blockedY)
+		}
+	}
+}
@@ -68,47 +68,27 @@ func (cs *ColossalSweep) Execute(caster Entity, gs *GameServer, zone *Zone) []Me
     casterX := caster.GetX()
     casterY := caster.GetY() - caster.GetSpriteHeightPixels()/2
 
-    messages = append(messages, Message{
-        Type: "abilityEffect",
-        Data: map[string]interface{}{
+    messages = append(messages, NewAreaMessage(zone.ID, casterX, casterY, EffectBroadcastRadius,
+        "abilityEffect",
+        map[string]interface{}{
             "ability":  "ColossalSweep",
             "casterId": caster.GetID(),
             "impactX":  casterX,
             "impactY":  casterY,
             "radius":   cs.Radius,
         },
+    ))
+
+    gs.dealAreaDamage(zone, AreaHit{
+        CasterID:   caster.GetID(),
+        X:          casterX,
+        Y:          casterY,
+        Radius:     cs.Radius,
+        TargetType: cs.TargetType,
+        Damage:     cs.Damage,
+        Colliders:  true,
     })
 
-    if cs.TargetType == "player" || cs.TargetType == "all" {
-        for _, player := range zone.Players {
-            offsetY := player.GetSpriteHeightPixels() / 2
-            playerColliderRadius := player.GetSpriteHeightPixels() / 2 * 1
-            testDistanceSq := (cs.Radius + playerColliderRadius) * (cs.Radius + playerColliderRadius)
-
-            dx := player.GetX() - casterX
-            dy := (player.GetY() - offsetY) - casterY
-            distSq := float32(dx*dx + dy*dy)
-            if distSq <= testDistanceSq && player.GetID() != caster.GetID() {
-                player.GetStats().HP -= cs.Damage
-            }
-        }
-    }
-
-    if cs.TargetType == "enemy" || cs.TargetType == "all" {
-        for _, enemy := range zone.Enemies {
-            offsetY := enemy.GetSpriteHeightPixels() / 2
-            enemyColliderRadius := enemy.GetSpriteHeightPixels() / 2 * 1.2
-            testDistanceSq := (cs.Radius + enemyColliderRadius) * (cs.Radius + enemyColliderRadius)
-
-            dx := enemy.GetX() - casterX
-            dy := (enemy.GetY() - offsetY) - casterY
-            distSq := float32(dx*dx + dy*dy)
-            if distSq <= testDistanceSq && enemy.GetID() != caster.GetID() {
-                enemy.GetStats().HP -= cs.Damage
-            }
-        }
-    }
-
     return messages
 }
 
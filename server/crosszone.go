@@ -0,0 +1,154 @@
+package main
+
+// Cross-zone effects
+//
+// Zones are only borders on the map: an enemy sees players over the border
+// and a swing next to it hits whoever stands on the other side. Whatever
+// reaches past a zone's bounds looks into the neighbours it touches.
+//
+// Reading a neighbour goes through its latest snapshot, as always. Changing
+// one can't, entities belong to their zone's worker: damage is described as
+// an AreaHit, applied on the spot in the caster's zone and queued on the
+// Control channel of every neighbour it reaches, whose worker applies it the
+// same way to its own entities at the start of its next tick. XP for the
+// players around a dying enemy goes the same way, to whichever zone owns
+// each of them by then, and so do area events such as telegraphs, which the
+// neighbours route to their own players a tick later.
+
+// AreaHit is damage dealt to every target within a circle
+type AreaHit struct {
+	CasterID   string
+	X, Y       float32
+	Radius     float32
+	TargetType string // "player", "enemy" or "all"
+	Damage     int
+
+	// test against the targets' colliders, half a sprite up from their
+	// feet, instead of their positions
+	Colliders bool
+}
+
+// distanceSquaredTo returns the squared distance from x, y to the closest
+// point of the zone
+func (z *Zone) distanceSquaredTo(x, y float32) float32 {
+	dx := max(z.WorldX-x, 0, x-(z.WorldX+float32(ZoneWidthPixels)))
+	dy := max(z.WorldY-y, 0, y-(z.WorldY+float32(ZoneHeightPixels)))
+	return dx*dx + dy*dy
+}
+
+// neighboursReaching calls visit for every neighbour of zone within radius
+// of x, y
+func (gs *GameServer) neighboursReaching(zone *Zone, x, y, radius float32, visit func(neighbour *Zone)) {
+	for _, id := range zone.Neighbors {
+		neighbour := gs.Zones[id]
+		if neighbour != nil && neighbour.distanceSquaredTo(x, y) <= radius*radius {
+			visit(neighbour)
+		}
+	}
+}
+
+// remotePlayersWithin calls visit for every player in the neighbours of zone
+// within radius of x, y, as of their last snapshot. Players that have just
+// been handed to zone are only visited there.
+func (gs *GameServer) remotePlayersWithin(zone *Zone, x, y, radius float32, visit func(update *PlayerUpdate, distanceSquared float32)) {
+	gs.neighboursReaching(zone, x, y, radius, func(neighbour *Zone) {
+		snapshot := neighbour.Snapshot()
+		if snapshot.playerGrid == nil {
+			return
+		}
+		snapshot.playerGrid.QueryRadius(x, y, radius, func(i int, distanceSquared float32) {
+			if _, local := zone.Players[snapshot.Players[i].PlayerID]; !local {
+				visit(&snapshot.Players[i], distanceSquared)
+			}
+		})
+	})
+}
+
+// remoteEnemiesWithin calls visit for every enemy in the neighbours of zone
+// within radius of x, y, as of their last snapshot
+func (gs *GameServer) remoteEnemiesWithin(zone *Zone, x, y, radius float32, visit func(update *EnemyUpdate, distanceSquared float32)) {
+	gs.neighboursReaching(zone, x, y, radius, func(neighbour *Zone) {
+		snapshot := neighbour.Snapshot()
+		if snapshot.enemyGrid == nil {
+			return
+		}
+		snapshot.enemyGrid.QueryRadius(x, y, radius, func(i int, distanceSquared float32) {
+			visit(&snapshot.Enemies[i], distanceSquared)
+		})
+	})
+}
+
+// dealAreaDamage applies hit in zone and hands it to every neighbour it
+// reaches. Called by the zone's worker.
+func (gs *GameServer) dealAreaDamage(zone *Zone, hit AreaHit) {
+	zone.applyAreaHit(hit)
+	gs.neighboursReaching(zone, hit.X, hit.Y, hit.reach(), func(neighbour *Zone) {
+		sendZoneCommand(neighbour, ZoneCommand{Type: ZoneAreaHit, FromZoneID: zone.ID, Hit: &hit})
+	})
+}
+
+// shareAreaMessages hands the area events zone raised this tick to every
+// neighbour they reach. Events shared by a neighbour are not passed on.
+// Called by the zone's worker.
+func (gs *GameServer) shareAreaMessages(zone *Zone, pending []Message) {
+	for i := range pending {
+		msg := pending[i]
+		if msg.Scope != ScopeArea || msg.ZoneID != zone.ID {
+			continue
+		}
+		gs.neighboursReaching(zone, msg.X, msg.Y, msg.Radius, func(neighbour *Zone) {
+			sendZoneCommand(neighbour, ZoneCommand{Type: ZoneAreaMessage, FromZoneID: zone.ID, Message: &msg})
+		})
+	}
+}
+
+// awardXP grants XP to a player of another zone, through the worker of
+// the zone that owns the player
+func (gs *GameServer) awardXP(playerID string, xp int) {
+	zoneID, exists := gs.Sessions.Zone(playerID)
+	if !exists {
+		return
+	}
+	if zone := gs.Zones[zoneID]; zone != nil {
+		sendZoneCommand(zone, ZoneCommand{Type: ZoneAwardXP, PlayerID: playerID, XP: xp})
+	}
+}
+
+// reach is how far from its centre hit can find a target's position
+func (hit AreaHit) reach() float32 {
+	if hit.Colliders {
+		return hit.Radius + MaxColliderReach
+	}
+	return hit.Radius
+}
+
+// applyAreaHit damages the zone's own entities caught by hit. Called by the
+// zone's worker.
+func (z *Zone) applyAreaHit(hit AreaHit) {
+	// tests whether a target of the given sprite height and collider scale
+	// at x, y is caught
+	caught := func(x, y, spriteHeight, colliderScale float32) bool {
+		radius := hit.Radius
+		if hit.Colliders {
+			y -= spriteHeight / 2
+			radius += spriteHeight / 2 * colliderScale
+		}
+		dx, dy := x-hit.X, y-hit.Y
+		return dx*dx+dy*dy <= radius*radius
+	}
+
+	if hit.TargetType == "player" || hit.TargetType == "all" {
+		z.playersWithin(hit.X, hit.Y, hit.reach(), func(player *Player, _ float32) {
+			if player.ID != hit.CasterID && caught(player.X, player.Y, player.SpriteHeightPixels, 1) {
+				player.Stats.HP -= hit.Damage
+			}
+		})
+	}
+	if hit.TargetType == "enemy" || hit.TargetType == "all" {
+		z.enemiesWithin(hit.X, hit.Y, hit.reach(), func(enemy *Enemy, _ float32) {
+			if enemy.ID != hit.CasterID && caught(enemy.X, enemy.Y, enemy.SpriteHeightPixels, 1.2) {
+				enemy.Stats.HP -= hit.Damage
+			}
+		})
+	}
+}
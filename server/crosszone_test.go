@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+// TestDeathXPCrossesBorder checks that an enemy dying next to a border gives
+// its XP to the players on both sides, each once and through its own zone
+func TestDeathXPCrossesBorder(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	tilemaps["a"], tilemaps["b"] = openTilemap(), openTilemap()
+	NumEnemiesPerZone = 0
+	gs := NewGameServer()
+	home, over := gs.Zones[1], gs.Zones[2]
+
+	place := func(id string, zone *Zone, x float32) *Player {
+		addTestSession(gs, id)
+		player := gs.CreatePlayer(id)
+		player.X, player.Y, player.ZoneID = x, 48, zone.ID
+		sendZoneCommand(zone, ZoneCommand{Type: ZoneJoin, PlayerID: id, Player: player})
+		gs.Sessions.SetZone(id, zone.ID)
+		gs.processZoneCommands(zone)
+		return player
+	}
+	local := place("local", home, over.WorldX-64)
+	remote := place("remote", over, over.WorldX+16)
+	over.publishSnapshot(1, 0)
+	localXP, remoteXP := local.GameXP, remote.GameXP
+
+	enemy := NewEnemy(home.ID, over.WorldX-16, 48, "easy")
+	home.addEnemy(enemy)
+	enemy.ChangeState("Death", 0)
+	if _, keep := enemy.UpdateEnemy(gs, home); keep {
+		t.Fatal("the enemy didn't die")
+	}
+	gs.processZoneCommands(home)
+	gs.processZoneCommands(over)
+
+	if got := local.GameXP - localXP; got != 10 {
+		t.Errorf("the player in the enemy's zone got %d XP, want 10", got)
+	}
+	if got := remote.GameXP - remoteXP; got != 10 {
+		t.Errorf("the player over the border got %d XP, want 10", got)
+	}
+}
+
+// TestTelegraphCrossesBorder checks that a telegraph raised next to a border
+// reaches the player on the other side through that player's zone, and is
+// not passed back
+func TestTelegraphCrossesBorder(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	tilemaps["a"], tilemaps["b"] = openTilemap(), openTilemap()
+	NumEnemiesPerZone = 0
+	gs := NewGameServer()
+	home, over := gs.Zones[1], gs.Zones[2]
+
+	session := addTestSession(gs, "remote")
+	player := gs.CreatePlayer("remote")
+	player.X, player.Y, player.ZoneID = over.WorldX+16, 48, over.ID
+	sendZoneCommand(over, ZoneCommand{Type: ZoneJoin, PlayerID: "remote", Player: player})
+	gs.Sessions.SetZone("remote", over.ID)
+	gs.processZoneCommands(over)
+
+	telegraph := NewAreaMessage(home.ID, over.WorldX-16, 48, 64, "telegraphWarning", map[string]interface{}{"ability": "Fireball"})
+	gs.shareAreaMessages(home, []Message{telegraph})
+	gs.processZone(over, 1)
+
+	received := 0
+	for _, msg := range session.backlog {
+		if msg.Type == "telegraphWarning" {
+			received++
+		}
+	}
+	if received != 1 {
+		t.Errorf("the player over the border got %d telegraphs, want 1", received)
+	}
+	if bounced := gs.processZoneCommands(home); len(bounced) != 0 {
+		t.Errorf("the telegraph came back to its zone: %v", bounced)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import "sync/atomic"
+
+// Delta snapshots
+//
+// Clients speaking DeltaSnapshotVersion or later get their entity state as a
+// single numbered "snapshot" message per tick instead of one playerUpdate or
+// enemyUpdate per entity. They acknowledge every snapshot they receive with an
+// "ack" message; each new snapshot only carries the fields that changed since
+// the most recently acknowledged one (the baseline), plus the IDs of entities
+// that have gone since. A snapshot with baseline 0 is complete.
+//
+// The client rebuilds full state by applying a snapshot on top of its copy of
+// the baseline, so it has to keep the last SnapshotHistorySize states it
+// received. If acks stop arriving for longer than that, the server falls back
+// to a complete snapshot.
+
+// DeltaSnapshotVersion is the first protocol version receiving delta snapshots
+const DeltaSnapshotVersion = 2
+
+// SnapshotHistorySize is how many sent snapshots can serve as a baseline,
+// at 10 ticks a second enough to ride out a few seconds of missing acks
+const SnapshotHistorySize = 32
+
+// DeltaSnapshot is the data of a "snapshot" message
+type DeltaSnapshot struct {
+	Seq      uint64      `json:"seq"`
+	Baseline uint64      `json:"baseline"` // 0 when every entity is sent in full
+	Zones    []DeltaZone `json:"zones"`
+
+	// last input applied to the receiving client's own player, not diffed
+	LastInputSeq uint64 `json:"lastInputSeq,omitempty"`
+
+	// changed fields of new or updated entities, always including their ID
+	Players []map[string]interface{} `json:"players,omitempty"`
+	Enemies []map[string]interface{} `json:"enemies,omitempty"`
+
+	// entities in the baseline that no longer exist or are out of view, also
+	// announced with entityLeave
+	RemovedPlayers []string `json:"removedPlayers,omitempty"`
+	RemovedEnemies []string `json:"removedEnemies,omitempty"`
+}
+
+// DeltaZone is the tick of one zone's state in a snapshot. Entity ticks and
+// timestamps are not diffed, clients take them from their zone.
+type DeltaZone struct {
+	ZoneID    int    `json:"zoneId"`
+	Tick      uint64 `json:"tick"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// sentSnapshot is what a client was sent under one sequence number
+type sentSnapshot struct {
+	seq  uint64
+	view InterestView
+}
+
+// deltaEncoder tracks a connection's sent and acknowledged snapshots. encode
+// is only called by the worker of the zone owning the player; acks arrive on
+// the connection's reader goroutine.
+type deltaEncoder struct {
+	seq     uint64
+	history [SnapshotHistorySize]sentSnapshot
+
+	sent  atomic.Uint64
+	acked atomic.Uint64
+}
+
+// ack records that the client received snapshot seq. Returns false for
+// snapshots that were never sent.
+func (e *deltaEncoder) ack(seq uint64) bool {
+	if seq == 0 || seq > e.sent.Load() {
+		return false
+	}
+	for {
+		acked := e.acked.Load()
+		// acks may arrive out of order, only ever move forward
+		if seq <= acked || e.acked.CompareAndSwap(acked, seq) {
+			return true
+		}
+	}
+}
+
+// baseline returns the acknowledged snapshot to diff against, or nil if there
+// is none or it has fallen out of the history
+func (e *deltaEncoder) baseline() *sentSnapshot {
+	acked := e.acked.Load()
+	if acked == 0 {
+		return nil
+	}
+	entry := &e.history[acked%SnapshotHistorySize]
+	if entry.seq != acked {
+		return nil
+	}
+	return entry
+}
+
+// encode builds the next snapshot for a client with the given view, leaving
+// out the held entities the client already has, see lod.go. Also returns the
+// entities actually held back: without a baseline every entity goes out.
+func (e *deltaEncoder) encode(view InterestView, hold replicationHold) (DeltaSnapshot, replicationHold) {
+	e.seq++
+	frame := DeltaSnapshot{Seq: e.seq, Zones: make([]DeltaZone, 0, len(view.Zones))}
+	base := e.baseline()
+	if base != nil {
+		frame.Baseline = base.seq
+		view, hold = view.holding(hold, base.view)
+	} else {
+		hold = replicationHold{}
+	}
+
+	for _, zone := range view.Zones {
+		frame.Zones = append(frame.Zones, DeltaZone{ZoneID: zone.ZoneID, Tick: zone.Tick, Timestamp: zone.Timestamp})
+	}
+	for id, update := range view.Players {
+		var previous *PlayerUpdate
+		if base != nil {
+			if previous = base.view.Players[id]; previous == update {
+				continue
+			}
+		}
+		if changes := diffPlayer(update, previous); changes != nil {
+			frame.Players = append(frame.Players, changes)
+		}
+	}
+	for id, update := range view.Enemies {
+		var previous *EnemyUpdate
+		if base != nil {
+			if previous = base.view.Enemies[id]; previous == update {
+				continue
+			}
+		}
+		if changes := diffEnemy(update, previous); changes != nil {
+			frame.Enemies = append(frame.Enemies, changes)
+		}
+	}
+
+	if base != nil {
+		for id := range base.view.Players {
+			if _, found := view.Players[id]; !found {
+				frame.RemovedPlayers = append(frame.RemovedPlayers, id)
+			}
+		}
+		for id := range base.view.Enemies {
+			if _, found := view.Enemies[id]; !found {
+				frame.RemovedEnemies = append(frame.RemovedEnemies, id)
+			}
+		}
+	}
+
+	e.history[e.seq%SnapshotHistorySize] = sentSnapshot{seq: e.seq, view: view}
+	e.sent.Store(e.seq)
+	return frame, hold
+}
+
+// diffPlayer returns the wire fields of current that differ from baseline,
+// plus its ID, or nil if nothing changed. A nil baseline means the client
+// has never seen the player, so every field is included. Ticks and
+// timestamps come with the zone.
+func diffPlayer(current, baseline *PlayerUpdate) map[string]interface{} {
+	d := entityDiff{full: baseline == nil}
+	if d.full {
+		baseline = &PlayerUpdate{}
+	}
+	diffField(&d, "x", current.X, baseline.X)
+	diffField(&d, "y", current.Y, baseline.Y)
+	diffField(&d, "zoneId", current.ZoneID, baseline.ZoneID)
+	diffField(&d, "species", current.Species, baseline.Species)
+	diffField(&d, "speciesId", current.SpeciesID, baseline.SpeciesID)
+	diffField(&d, "direction", current.Direction, baseline.Direction)
+	diffField(&d, "maxHp", current.MaxHP, baseline.MaxHP)
+	diffField(&d, "hp", current.HP, baseline.HP)
+	diffField(&d, "maxAp", current.MaxAP, baseline.MaxAP)
+	diffField(&d, "ap", current.AP, baseline.AP)
+	diffField(&d, "gameXp", current.GameXP, baseline.GameXP)
+	diffField(&d, "gameLevel", current.GameLevel, baseline.GameLevel)
+	diffField(&d, "gameXpOnCurrentLevel", current.GameXPOnCurrentLevel, baseline.GameXPOnCurrentLevel)
+	diffField(&d, "gameXpTotalForNextLevel", current.GameXPTotalForNextLevel, baseline.GameXPTotalForNextLevel)
+	diffOmitEmpty(&d, "lastInputSeq", current.LastInputSeq, baseline.LastInputSeq)
+	return d.result("playerId", current.PlayerID)
+}
+
+// diffEnemy is diffPlayer for enemies
+func diffEnemy(current, baseline *EnemyUpdate) map[string]interface{} {
+	d := entityDiff{full: baseline == nil}
+	if d.full {
+		baseline = &EnemyUpdate{}
+	}
+	diffField(&d, "x", current.X, baseline.X)
+	diffField(&d, "y", current.Y, baseline.Y)
+	diffField(&d, "zoneId", current.ZoneID, baseline.ZoneID)
+	diffField(&d, "type", current.Type, baseline.Type)
+	diffField(&d, "direction", current.Direction, baseline.Direction)
+	diffField(&d, "maxHp", current.MaxHP, baseline.MaxHP)
+	diffField(&d, "hp", current.HP, baseline.HP)
+	return d.result("enemyId", current.EnemyID)
+}
+
+// entityDiff collects the changed wire fields of one entity update
+type entityDiff struct {
+	full    bool // the client has never seen the entity
+	changes map[string]interface{}
+}
+
+func diffField[T comparable](d *entityDiff, name string, current, baseline T) {
+	if !d.full && current == baseline {
+		return
+	}
+	if d.changes == nil {
+		d.changes = make(map[string]interface{}, 4)
+	}
+	d.changes[name] = current
+}
+
+// diffOmitEmpty is diffField for omitempty fields, left out of full updates
+// when zero as encoding/json leaves them out
+func diffOmitEmpty[T comparable](d *entityDiff, name string, current, baseline T) {
+	var zero T
+	if d.full && current == zero {
+		return
+	}
+	diffField(d, name, current, baseline)
+}
+
+func (d *entityDiff) result(idField, id string) map[string]interface{} {
+	if d.changes == nil {
+		if !d.full {
+			return nil
+		}
+		d.changes = make(map[string]interface{}, 1)
+	}
+	d.changes[idField] = id
+	return d.changes
+}
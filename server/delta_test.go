@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// BenchmarkDeltaSnapshot compares the wire formats for delta snapshots, see
+// benchmarkDeltaSnapshot
+func BenchmarkDeltaSnapshot(b *testing.B) {
+	b.Run("json", func(b *testing.B) { benchmarkDeltaSnapshot(b, jsonCodec{}) })
+	b.Run("msgpack", func(b *testing.B) { benchmarkDeltaSnapshot(b, msgpackCodec{}) })
+}
+
+// benchmarkDeltaMovers is the share of enemies that move between two ticks,
+// the rest of the crowd is idle
+const benchmarkDeltaMovers = 0.1
+
+// benchmarkDeltaSnapshot measures building and encoding a delta snapshot for
+// a client acknowledging every tick. Compare bytes/tick with WireEncode.
+func benchmarkDeltaSnapshot(b *testing.B, codec WireCodec) {
+	random := rand.New(rand.NewSource(1))
+	zones := benchmarkWorld(random)
+	ticks := [2][]*ZoneSnapshot{benchmarkSnapshots(zones, 1)}
+	for _, zone := range zones {
+		for _, enemy := range zone.Enemies {
+			if random.Float32() < benchmarkDeltaMovers {
+				enemy.X += enemy.VX * float32(TickInterval.Seconds())
+				enemy.Y += enemy.VY * float32(TickInterval.Seconds())
+			}
+		}
+	}
+	ticks[1] = benchmarkSnapshots(zones, 2)
+
+	views := [2]InterestView{benchmarkView(ticks[0]), benchmarkView(ticks[1])}
+
+	var encoder deltaEncoder
+	encoder.encode(views[0], replicationHold{})
+	encoder.ack(1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var payload []byte
+	for i := 0; i < b.N; i++ {
+		frame, _ := encoder.encode(views[(i+1)%2], replicationHold{})
+		var err error
+		if payload, err = codec.EncodeBatch([]Message{{Type: MsgSnapshot, Data: frame}}); err != nil {
+			b.Fatal(err)
+		}
+		encoder.ack(frame.Seq)
+	}
+	b.ReportMetric(float64(len(payload)), "bytes/tick")
+}
+
+// deltaTestView returns a single zone view holding the given entities
+func deltaTestView(tick uint64, players []*PlayerUpdate, enemies []*EnemyUpdate) InterestView {
+	view := InterestView{
+		Zones:   []*ZoneSnapshot{{ZoneID: 1, Tick: tick, Timestamp: int64(tick) * 100}},
+		Players: make(map[string]*PlayerUpdate),
+		Enemies: make(map[string]*EnemyUpdate),
+	}
+	for _, player := range players {
+		view.Players[player.PlayerID] = player
+	}
+	for _, enemy := range enemies {
+		view.Enemies[enemy.EnemyID] = enemy
+	}
+	return view
+}
+
+// TestDiffCoversWireFields checks that an entity the client has never seen
+// goes out with every field encoding/json would send except the tick and
+// timestamp, so the hand-written diffs keep up with the update structs
+func TestDiffCoversWireFields(t *testing.T) {
+	player := &PlayerUpdate{PlayerID: "p", X: 1, Y: 2, ZoneID: 3, Tick: 4, Timestamp: 5, Species: "s", SpeciesID: 6, Direction: 7,
+		MaxHP: 8, HP: 9, MaxAP: 10, AP: 11, GameXP: 12, GameLevel: 13, GameXPOnCurrentLevel: 14, GameXPTotalForNextLevel: 15, LastInputSeq: 16}
+	enemy := &EnemyUpdate{EnemyID: "e", X: 1, Y: 2, ZoneID: 3, Tick: 4, Timestamp: 5, Type: "t", Direction: 6, MaxHP: 7, HP: 8}
+
+	for _, test := range []struct {
+		update interface{}
+		diff   map[string]interface{}
+	}{
+		{player, diffPlayer(player, nil)},
+		{enemy, diffEnemy(enemy, nil)},
+		{&PlayerUpdate{PlayerID: "zero"}, diffPlayer(&PlayerUpdate{PlayerID: "zero"}, nil)},
+	} {
+		want := wireDocument(t, test.update)
+		delete(want, "tick")
+		delete(want, "timestamp")
+		if got := wireDocument(t, test.diff); !reflect.DeepEqual(got, want) {
+			t.Errorf("full diff %v, want %v", got, want)
+		}
+	}
+}
+
+// wireDocument returns v as a client decoding its JSON sees it
+func wireDocument(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+// TestDeltaSnapshotAcked checks that once a snapshot is acknowledged the
+// next one only carries what changed since, and what went
+func TestDeltaSnapshotAcked(t *testing.T) {
+	player := &PlayerUpdate{PlayerID: "p", X: 1, Y: 1, HP: 10}
+	enemy := &EnemyUpdate{EnemyID: "e", X: 5, Y: 5, HP: 3}
+	gone := &EnemyUpdate{EnemyID: "gone", X: 7, Y: 7}
+
+	var encoder deltaEncoder
+	first, _ := encoder.encode(deltaTestView(1, []*PlayerUpdate{player}, []*EnemyUpdate{enemy, gone}), replicationHold{})
+	if first.Seq != 1 || first.Baseline != 0 || len(first.Players) != 1 || len(first.Enemies) != 2 {
+		t.Fatalf("first snapshot %+v, want seq 1 complete with every entity", first)
+	}
+	if !encoder.ack(first.Seq) {
+		t.Fatal("ack of a sent snapshot refused")
+	}
+
+	moved := *player
+	moved.X = 2
+	second, _ := encoder.encode(deltaTestView(2, []*PlayerUpdate{&moved}, []*EnemyUpdate{enemy}), replicationHold{})
+	if second.Seq != 2 || second.Baseline != 1 {
+		t.Fatalf("second snapshot seq %d baseline %d, want 2 on 1", second.Seq, second.Baseline)
+	}
+	if want := []map[string]interface{}{{"playerId": "p", "x": float32(2)}}; !reflect.DeepEqual(second.Players, want) {
+		t.Errorf("players %v, want %v", second.Players, want)
+	}
+	if len(second.Enemies) != 0 {
+		t.Errorf("unchanged enemy sent again: %v", second.Enemies)
+	}
+	if !reflect.DeepEqual(second.RemovedEnemies, []string{"gone"}) || len(second.RemovedPlayers) != 0 {
+		t.Errorf("removed players %v enemies %v, want enemy gone", second.RemovedPlayers, second.RemovedEnemies)
+	}
+	if want := []DeltaZone{{ZoneID: 1, Tick: 2, Timestamp: 200}}; !reflect.DeepEqual(second.Zones, want) {
+		t.Errorf("zones %v, want %v", second.Zones, want)
+	}
+}
+
+// TestDeltaSnapshotFallsBack checks that without a usable baseline the
+// client gets a complete snapshot
+func TestDeltaSnapshotFallsBack(t *testing.T) {
+	enemy := &EnemyUpdate{EnemyID: "e", X: 5, Y: 5}
+	view := deltaTestView(1, nil, []*EnemyUpdate{enemy})
+
+	var encoder deltaEncoder
+	if encoder.ack(1) {
+		t.Error("ack of a snapshot never sent accepted")
+	}
+	encoder.encode(view, replicationHold{})
+	if frame, _ := encoder.encode(view, replicationHold{}); frame.Baseline != 0 || len(frame.Enemies) != 1 {
+		t.Errorf("unacknowledged: baseline %d with %d enemies, want a complete snapshot", frame.Baseline, len(frame.Enemies))
+	}
+
+	encoder.ack(2)
+	if frame, _ := encoder.encode(view, replicationHold{}); frame.Baseline != 2 || len(frame.Enemies) != 0 {
+		t.Fatalf("acknowledged: baseline %d with %d enemies, want a delta on 2", frame.Baseline, len(frame.Enemies))
+	}
+	// the acknowledged snapshot falls out of the history
+	for i := 0; i < SnapshotHistorySize; i++ {
+		encoder.encode(view, replicationHold{})
+	}
+	if frame, _ := encoder.encode(view, replicationHold{}); frame.Baseline != 0 || len(frame.Enemies) != 1 {
+		t.Errorf("stale baseline: baseline %d with %d enemies, want a complete snapshot", frame.Baseline, len(frame.Enemies))
+	}
+}
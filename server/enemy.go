@@ -19,6 +19,9 @@ type Stats struct {
 }
 */
 
+// XPAwardRadius is how far from a dead enemy players get its XP, in pixels
+const XPAwardRadius = 500
+
 // Enemy represents an enemy entity
 type Enemy struct {
 	ID                 string
@@ -47,6 +50,12 @@ type Enemy struct {
 	// Ability configuration
 	Ability     Ability // Generic ability slot
 	AbilityName string  // Name of the ability (for reference)
+
+	// Pursuit, the waypoints left to the tile the target was on when they
+	// were found, see pathfind.go
+	path       []TilePosition
+	pathTarget TilePosition
+	hasPath    bool
 }
 
 
@@ -103,8 +112,8 @@ func NewEnemy(zoneID int, x, y float32, enemyType string) *Enemy {
 		},
 
 		// AI configuration
-		PursueTriggerRadius:    config.PursueTriggerRadius,
-		TelegraphTriggerRadius: config.TelegraphTriggerRadius,
+		PursueTriggerRadius:    config.PursueTriggerTiles * float32(TileSize),
+		TelegraphTriggerRadius: config.TelegraphTriggerTiles * float32(TileSize),
 		TelegraphDuration:      config.TelegraphDuration,
 		AttackDuration:         config.AttackDuration,
 		DeathDuration:          config.DeathDuration,
@@ -174,22 +183,24 @@ func (e *Enemy) UpdateEnemy(gs *GameServer, zone *Zone) ([]Message, bool) {
 			e.VY = float32(rand.Float32()*2-1) * 100
 		}
 		// Check for nearby players to pursue
-		nearestPlayer, dist := e.findNearestPlayer(zone)
-		if nearestPlayer != nil && dist <= e.PursueTriggerRadius {
+		_, dist, found := e.findNearestPlayer(gs, zone)
+		if found && dist <= e.PursueTriggerRadius {
 			e.ChangeState("Pursue", 0)
+			e.path, e.hasPath = nil, false
 		}
 
 	case "Pursue":
-		nearestPlayer, dist := e.findNearestPlayer(zone)
-		if nearestPlayer == nil || dist > e.PursueTriggerRadius {
+		nearestPlayer, dist, found := e.findNearestPlayer(gs, zone)
+		if !found || dist > e.PursueTriggerRadius {
 			e.ChangeState("Roam", 0)
 		} else if dist <= e.TelegraphTriggerRadius {
 			e.ChangeState("Telegraph", e.TelegraphDuration)
 			e.VX, e.VY = 0, 0 // Stop moving to telegraph attack
 		} else {
-			// Move toward the player
-			dx := nearestPlayer.X - e.X
-			dy := nearestPlayer.Y - e.Y
+			// Move toward the player, around the walls in the way
+			waypointX, waypointY := e.pursuitWaypoint(zone, nearestPlayer)
+			dx := waypointX - e.X
+			dy := waypointY - e.Y
 			mag := float32(math.Sqrt(float64(dx*dx + dy*dy)))
 			if mag > 0 {
 				e.VX = (dx / mag) * 150 // Move faster than Roam
@@ -207,56 +218,52 @@ func (e *Enemy) UpdateEnemy(gs *GameServer, zone *Zone) ([]Message, bool) {
 				if !ok {
 					log.Printf("Enemy %s has Fireball ability but type assertion failed", e.ID)
 				} else {
-					// Find the nearest valid target within range
-					var target Entity
+					// Find the nearest valid target within range, over zone
+					// borders too
+					var target *perceivedEntity
 					var targetDist float32 = fireball.Range
-					var targetID string
-					if fireball.TargetType == "player" || fireball.TargetType == "all" {
-						for _, player := range zone.Players {
-							if player.GetID() == e.GetID() {
-								continue
-							}
-							dx := player.GetX() - e.GetX()
-							dy := player.GetY() - e.GetY()
-							dist := float32(math.Sqrt(float64(dx*dx + dy*dy)))
-							if dist <= fireball.Range && dist < targetDist {
-								target = player
-								targetDist = dist
-								targetID = player.GetID()
-							}
+					consider := func(id string, x, y, distSq float32) {
+						if id == e.GetID() {
+							return
 						}
+						dist := float32(math.Sqrt(float64(distSq)))
+						if dist < targetDist {
+							target = &perceivedEntity{ID: id, X: x, Y: y}
+							targetDist = dist
+						}
+					}
+					if fireball.TargetType == "player" || fireball.TargetType == "all" {
+						zone.playersWithin(e.GetX(), e.GetY(), fireball.Range, func(player *Player, distSq float32) {
+							consider(player.ID, player.X, player.Y, distSq)
+						})
+						gs.remotePlayersWithin(zone, e.GetX(), e.GetY(), fireball.Range, func(player *PlayerUpdate, distSq float32) {
+							consider(player.PlayerID, player.X, player.Y, distSq)
+						})
 					}
 					if fireball.TargetType == "enemy" || fireball.TargetType == "all" {
-						for _, enemy := range zone.Enemies {
-							if enemy.GetID() == e.GetID() {
-								continue
-							}
-							dx := enemy.GetX() - e.GetX()
-							dy := enemy.GetY() - e.GetY()
-							dist := float32(math.Sqrt(float64(dx*dx + dy*dy)))
-							if dist <= fireball.Range && dist < targetDist {
-								target = enemy
-								targetDist = dist
-								targetID = enemy.GetID()
-							}
-						}
+						zone.enemiesWithin(e.GetX(), e.GetY(), fireball.Range, func(enemy *Enemy, distSq float32) {
+							consider(enemy.ID, enemy.X, enemy.Y, distSq)
+						})
+						gs.remoteEnemiesWithin(zone, e.GetX(), e.GetY(), fireball.Range, func(enemy *EnemyUpdate, distSq float32) {
+							consider(enemy.EnemyID, enemy.X, enemy.Y, distSq)
+						})
 					}
 
 					// If a target is found, set the impact position and send a telegraph warning
 					if target != nil {
-						fireball.SetImpactPosition(target.GetX(), target.GetY(), targetID)
-						messages = append(messages, Message{
-							Type: "telegraphWarning",
-							Data: map[string]interface{}{
+						fireball.SetImpactPosition(target.X, target.Y, target.ID)
+						messages = append(messages, NewAreaMessage(zone.ID, target.X, target.Y, EffectBroadcastRadius,
+							"telegraphWarning",
+							map[string]interface{}{
 								"ability":  "Fireball",
 								"casterId": e.GetID(),
-								"targetId": targetID,
-								"impactX":  target.GetX(),
-								"impactY":  target.GetY(),
+								"targetId": target.ID,
+								"impactX":  target.X,
+								"impactY":  target.Y,
 								"radius":   fireball.Radius,
 								"duration": e.StateDuration.Milliseconds(),
 							},
-						})
+						))
 					}
 				}
 			}
@@ -286,10 +293,10 @@ func (e *Enemy) UpdateEnemy(gs *GameServer, zone *Zone) ([]Message, bool) {
 
 	case "Cooldown":
 		if time.Since(e.StateStartTime) >= e.StateDuration {
-			nearestPlayer, dist := e.findNearestPlayer(zone)
-			if nearestPlayer != nil && dist <= e.TelegraphTriggerRadius {
+			_, dist, found := e.findNearestPlayer(gs, zone)
+			if found && dist <= e.TelegraphTriggerRadius {
 				e.ChangeState("Telegraph", e.TelegraphDuration)
-			} else if nearestPlayer != nil && dist <= e.PursueTriggerRadius {
+			} else if found && dist <= e.PursueTriggerRadius {
 				e.ChangeState("Puruse", 0)
 			} else {
 				e.ChangeState("Roam", 0)
@@ -299,30 +306,38 @@ func (e *Enemy) UpdateEnemy(gs *GameServer, zone *Zone) ([]Message, bool) {
 	case "Death":
 		if time.Since(e.StateStartTime) >= e.StateDuration {
 			// Enemy is fully dead, award XP to nearby players
-			for _, player := range zone.Players {
-				dx := player.X - e.X
-				dy := player.Y - e.Y
-				if float32(math.Sqrt(float64(dx*dx+dy*dy))) <= 500 { // Arbitrary XP award radius
-					xpAward := 10 // Adjust based on enemy type
-					switch e.Type {
-					case "easy":
-						xpAward = 10
-					case "medium":
-						xpAward = 20
-					case "hard":
-						xpAward = 50
-					}
-					addPlayerXP(player, xpAward, gs)
-				}
+			xpAward := 10 // Adjust based on enemy type
+			switch e.Type {
+			case "easy":
+				xpAward = 10
+			case "medium":
+				xpAward = 20
+			case "hard":
+				xpAward = 50
 			}
+			zone.playersWithin(e.X, e.Y, XPAwardRadius, func(player *Player, _ float32) {
+				messages = append(messages, addPlayerXP(player, xpAward, gs)...)
+			})
+			// and to those over the border, through their own zone
+			gs.remotePlayersWithin(zone, e.X, e.Y, XPAwardRadius, func(player *PlayerUpdate, _ float32) {
+				gs.awardXP(player.PlayerID, xpAward)
+			})
 			return messages, false // Remove enemy
 		}
 	}
 
 	// Update position
 	dt := float32(TickInterval.Seconds())
-	e.X += e.VX * dt
-	e.Y += e.VY * dt
+	var blockedX, blockedY bool
+	e.X, e.Y, blockedX, blockedY = moveAndSlide(e.X, e.Y, e.VX*dt, e.VY*dt)
+
+	// Bounce off walls
+	if blockedX {
+		e.VX = -e.VX * 0.5
+	}
+	if blockedY {
+		e.VY = -e.VY * 0.5
+	}
 
 	// Keep enemy within zone bounds
 	if e.X < zone.WorldX {
@@ -345,20 +360,78 @@ func (e *Enemy) UpdateEnemy(gs *GameServer, zone *Zone) ([]Message, bool) {
 	return messages, true // Keep the enemy alive
 }
 
-// findNearestPlayer finds the nearest player to the enemy
-func (e *Enemy) findNearestPlayer(zone *Zone) (*Player, float32) {
-	var nearestPlayer *Player = nil
+// RepathTiles is how many tiles a pursued target moves before its pursuer
+// looks for a new path
+const RepathTiles = 2
+
+// pursuitWaypoint returns where a pursuing enemy heads next on its way to a
+// target: downhill on the zone's flow field when the target is a player of
+// the zone, otherwise the next waypoint of its path, or the target itself
+// once it is past the last one or while there is no path yet
+func (e *Enemy) pursuitWaypoint(zone *Zone, target perceivedEntity) (float32, float32) {
+	if _, local := zone.Players[target.ID]; local {
+		if waypointX, waypointY, ok := zone.Flow.waypoint(e.X, e.Y); ok {
+			e.path, e.hasPath = nil, false
+			return waypointX, waypointY
+		}
+	}
+	return e.followPath(zone, target.X, target.Y)
+}
+
+// followPath returns the next waypoint of the enemy's path to a target at
+// x, y, finding a new path when the target has moved away from the last one
+func (e *Enemy) followPath(zone *Zone, x, y float32) (float32, float32) {
+	target := tileAt(x, y)
+	here := tileAt(e.X, e.Y)
+
+	moved := max(target.X-e.pathTarget.X, e.pathTarget.X-target.X, target.Y-e.pathTarget.Y, e.pathTarget.Y-target.Y)
+	if !e.hasPath || moved > RepathTiles || (len(e.path) == 0 && here != target) {
+		if path, ok := zone.Paths.find(zone, here, target); ok {
+			e.path, e.pathTarget, e.hasPath = path, target, true
+		}
+	}
+
+	// a waypoint is reached within half a tile, more than the enemy moves
+	// in a tick, so it can't overshoot and turn back
+	reach := float32(TileSize) / 2
+	for len(e.path) > 0 {
+		waypointX, waypointY := tileCentre(e.path[0])
+		dx, dy := waypointX-e.X, waypointY-e.Y
+		if dx*dx+dy*dy > reach*reach {
+			return waypointX, waypointY
+		}
+		e.path = e.path[1:]
+	}
+	return x, y
+}
+
+// perceivedEntity is an entity an enemy can see, in its own zone or over the
+// border in a neighbour
+type perceivedEntity struct {
+	ID   string
+	X, Y float32
+}
+
+// findNearestPlayer finds the nearest player to the enemy, looking into the
+// neighbouring zones its perception reaches. Players further away than the
+// enemy's trigger radii are out of its perception and aren't considered, ok is
+// false if there are none in range.
+func (e *Enemy) findNearestPlayer(gs *GameServer, zone *Zone) (nearest perceivedEntity, dist float32, ok bool) {
 	var minDistSq float32 = math.MaxFloat32
 
-	for _, player := range zone.Players {
-		dx := player.X - e.X
-		dy := player.Y - e.Y
-		distSq := float32(dx*dx + dy*dy)
+	perception := max(e.PursueTriggerRadius, e.TelegraphTriggerRadius)
+	zone.playersWithin(e.X, e.Y, perception, func(player *Player, distSq float32) {
 		if distSq < minDistSq {
 			minDistSq = distSq
-			nearestPlayer = player
+			nearest, ok = perceivedEntity{ID: player.ID, X: player.X, Y: player.Y}, true
 		}
-	}
+	})
+	gs.remotePlayersWithin(zone, e.X, e.Y, perception, func(player *PlayerUpdate, distSq float32) {
+		if distSq < minDistSq {
+			minDistSq = distSq
+			nearest, ok = perceivedEntity{ID: player.PlayerID, X: player.X, Y: player.Y}, true
+		}
+	})
 
-	return nearestPlayer, float32(math.Sqrt(float64(minDistSq)))
+	return nearest, float32(math.Sqrt(float64(minDistSq))), ok
 }
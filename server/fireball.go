@@ -2,7 +2,6 @@ package main
 
 import (
 	"log"
-	"math"
 	"time"
 )
 
@@ -81,45 +80,26 @@ func (fb *Fireball) Execute(caster Entity, gs *GameServer, zone *Zone) []Message
     var messages []Message
     impactX, impactY := fb.ImpactX, fb.ImpactY
 
-    messages = append(messages, Message{
-        Type: "abilityEffect",
-        Data: map[string]interface{}{
+    messages = append(messages, NewAreaMessage(zone.ID, impactX, impactY, EffectBroadcastRadius,
+        "abilityEffect",
+        map[string]interface{}{
             "ability":  "Fireball",
             "casterId": caster.GetID(),
             "impactX":  impactX,
             "impactY":  impactY,
             "radius":   fb.Radius,
         },
+    ))
+
+    gs.dealAreaDamage(zone, AreaHit{
+        CasterID:   caster.GetID(),
+        X:          impactX,
+        Y:          impactY,
+        Radius:     fb.Radius,
+        TargetType: fb.TargetType,
+        Damage:     fb.Damage,
     })
 
-    if fb.TargetType == "player" || fb.TargetType == "all" {
-        for _, player := range zone.Players {
-            if player.GetID() == caster.GetID() {
-                continue
-            }
-            dx := player.GetX() - impactX
-            dy := player.GetY() - impactY
-            dist := float32(math.Sqrt(float64(dx*dx + dy*dy)))
-            if dist <= fb.Radius {
-                player.GetStats().HP -= fb.Damage
-            }
-        }
-    }
-
-    if fb.TargetType == "enemy" || fb.TargetType == "all" {
-        for _, enemy := range zone.Enemies {
-            if enemy.GetID() == caster.GetID() {
-                continue
-            }
-            dx := enemy.GetX() - impactX
-            dy := enemy.GetY() - impactY
-            dist := float32(math.Sqrt(float64(dx*dx + dy*dy)))
-            if dist <= fb.Radius {
-                enemy.GetStats().HP -= fb.Damage
-            }
-        }
-    }
-
     fb.ImpactX, fb.ImpactY = 0, 0
     fb.TargetID = ""
 
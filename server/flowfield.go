@@ -0,0 +1,221 @@
+package main
+
+// Flow fields
+//
+// A path per enemy is wasted work when a swarm chases the same few players.
+// Every zone also keeps a flow field: for each tile near its players, the
+// walking cost to the closest of them around the walls, a Dijkstra map over
+// the same tiles and moves as pathfind.go. An enemy chasing a player of its
+// own zone just reads which way is downhill from its tile, one lookup
+// whatever the number of enemies. Enemies chasing players over the
+// border, who aren't in the field, keep using A* to the border and head
+// straight for them from there.
+//
+// The field only reaches FlowFieldRange tiles from each player, past the
+// distance enemies give up the chase from, and is refreshed incrementally:
+// when a player changes tile only the tiles within range of its old and new
+// tile are worked out again, from the players within range of those. Only
+// the zone's worker touches it.
+
+// FlowFieldRange is how far from a player, in tiles of walking, its flow
+// field reaches
+const FlowFieldRange = 16
+
+// flowUnreached is the cost of tiles out of range of every player
+const flowUnreached = ^uint16(0)
+
+type flowField struct {
+	// tiles are indexed on a grid one tile bigger than the zone all around,
+	// unwalkable, so neighbours are never out of bounds
+	stride   int
+	bounds   tileRect
+	costs    []uint16                // by tile, flowUnreached out of range
+	moves    []uint8                 // by tile, 1 + the pathDirections index of the way downhill, 0 for none
+	walkable []bool                  // by tile, walls never move
+	sources  map[string]TilePosition // the tile of every player in the field
+
+	// scratch for the partial searches, as in pathfinder
+	scratch      []uint16
+	scratchMoves []uint8
+	visited      []uint32
+	buckets      [][]int32
+	search       uint32
+
+	// tiles already redone by an update, so overlapping areas are done once
+	done []uint32
+	pass uint32
+}
+
+// index returns where a world tile of the zone is in the field's slices
+func (f *flowField) index(tile TilePosition) int {
+	return (tile.Y-f.bounds.Min.Y+1)*f.stride + tile.X - f.bounds.Min.X + 1
+}
+
+// update brings the field up to date with the positions of the zone's
+// players. Called by the zone's worker each tick once players have moved.
+func (f *flowField) update(zone *Zone) {
+	bounds := zone.tileBounds()
+	if f.bounds != bounds || f.costs == nil {
+		width, height := bounds.Max.X-bounds.Min.X, bounds.Max.Y-bounds.Min.Y
+		f.bounds, f.stride = bounds, width+2
+		size := f.stride * (height + 2)
+		f.costs = make([]uint16, size)
+		for i := range f.costs {
+			f.costs[i] = flowUnreached
+		}
+		f.moves = make([]uint8, size)
+		f.walkable = make([]bool, size)
+		walkable := zoneWalkable(bounds)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				f.walkable[(y+1)*f.stride+x+1] = walkable(x, y)
+			}
+		}
+		f.scratch = make([]uint16, size)
+		f.scratchMoves = make([]uint8, size)
+		f.visited = make([]uint32, size)
+		f.done = make([]uint32, size)
+		f.sources = make(map[string]TilePosition)
+	}
+
+	// players that changed tile, came or went make the tiles within range
+	// of where they were and are dirty
+	current := make(map[string]TilePosition, len(zone.Players))
+	var dirty []TilePosition
+	for id, player := range zone.Players {
+		tile := tileAt(player.X, player.Y)
+		if !bounds.contains(tile) {
+			continue
+		}
+		current[id] = tile
+		if previous, found := f.sources[id]; !found || previous != tile {
+			dirty = append(dirty, tile)
+			if found {
+				dirty = append(dirty, previous)
+			}
+		}
+	}
+	for id, previous := range f.sources {
+		if _, found := current[id]; !found {
+			dirty = append(dirty, previous)
+		}
+	}
+	f.sources = current
+	if len(dirty) == 0 {
+		return
+	}
+
+	// every player within range of a dirty tile may reach into its area
+	near := func(a, b TilePosition, tiles int) bool {
+		return max(a.X-b.X, b.X-a.X, a.Y-b.Y, b.Y-a.Y) <= tiles
+	}
+	var seeds []TilePosition
+	for _, tile := range current {
+		for _, centre := range dirty {
+			if near(tile, centre, 2*FlowFieldRange) {
+				seeds = append(seeds, tile)
+				break
+			}
+		}
+	}
+
+	// work out costs from those players, walking no further than the range,
+	// and copy them over the dirty areas
+	f.flood(seeds)
+	f.pass++
+	for _, centre := range dirty {
+		for y := max(centre.Y-FlowFieldRange, bounds.Min.Y); y <= min(centre.Y+FlowFieldRange, bounds.Max.Y-1); y++ {
+			row := f.index(TilePosition{X: bounds.Min.X, Y: y}) - bounds.Min.X
+			for x := max(centre.X-FlowFieldRange, bounds.Min.X); x <= min(centre.X+FlowFieldRange, bounds.Max.X-1); x++ {
+				i := row + x
+				if f.done[i] == f.pass {
+					continue
+				}
+				f.done[i] = f.pass
+				if f.visited[i] == f.search {
+					f.costs[i], f.moves[i] = f.scratch[i], f.scratchMoves[i]
+				} else {
+					f.costs[i], f.moves[i] = flowUnreached, 0
+				}
+			}
+		}
+	}
+}
+
+// flood runs Dijkstra from the seed tiles into the scratch costs and moves,
+// up to FlowFieldRange tiles of walking
+func (f *flowField) flood(seeds []TilePosition) {
+	f.search++
+	limit := uint16(FlowFieldRange * straightCost)
+	var offsets [len(pathDirections)]int32
+	for d, direction := range pathDirections {
+		offsets[d] = int32(direction.Y*f.stride + direction.X)
+	}
+
+	// costs are small and moves cost 10 or 14, so a bucket of tiles per
+	// cost stands in for the priority queue, settling tiles in cost order
+	if len(f.buckets) == 0 {
+		f.buckets = make([][]int32, limit+1)
+	}
+	for _, seed := range seeds {
+		i := int32(f.index(seed))
+		if f.visited[i] != f.search {
+			f.visited[i] = f.search
+			f.scratch[i], f.scratchMoves[i] = 0, 0
+			f.buckets[0] = append(f.buckets[0], i)
+		}
+	}
+	for cost := uint16(0); cost <= limit; cost++ {
+		for len(f.buckets[cost]) > 0 {
+			bucket := f.buckets[cost]
+			index := bucket[len(bucket)-1]
+			f.buckets[cost] = bucket[:len(bucket)-1]
+			if f.scratch[index] != cost {
+				continue // reached more cheaply since it was queued
+			}
+			for d, offset := range offsets {
+				next := index + offset
+				if !f.walkable[next] {
+					continue
+				}
+				step := uint16(straightCost)
+				if d >= 4 {
+					// no cutting corners, as in pathfind.go
+					direction := pathDirections[d]
+					if !f.walkable[index+int32(direction.X)] || !f.walkable[index+int32(direction.Y*f.stride)] {
+						continue
+					}
+					step = diagonalCost
+				}
+				nextCost := cost + step
+				if nextCost > limit || f.visited[next] == f.search && f.scratch[next] <= nextCost {
+					continue
+				}
+				f.visited[next] = f.search
+				// the way downhill from next is back the way it was reached
+				f.scratch[next], f.scratchMoves[next] = nextCost, opposite[d]+1
+				f.buckets[nextCost] = append(f.buckets[nextCost], next)
+			}
+		}
+	}
+}
+
+// opposite is the pathDirections index of the reverse of each direction
+var opposite = [len(pathDirections)]uint8{1, 0, 3, 2, 7, 6, 5, 4}
+
+// waypoint returns the middle of the tile next to x, y that leads downhill
+// to the closest player, and false if x, y is out of the field or already on
+// a player's tile
+func (f *flowField) waypoint(x, y float32) (float32, float32, bool) {
+	tile := tileAt(x, y)
+	if f.costs == nil || !f.bounds.contains(tile) {
+		return 0, 0, false
+	}
+	move := f.moves[f.index(tile)]
+	if move == 0 {
+		return 0, 0, false
+	}
+	direction := pathDirections[move-1]
+	waypointX, waypointY := tileCentre(TilePosition{X: tile.X + direction.X, Y: tile.Y + direction.Y})
+	return waypointX, waypointY, true
+}
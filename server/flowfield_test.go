@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestFlowField checks that every tile a player can be walked to from leads
+// one step closer to them, and the others nowhere, as the player moves
+func TestFlowField(t *testing.T) {
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	// a wall to walk around, and a pocket at 6,6 closed off by another
+	tilemaps["a"] = borderTilemap(
+		TilePosition{X: 3, Y: 1}, TilePosition{X: 3, Y: 2}, TilePosition{X: 3, Y: 3}, TilePosition{X: 3, Y: 4},
+		TilePosition{X: 5, Y: 5}, TilePosition{X: 6, Y: 5}, TilePosition{X: 5, Y: 6},
+	)
+	zone := &Zone{ID: 1, Players: make(map[string]*Player)}
+	bounds := zone.tileBounds()
+	walkable := zoneWalkable(bounds)
+	free := func(tile TilePosition) bool { return walkable(tile.X-bounds.Min.X, tile.Y-bounds.Min.Y) }
+	pocket := TilePosition{X: 6, Y: 6}
+
+	check := func(target TilePosition) {
+		t.Helper()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				tile := TilePosition{X: x, Y: y}
+				cost, move := zone.Flow.costs[zone.Flow.index(tile)], zone.Flow.moves[zone.Flow.index(tile)]
+				switch {
+				case !free(tile) || tile == pocket:
+					if cost != flowUnreached || move != 0 {
+						t.Errorf("unreachable tile %v has cost %d and move %d", tile, cost, move)
+					}
+					continue
+				case tile == target:
+					if cost != 0 || move != 0 {
+						t.Errorf("the player's tile has cost %d and move %d", cost, move)
+					}
+					continue
+				case move == 0:
+					t.Errorf("tile %v leads nowhere", tile)
+					continue
+				}
+
+				direction := pathDirections[move-1]
+				next := TilePosition{X: x + direction.X, Y: y + direction.Y}
+				step := uint16(straightCost)
+				if direction.X != 0 && direction.Y != 0 {
+					step = diagonalCost
+					if !free(TilePosition{X: next.X, Y: y}) || !free(TilePosition{X: x, Y: next.Y}) {
+						t.Errorf("tile %v leads to %v cutting a corner", tile, next)
+					}
+				}
+				if !free(next) || zone.Flow.costs[zone.Flow.index(next)]+step != cost {
+					t.Errorf("tile %v with cost %d leads to %v with cost %d", tile, cost, next, zone.Flow.costs[zone.Flow.index(next)])
+				}
+
+				// as cheap as the shortest path A* finds
+				var paths pathfinder
+				path, _, _ := paths.findPath(bounds, tile, target, MaxPathNodes)
+				shortest, at := 0, tile
+				for _, step := range path {
+					shortest += octileDistance(at, step)
+					at = step
+				}
+				if at != target || int(cost) != shortest {
+					t.Errorf("tile %v has cost %d, the shortest path costs %d", tile, cost, shortest)
+				}
+			}
+		}
+	}
+
+	x, y := tileCentre(TilePosition{X: 1, Y: 1})
+	player := NewPlayer("player", zone.ID, x, y)
+	zone.Players[player.ID] = player
+	zone.Flow.update(zone)
+	check(TilePosition{X: 1, Y: 1})
+
+	// only the tiles around where the player was and is are redone
+	player.X, player.Y = tileCentre(TilePosition{X: 5, Y: 2})
+	zone.Flow.update(zone)
+	check(TilePosition{X: 5, Y: 2})
+}
+
+// TestPursuitOverBorder checks that an enemy chasing a player over the
+// border heads for the border without searching the whole zone for them,
+// then straight at them
+func TestPursuitOverBorder(t *testing.T) {
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	tilemaps["a"], tilemaps["b"] = borderTilemap(TilePosition{X: 5, Y: 3}), borderTilemap()
+	zone := &Zone{ID: 1, Players: make(map[string]*Player)}
+	x, y := tileCentre(TilePosition{X: 11, Y: 3})
+	target := perceivedEntity{ID: "remote", X: x, Y: y}
+
+	enemy := NewEnemy(zone.ID, 0, 0, "easy")
+	enemy.X, enemy.Y = tileCentre(TilePosition{X: 2, Y: 3})
+	zone.Paths.startTick(1)
+	zone.Flow.update(zone)
+	waypointX, waypointY := enemy.pursuitWaypoint(zone, target)
+	if spent := PathSearchBudget - zone.Paths.budget; spent > 64 {
+		t.Errorf("the search expanded %d tiles of a zone of 64", spent)
+	}
+	if tile := tileAt(waypointX, waypointY); tile != (TilePosition{X: 3, Y: 3}) {
+		t.Errorf("the enemy heads for %v, want 3,3", tile)
+	}
+	if last := enemy.path[len(enemy.path)-1]; last != (TilePosition{X: 7, Y: 3}) {
+		t.Errorf("the path ends at %v, want the border at 7,3", last)
+	}
+
+	// walk the path to the border
+	for i := 0; i < 10 && (waypointX != x || waypointY != y); i++ {
+		enemy.X, enemy.Y = waypointX, waypointY
+		zone.Paths.startTick(uint64(2 + i))
+		waypointX, waypointY = enemy.pursuitWaypoint(zone, target)
+	}
+	if waypointX != x || waypointY != y {
+		t.Errorf("the enemy at %v heads for %v, not straight at the player", tileAt(enemy.X, enemy.Y), tileAt(waypointX, waypointY))
+	}
+	if here := tileAt(enemy.X, enemy.Y); here != (TilePosition{X: 7, Y: 3}) {
+		t.Errorf("the enemy went straight for the player from %v, want the border at 7,3", here)
+	}
+}
+
+// BenchmarkSwarm compares steering a crowd with A* and with the flow field,
+// see benchmarkSwarm
+func BenchmarkSwarm(b *testing.B) {
+	b.Run("astar", func(b *testing.B) { benchmarkSwarm(b, false) })
+	b.Run("flowfield", func(b *testing.B) { benchmarkSwarm(b, true) })
+}
+
+// benchmarkSwarm times a tick of steering for 2000 enemies chasing the 5
+// players of their zone, who walk in circles among the walls. Enemies that
+// catch up start over elsewhere in range, so chases keep starting. The A*
+// variant paths every enemy within the search budget, the flow field one
+// refreshes the field and samples it.
+func benchmarkSwarm(b *testing.B, flow bool) {
+	const enemies, players = 2000, 5
+	const circle = 10 * 32 // radius the players walk around, in pixels
+	random := rand.New(rand.NewSource(1))
+	saved := World
+	defer func() { World = saved }()
+	size := benchmarkWalledWorld(random)
+
+	zone := &Zone{ID: 1, Players: make(map[string]*Player)}
+	targets := make([]*Player, players)
+	centres := make([][2]float32, players)
+	for i := range targets {
+		x, y := tileCentre(TilePosition{X: 40 + random.Intn(size-80), Y: 40 + random.Intn(size-80)})
+		centres[i] = [2]float32{x, y}
+		targets[i] = NewPlayer(fmt.Sprintf("player%d", i), zone.ID, x+circle, y)
+		zone.Players[targets[i].ID] = targets[i]
+	}
+	// enemies start the chase somewhere in pursuit range of their target
+	place := func(enemy *Enemy, target *Player) {
+		tile := tileAt(target.X, target.Y)
+		tile.X += random.Intn(13) - 6
+		tile.Y += random.Intn(13) - 6
+		for solidTile(tile.X, tile.Y) {
+			tile.X++
+		}
+		enemy.X, enemy.Y = tileCentre(tile)
+		enemy.path, enemy.hasPath = nil, false
+	}
+	pursuers := make([]*Enemy, enemies)
+	for i := range pursuers {
+		pursuers[i] = NewEnemy(zone.ID, 0, 0, "easy")
+		place(pursuers[i], targets[i%players])
+	}
+
+	dt := float32(TickInterval.Seconds())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		angle := float64(i) * 0.06
+		for j, player := range targets {
+			player.X = centres[j][0] + circle*float32(math.Cos(angle))
+			player.Y = centres[j][1] + circle*float32(math.Sin(angle))
+		}
+		zone.Paths.startTick(uint64(i))
+		if flow {
+			zone.Flow.update(zone)
+		}
+		for j, enemy := range pursuers {
+			target := targets[j%players]
+			var x, y float32
+			if flow {
+				x, y = enemy.pursuitWaypoint(zone, perceivedEntity{ID: target.ID, X: target.X, Y: target.Y})
+			} else {
+				x, y = enemy.followPath(zone, target.X, target.Y)
+			}
+			// the ones that caught up stand for enemies joining the chase
+			if dx, dy := target.X-enemy.X, target.Y-enemy.Y; dx*dx+dy*dy < enemy.TelegraphTriggerRadius*enemy.TelegraphTriggerRadius {
+				place(enemy, target)
+				continue
+			}
+			dx, dy := x-enemy.X, y-enemy.Y
+			if distance := float32(math.Sqrt(float64(dx*dx + dy*dy))); distance > 0 {
+				// waypoints keep clear of walls, the move needn't collide
+				enemy.X += dx / distance * 150 * dt
+				enemy.Y += dy / distance * 150 * dt
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ module aavegotchi-mmorpg-server
 
 go 1.23.4
 
-require (
-	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-)
+require github.com/gorilla/websocket v1.5.3
+
+require github.com/lib/pq v1.10.9 // indirect
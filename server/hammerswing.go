@@ -66,47 +66,27 @@ func (hs *HammerSwing) Execute(caster Entity, gs *GameServer, zone *Zone) []Mess
     casterX := caster.GetX()
     casterY := caster.GetY() - caster.GetSpriteHeightPixels()/2
 
-    messages = append(messages, Message{
-        Type: "abilityEffect",
-        Data: map[string]interface{}{
+    messages = append(messages, NewAreaMessage(zone.ID, casterX, casterY, EffectBroadcastRadius,
+        "abilityEffect",
+        map[string]interface{}{
             "ability":  "HammerSwing",
             "casterId": caster.GetID(),
             "impactX":  casterX,
             "impactY":  casterY,
             "radius":   hs.Radius,
         },
+    ))
+
+    gs.dealAreaDamage(zone, AreaHit{
+        CasterID:   caster.GetID(),
+        X:          casterX,
+        Y:          casterY,
+        Radius:     hs.Radius,
+        TargetType: hs.TargetType,
+        Damage:     hs.Damage,
+        Colliders:  true,
     })
 
-    if hs.TargetType == "player" || hs.TargetType == "all" {
-        for _, player := range zone.Players {
-            offsetY := player.GetSpriteHeightPixels() / 2
-            playerColliderRadius := player.GetSpriteHeightPixels() / 2 * 1
-            testDistanceSq := (hs.Radius + playerColliderRadius) * (hs.Radius + playerColliderRadius)
-
-            dx := player.GetX() - casterX
-            dy := (player.GetY() - offsetY) - casterY
-            distSq := float32(dx*dx + dy*dy)
-            if distSq <= testDistanceSq && player.GetID() != caster.GetID() {
-                player.GetStats().HP -= hs.Damage
-            }
-        }
-    }
-
-    if hs.TargetType == "enemy" || hs.TargetType == "all" {
-        for _, enemy := range zone.Enemies {
-            offsetY := enemy.GetSpriteHeightPixels() / 2
-            enemyColliderRadius := enemy.GetSpriteHeightPixels() / 2 * 1.2
-            testDistanceSq := (hs.Radius + enemyColliderRadius) * (hs.Radius + enemyColliderRadius)
-
-            dx := enemy.GetX() - casterX
-            dy := (enemy.GetY() - offsetY) - casterY
-            distSq := float32(dx*dx + dy*dy)
-            if distSq <= testDistanceSq && enemy.GetID() != caster.GetID() {
-                enemy.GetStats().HP -= hs.Damage
-            }
-        }
-    }
-
     return messages
 }
 
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Zone ownership
+//
+// Only a zone's own worker goroutine reads or writes zone.Players and
+// zone.Enemies. Everything else asks the worker to do it by sending a
+// ZoneCommand with sendZoneCommand: players join the world, leave it, and are
+// handed over from one zone to the next this way. Commands go on zone.Control,
+// or on the zone's overflow queue once Control is full, and the worker takes
+// both in the order they were sent at the start of every tick, before any
+// input from zone.Inbound. A player's Transfer is therefore always applied
+// before a Leave or another Transfer sent after it.
+
+// ZoneCommandType identifies a change to a zone's player set
+type ZoneCommandType int
+
+const (
+	ZoneJoin        ZoneCommandType = iota // a newly spawned player enters the world
+	ZoneTransfer                           // a player handed over by another zone's worker
+	ZoneLeave                              // the player's session has ended
+	ZoneAreaHit                            // damage from another zone, see crosszone.go
+	ZoneAwardXP                            // XP from an enemy killed in another zone
+	ZoneAreaMessage                        // an area event raised in another zone, see crosszone.go
+)
+
+// ZoneCommand is a request for a zone's worker to change its player set
+type ZoneCommand struct {
+	Type       ZoneCommandType
+	PlayerID   string
+	Player     *Player       // set for ZoneJoin and ZoneTransfer
+	FromZoneID int           // set for ZoneTransfer, ZoneAreaHit and ZoneAreaMessage
+	Arrived    chan struct{} // optional, closed once the player is in the zone
+	Hit        *AreaHit      // set for ZoneAreaHit
+	XP         int           // set for ZoneAwardXP
+	Message    *Message      // set for ZoneAreaMessage
+}
+
+// ZoneArrivalTimeout bounds how long a connection waits for its player to be
+// acknowledged by a zone worker after spawning
+const ZoneArrivalTimeout = 2 * time.Second
+
+// joinZone asks the player's zone to take ownership of a newly created player
+// and waits for the zone worker to acknowledge the arrival
+func (gs *GameServer) joinZone(player *Player) bool {
+	zone := gs.Zones[player.ZoneID]
+	if zone == nil {
+		log.Printf("Error: Initial zone %d not found for player %s", player.ZoneID, player.ID)
+		return false
+	}
+
+	arrived := make(chan struct{})
+	sendZoneCommand(zone, ZoneCommand{Type: ZoneJoin, PlayerID: player.ID, Player: player, Arrived: arrived})
+	// register only after queueing the join so anything routed to the zone
+	// from now on is queued behind it
+	gs.Sessions.SetZone(player.ID, zone.ID)
+
+	select {
+	case <-arrived:
+		return true
+	case <-time.After(ZoneArrivalTimeout):
+		log.Printf("Timed out waiting for player %s to arrive in zone %d", player.ID, zone.ID)
+		return false
+	}
+}
+
+// leaveZone asks whichever zone currently owns the player to remove it
+func (gs *GameServer) leaveZone(playerID string) {
+	zoneID, exists := gs.Sessions.Zone(playerID)
+	if !exists {
+		return
+	}
+	if zone := gs.Zones[zoneID]; zone != nil {
+		sendZoneCommand(zone, ZoneCommand{Type: ZoneLeave, PlayerID: playerID})
+	}
+}
+
+// switchZone hands a player over to another zone's worker. Must be called by
+// the worker of oldZone, which gives up the player for good: the caller must
+// not touch the player again after this returns.
+func (gs *GameServer) switchZone(player *Player, oldZone *Zone, newZoneID int) {
+	newZone := gs.Zones[newZoneID]
+	if newZone == nil {
+		log.Printf("Error: Cannot switch player %s to missing zone %d", player.ID, newZoneID)
+		return
+	}
+
+	oldZone.removePlayer(player.ID)
+	player.ZoneID = newZoneID
+
+	sendZoneCommand(newZone, ZoneCommand{
+		Type:       ZoneTransfer,
+		PlayerID:   player.ID,
+		Player:     player,
+		FromZoneID: oldZone.ID,
+	})
+	gs.Sessions.SetZone(player.ID, newZoneID)
+
+	log.Printf("Player %s switched from Zone %d (%d,%d) to Zone %d (%d,%d)",
+		player.ID, oldZone.ID, oldZone.GridX, oldZone.GridY, newZone.ID, newZone.GridX, newZone.GridY)
+}
+
+// sendZoneCommand queues a command for a zone's worker without ever
+// blocking the sender, two workers handing players to each other with full
+// control channels would otherwise deadlock. Once Control is full commands
+// wait on the overflow queue, and so does everything sent after them until
+// the worker has taken it, which keeps them in order.
+func sendZoneCommand(zone *Zone, cmd ZoneCommand) {
+	zone.overflowMu.Lock()
+	defer zone.overflowMu.Unlock()
+	if len(zone.overflow) == 0 {
+		select {
+		case zone.Control <- cmd:
+			return
+		default:
+			log.Printf("Control channel full for Zone %d, queueing command on the overflow", zone.ID)
+		}
+	}
+	zone.overflow = append(zone.overflow, cmd)
+}
+
+// takeZoneCommands returns the queued commands in the order they were sent.
+// Called by the zone worker.
+func (z *Zone) takeZoneCommands() []ZoneCommand {
+	z.commands = z.commands[:0]
+	z.overflowMu.Lock()
+	defer z.overflowMu.Unlock()
+	// everything on Control was sent before anything on the overflow
+	for len(z.Control) > 0 {
+		z.commands = append(z.commands, <-z.Control)
+	}
+	z.commands = append(z.commands, z.overflow...)
+	clear(z.overflow)
+	z.overflow = z.overflow[:0]
+	return z.commands
+}
+
+// processZoneCommands applies all queued commands, returning the messages
+// they raise. Called by the zone worker.
+func (gs *GameServer) processZoneCommands(zone *Zone) []Message {
+	var messages []Message
+	for _, cmd := range zone.takeZoneCommands() {
+		switch cmd.Type {
+		case ZoneJoin, ZoneTransfer:
+			cmd.Player.ZoneID = zone.ID
+			zone.addPlayer(cmd.Player)
+			if cmd.Arrived != nil {
+				close(cmd.Arrived)
+			}
+			if cmd.Type == ZoneJoin {
+				log.Printf("Player %s joined Zone %d", cmd.PlayerID, zone.ID)
+			}
+
+		case ZoneLeave:
+			if _, exists := zone.Players[cmd.PlayerID]; exists {
+				gs.RemovePlayer(zone, cmd.PlayerID)
+				continue
+			}
+			// the player was handed over after the leave was sent
+			gs.chaseZoneCommand(zone, cmd)
+
+		case ZoneAreaHit:
+			zone.applyAreaHit(*cmd.Hit)
+
+		case ZoneAwardXP:
+			if player, exists := zone.Players[cmd.PlayerID]; exists {
+				messages = append(messages, addPlayerXP(player, cmd.XP, gs)...)
+				continue
+			}
+			gs.chaseZoneCommand(zone, cmd)
+
+		case ZoneAreaMessage:
+			messages = append(messages, *cmd.Message)
+		}
+	}
+	return messages
+}
+
+// chaseZoneCommand sends a command for a player that was handed over after
+// the command was sent on to the zone that owns the player now
+func (gs *GameServer) chaseZoneCommand(zone *Zone, cmd ZoneCommand) {
+	if zoneID, exists := gs.Sessions.Zone(cmd.PlayerID); exists && zoneID != zone.ID {
+		if nextZone := gs.Zones[zoneID]; nextZone != nil {
+			sendZoneCommand(nextZone, cmd)
+		}
+	}
+}
+
+// forwardMessage re-routes an inbound message for a player this zone no longer
+// owns to the zone that does. Returns false if there is nowhere to send it.
+func (gs *GameServer) forwardMessage(zone *Zone, msg Message) bool {
+	zoneID, exists := gs.Sessions.Zone(msg.PlayerID)
+	if !exists || zoneID == zone.ID {
+		return false
+	}
+	nextZone := gs.Zones[zoneID]
+	if nextZone == nil {
+		return false
+	}
+	select {
+	case nextZone.Inbound <- msg:
+	default:
+		log.Printf("Inbound channel full for Zone %d", nextZone.ID)
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// useTestWorld makes World a grid of small open zones for the duration of a
+// test, with the given tilemaps by ref
+func useTestWorld(t testing.TB, grid [][]string, tilemaps map[string]*Tilemap, spawn WorldPoint) {
+	t.Helper()
+	keepWorld(t)
+	definition := WorldDefinition{
+		TileSize:       32,
+		ZoneSize:       8,
+		DifficultyTier: 1,
+		PlayerSpawn:    &spawn,
+		Grid:           grid,
+	}
+	if err := InitializeWorld(definition); err != nil {
+		t.Fatal(err)
+	}
+	World.Tilemaps = tilemaps
+}
+
+// keepWorld puts the world back the way it was once a test is done
+func keepWorld(t testing.TB) {
+	saved := World
+	savedTileSize, savedWidth, savedHeight, savedEnemies := TileSize, ZoneWidthPixels, ZoneHeightPixels, NumEnemiesPerZone
+	t.Cleanup(func() {
+		World = saved
+		TileSize, ZoneWidthPixels, ZoneHeightPixels, NumEnemiesPerZone = savedTileSize, savedWidth, savedHeight, savedEnemies
+	})
+}
+
+// openTilemap is a zone sized map without walls
+func openTilemap() *Tilemap {
+	size := World.ZoneSize
+	return &Tilemap{Width: size, Height: size, TileWidth: TileSize, TileHeight: TileSize, Collision: make([]bool, size*size)}
+}
+
+// portalStrip adds a portal layer to a map covering the columns from..to,
+// leading to targetX, targetY in zone targetZone
+func portalStrip(tilemap *Tilemap, from, to, targetZone int, targetX, targetY float64) {
+	data := make([]int, tilemap.Width*tilemap.Height)
+	for y := 0; y < tilemap.Height; y++ {
+		for x := from; x <= to; x++ {
+			data[y*tilemap.Width+x] = 1
+		}
+	}
+	tilemap.Layers = append(tilemap.Layers, TilemapLayer{
+		Name:   fmt.Sprintf("portal to %d", targetZone),
+		Type:   "tilelayer",
+		Width:  tilemap.Width,
+		Height: tilemap.Height,
+		Data:   data,
+		Properties: []TilemapProperty{
+			{Name: "targetZone", Type: "int", Value: float64(targetZone)},
+			{Name: "targetX", Type: "float", Value: targetX},
+			{Name: "targetY", Type: "float", Value: targetY},
+		},
+	})
+	tilemap.buildPortals()
+}
+
+// addTestSession registers a session with no connection, as if its client
+// had dropped and not resumed yet
+func addTestSession(gs *GameServer, id string) *Session {
+	session := &Session{ID: id}
+	gs.Sessions.mu.Lock()
+	gs.Sessions.sessions[id] = session
+	gs.Sessions.mu.Unlock()
+	return session
+}
+
+// TestHandoffRace walks players at random over the borders of four zones and
+// through portals between two of them, with every zone's worker on its own
+// goroutine, inputs routed and admin reads made while they run and a third of
+// the players leaving from halfway on. Run it with -race. Control channels
+// hold a single command so handoffs go through the overflow queue too.
+func TestHandoffRace(t *testing.T) {
+	const players, ticks = 60, 200
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a", "b"}, {"c", "d"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	for _, ref := range []string{"a", "b", "c", "d"} {
+		tilemaps[ref] = openTilemap()
+	}
+	portalStrip(tilemaps["a"], 3, 4, 4, 32, 32)
+	portalStrip(tilemaps["d"], 3, 4, 1, 224, 224)
+
+	gs := NewGameServer()
+	for _, zone := range gs.Zones {
+		zone.Control = make(chan ZoneCommand, 1)
+	}
+
+	// the zone workers, one tick at a time so the test knows when it's done
+	ticked := make(chan struct{})
+	nextTick := make(chan struct{})
+	var tickMu sync.Mutex
+	waitTick := func() <-chan struct{} {
+		tickMu.Lock()
+		defer tickMu.Unlock()
+		return nextTick
+	}
+	stop := make(chan struct{})
+	go func() {
+		defer close(ticked)
+		for tick := uint64(1); ; tick++ {
+			var workers sync.WaitGroup
+			for _, zone := range gs.Zones {
+				workers.Add(1)
+				go func() {
+					defer workers.Done()
+					gs.processZone(zone, tick)
+				}()
+			}
+			workers.Wait()
+
+			tickMu.Lock()
+			close(nextTick)
+			nextTick = make(chan struct{})
+			tickMu.Unlock()
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+
+	var clients sync.WaitGroup
+	for i := 0; i < players; i++ {
+		id := fmt.Sprintf("player%d", i)
+		session := addTestSession(gs, id)
+		leaves := i%3 == 0
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			if !gs.joinZone(gs.CreatePlayer(id)) {
+				t.Errorf("%s didn't join the world", id)
+				return
+			}
+			random := rand.New(rand.NewSource(int64(i)))
+			var keys InputKeys
+			for seq := uint64(1); seq <= ticks; seq++ {
+				<-waitTick()
+				if leaves && seq == ticks/2+uint64(i) {
+					gs.leaveZone(id)
+					return
+				}
+				if seq%5 == 1 {
+					keys = InputKeys{W: random.Intn(2) == 0, A: random.Intn(2) == 0}
+					keys.S, keys.D = !keys.W, !keys.A
+				}
+				zoneID, inWorld := gs.Sessions.Zone(id)
+				if !inWorld {
+					t.Errorf("%s left the world", id)
+					return
+				}
+				gs.Zones[zoneID].Inbound <- Message{Type: "input", PlayerID: id, Data: &InputData{Keys: keys, Seq: seq}}
+				// what the admin endpoint reads while the zones run
+				if info := session.Info(); info.ZoneID == 0 {
+					t.Errorf("%s is in no zone", id)
+				}
+			}
+		}()
+	}
+	clients.Wait()
+	// let the last handoffs and leaves land
+	for i := 0; i < 3; i++ {
+		<-waitTick()
+	}
+	close(stop)
+	<-ticked
+
+	teleports := 0
+	owners := make(map[string]int)
+	for _, zone := range gs.Zones {
+		for id, player := range zone.Players {
+			if other, twice := owners[id]; twice {
+				t.Errorf("%s is in zones %d and %d", id, other, zone.ID)
+			}
+			owners[id] = zone.ID
+			if player.ZoneID != zone.ID {
+				t.Errorf("%s is in zone %d but thinks it is in %d", id, zone.ID, player.ZoneID)
+			}
+			if zoneID := gs.calculateZoneID(player.X, player.Y, player); zoneID != zone.ID {
+				t.Errorf("%s is in zone %d but stands in %d", id, zone.ID, zoneID)
+			}
+		}
+	}
+	for i := 0; i < players; i++ {
+		id := fmt.Sprintf("player%d", i)
+		session, _ := gs.Sessions.Get(id)
+		zoneID, inWorld := session.ZoneID()
+		switch {
+		case i%3 == 0 && (inWorld || owners[id] != 0):
+			t.Errorf("%s left but is still in zone %d", id, owners[id])
+		case i%3 != 0 && zoneID != owners[id]:
+			t.Errorf("%s is in zone %d, its session says %d", id, owners[id], zoneID)
+		}
+		for _, msg := range session.backlog {
+			if msg.Type == MsgTeleport {
+				teleports++
+			}
+		}
+	}
+	if teleports == 0 {
+		t.Error("no player went through a portal")
+	}
+}
+
+// TestZoneCommandOrder checks that a player's leave sent after its handoff,
+// while the handoff waits on the overflow queue, doesn't overtake it and
+// leave the player behind in the world
+func TestZoneCommandOrder(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	tilemaps["a"], tilemaps["b"] = openTilemap(), openTilemap()
+	gs := NewGameServer()
+	from, to := gs.Zones[1], gs.Zones[2]
+	for _, zone := range gs.Zones {
+		zone.Control = make(chan ZoneCommand, 1)
+	}
+
+	addTestSession(gs, "player")
+	player := gs.CreatePlayer("player")
+	sendZoneCommand(from, ZoneCommand{Type: ZoneJoin, PlayerID: player.ID, Player: player})
+	gs.Sessions.SetZone(player.ID, from.ID)
+	gs.processZoneCommands(from)
+
+	// a command for nobody fills the destination's Control
+	sendZoneCommand(to, ZoneCommand{Type: ZoneLeave, PlayerID: "nobody"})
+	player.X = to.WorldX + 16
+	gs.switchZone(player, from, to.ID)
+	gs.leaveZone(player.ID)
+	if len(to.overflow) != 2 {
+		t.Fatalf("%d commands on the overflow, want the handoff and the leave", len(to.overflow))
+	}
+	gs.processZoneCommands(to)
+
+	if _, exists := to.Players[player.ID]; exists {
+		t.Error("the player is still in the zone it was handed to")
+	}
+	if zoneID, inWorld := gs.Sessions.Zone(player.ID); inWorld {
+		t.Errorf("the player's session still says it is in zone %d", zoneID)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Heartbeat settings, overridable from the command line. The server pings
+// every client each PingInterval; a client that sends nothing at all (not
+// even a pong) for IdleTimeout is disconnected.
+var (
+	PingInterval = 5 * time.Second
+	IdleTimeout  = 15 * time.Second
+)
+
+// LatencyStats is a client's round trip time as measured by ping/pong
+type LatencyStats struct {
+	RTTMs         float64 `json:"rttMs"`         // most recent sample
+	SmoothedRTTMs float64 `json:"smoothedRttMs"` // exponentially weighted, as in RFC 6298
+	JitterMs      float64 `json:"jitterMs"`      // mean deviation between samples, as in RFC 3550
+	Samples       int     `json:"samples"`
+}
+
+// latencyTracker accumulates RTT samples for one connection
+type latencyTracker struct {
+	mu    sync.Mutex
+	stats LatencyStats
+}
+
+// addSample records a round trip and returns the updated stats
+func (t *latencyTracker) addSample(rtt time.Duration) LatencyStats {
+	sample := float64(rtt) / float64(time.Millisecond)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stats.Samples == 0 {
+		t.stats.SmoothedRTTMs = sample
+	} else {
+		delta := sample - t.stats.RTTMs
+		if delta < 0 {
+			delta = -delta
+		}
+		t.stats.JitterMs += (delta - t.stats.JitterMs) / 16
+		t.stats.SmoothedRTTMs += (sample - t.stats.SmoothedRTTMs) / 8
+	}
+	t.stats.RTTMs = sample
+	t.stats.Samples++
+	return t.stats
+}
+
+// Stats returns the latest latency figures
+func (t *latencyTracker) Stats() LatencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// Latency returns the client's measured round trip time
+func (c *Client) Latency() LatencyStats {
+	return c.latency.Stats()
+}
+
+// startHeartbeat arms the idle timeout and starts measuring pongs. Must be
+// called from the goroutine that reads the connection, before reading.
+func (c *Client) startHeartbeat() {
+	c.extendReadDeadline()
+	c.conn.SetPongHandler(func(appData string) error {
+		c.extendReadDeadline()
+		if len(appData) != 8 {
+			return nil
+		}
+		sentAt := time.Unix(0, int64(binary.BigEndian.Uint64([]byte(appData))))
+		stats := c.latency.addSample(time.Since(sentAt))
+		// the client shows this in its debug HUD, a lost one doesn't matter
+		c.sendBatch([]Message{{Type: "latency", Data: stats}}, nil, true)
+		return nil
+	})
+}
+
+// extendReadDeadline pushes the idle timeout back, called whenever the client
+// shows signs of life
+func (c *Client) extendReadDeadline() {
+	c.conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+}
+
+// writePing sends a ping carrying the current time. Called by the writer goroutine.
+func (c *Client) writePing() error {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+	return c.conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(WriteWait))
+}
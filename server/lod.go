@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sort"
+)
+
+// Replication level of detail
+//
+// Not every entity in a player's view is worth sending every tick. Entities
+// are due again after an interval set by their distance from the player,
+// ReplicationTiers, and the due ones are sent most overdue first, closest
+// first among equals, for as long as ClientByteBudget lasts. Entities that
+// don't make it keep accumulating priority and go out in a later tick. The
+// player's own update and entities new to the view are always sent.
+//
+// Held entities stay in the view, so they are not removed: delta snapshots
+// repeat them at their baseline state and the client's state of them is
+// left as it is.
+
+// ReplicationTier sets how often entities up to Radius away are sent
+type ReplicationTier struct {
+	Radius   float32
+	Interval uint64 // in ticks
+}
+
+// ReplicationTiers from near to far, the radius of the last tier is ignored
+// and it covers the rest of the view
+var ReplicationTiers = []ReplicationTier{
+	{Radius: 640, Interval: 1},
+	{Radius: 1280, Interval: 2},
+	{Interval: 4},
+}
+
+// ClientByteBudget is the most bytes of entity updates a client is sent per
+// tick, overridable from the command line. Costs are estimated from the size
+// of a complete JSON update message, so delta snapshots come in well below it.
+var ClientByteBudget = 16 * 1024
+
+// Estimated cost of one entity update against ClientByteBudget
+const (
+	playerUpdateCost = 330
+	enemyUpdateCost  = 190
+)
+
+// replicationHold is the set of entities in a view that are not sent in a
+// tick
+type replicationHold struct {
+	players map[string]bool
+	enemies map[string]bool
+}
+
+// replicationSchedule remembers the tick each entity in a connection's view
+// was last sent. Only used by the worker of the zone owning the player.
+type replicationSchedule struct {
+	players map[string]uint64
+	enemies map[string]uint64
+}
+
+type replicationCandidate struct {
+	id              string
+	enemy           bool
+	priority        float32
+	distanceSquared float32
+}
+
+// replicationInterval returns how many ticks apart an entity distanceSquared
+// away is sent
+func replicationInterval(distanceSquared float32) uint64 {
+	for _, tier := range ReplicationTiers[:len(ReplicationTiers)-1] {
+		if distanceSquared <= tier.Radius*tier.Radius {
+			return tier.Interval
+		}
+	}
+	return ReplicationTiers[len(ReplicationTiers)-1].Interval
+}
+
+// plan decides which entities in the view of the player ownID at x, y are
+// sent this tick, returning the ones that are held back. Nothing counts as
+// sent until it is recorded.
+func (r *replicationSchedule) plan(view InterestView, ownID string, x, y float32, tick uint64, budget int) replicationHold {
+	if r.players == nil {
+		r.players = make(map[string]uint64)
+		r.enemies = make(map[string]uint64)
+	}
+	hold := replicationHold{players: make(map[string]bool), enemies: make(map[string]bool)}
+
+	// forget entities that left the view, they are new when they come back
+	for id := range r.players {
+		if _, visible := view.Players[id]; !visible {
+			delete(r.players, id)
+		}
+	}
+	for id := range r.enemies {
+		if _, visible := view.Enemies[id]; !visible {
+			delete(r.enemies, id)
+		}
+	}
+
+	spent := 0
+	var candidates []replicationCandidate
+	consider := func(id string, enemy bool, sent map[string]uint64, updateX, updateY float32) {
+		last, seen := sent[id]
+		cost := playerUpdateCost
+		if enemy {
+			cost = enemyUpdateCost
+		}
+		if !seen || id == ownID {
+			spent += cost
+			return
+		}
+		dx, dy := updateX-x, updateY-y
+		distanceSquared := dx*dx + dy*dy
+		interval := replicationInterval(distanceSquared)
+		if tick-last < interval {
+			if enemy {
+				hold.enemies[id] = true
+			} else {
+				hold.players[id] = true
+			}
+			return
+		}
+		candidates = append(candidates, replicationCandidate{
+			id:              id,
+			enemy:           enemy,
+			priority:        float32(tick-last) / float32(interval),
+			distanceSquared: distanceSquared,
+		})
+	}
+	for id, update := range view.Players {
+		consider(id, false, r.players, update.X, update.Y)
+	}
+	for id, update := range view.Enemies {
+		consider(id, true, r.enemies, update.X, update.Y)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority > candidates[j].priority
+		}
+		return candidates[i].distanceSquared < candidates[j].distanceSquared
+	})
+	for _, candidate := range candidates {
+		held, cost := hold.players, playerUpdateCost
+		if candidate.enemy {
+			held, cost = hold.enemies, enemyUpdateCost
+		}
+		if spent+cost > budget {
+			held[candidate.id] = true
+			continue
+		}
+		spent += cost
+	}
+	return hold
+}
+
+// record notes that every entity in view but not held went out at tick.
+// Called once the frame carrying them is queued, so entities in a frame that
+// was dropped are still due.
+func (r *replicationSchedule) record(view InterestView, hold replicationHold, tick uint64) {
+	for id := range view.Players {
+		if !hold.players[id] {
+			r.players[id] = tick
+		}
+	}
+	for id := range view.Enemies {
+		if !hold.enemies[id] {
+			r.enemies[id] = tick
+		}
+	}
+}
+
+// holding returns view with held entities replaced by their state in the
+// baseline, so the delta against it leaves them out, and the entities that
+// were held. Held entities missing from the baseline have to be sent anyway.
+func (view InterestView) holding(hold replicationHold, baseline InterestView) (InterestView, replicationHold) {
+	if len(hold.players) == 0 && len(hold.enemies) == 0 {
+		return view, hold
+	}
+	held := InterestView{
+		Zones:   view.Zones,
+		Players: make(map[string]*PlayerUpdate, len(view.Players)),
+		Enemies: make(map[string]*EnemyUpdate, len(view.Enemies)),
+	}
+	applied := replicationHold{players: make(map[string]bool), enemies: make(map[string]bool)}
+	for id, update := range view.Players {
+		if previous, found := baseline.Players[id]; found && hold.players[id] {
+			update = previous
+			applied.players[id] = true
+		}
+		held.Players[id] = update
+	}
+	for id, update := range view.Enemies {
+		if previous, found := baseline.Enemies[id]; found && hold.enemies[id] {
+			update = previous
+			applied.enemies[id] = true
+		}
+		held.Enemies[id] = update
+	}
+	return held, applied
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// BenchmarkReplicationLOD compares replicating the whole view every tick
+// with the byte budget, see benchmarkReplicationLOD
+func BenchmarkReplicationLOD(b *testing.B) {
+	b.Run("off", func(b *testing.B) { benchmarkReplicationLOD(b, false) })
+	b.Run("on", func(b *testing.B) { benchmarkReplicationLOD(b, true) })
+}
+
+// benchmarkReplicationLOD measures the per-entity updates a JSON client in
+// the middle of a crowd is sent per tick, reporting the average frame size as
+// bytes/tick. With lod off the whole view goes out every tick.
+func benchmarkReplicationLOD(b *testing.B, lod bool) {
+	random := rand.New(rand.NewSource(1))
+	zones := benchmarkWorld(random)
+	view := benchmarkView(benchmarkSnapshots(zones, 1))
+	var own *PlayerUpdate
+	for _, update := range view.Players {
+		own = update
+		break
+	}
+	x, y := float32(ZoneWidthPixels)/2, float32(ZoneHeightPixels)/2
+
+	var schedule replicationSchedule
+	total := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var hold replicationHold
+		if lod {
+			hold = schedule.plan(view, own.PlayerID, x, y, uint64(i+1), ClientByteBudget)
+			schedule.record(view, hold, uint64(i+1))
+		}
+		batch := make([]Message, 0, len(view.Players)+len(view.Enemies))
+		for id, update := range view.Players {
+			if !hold.players[id] {
+				batch = append(batch, Message{Type: "playerUpdate", Data: update})
+			}
+		}
+		for id, update := range view.Enemies {
+			if !hold.enemies[id] {
+				batch = append(batch, Message{Type: "enemyUpdate", Data: update})
+			}
+		}
+		payload, err := jsonCodec{}.EncodeBatch(batch)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += len(payload)
+	}
+	b.ReportMetric(float64(total)/float64(b.N), "bytes/tick")
+}
+
+// TestReplicationRecordedOnceQueued checks that entities planned into a
+// frame that was never queued are still due next tick
+func TestReplicationRecordedOnceQueued(t *testing.T) {
+	view := deltaTestView(1, []*PlayerUpdate{{PlayerID: "own"}}, []*EnemyUpdate{{EnemyID: "far", X: 5000}})
+
+	var schedule replicationSchedule
+	schedule.plan(view, "own", 0, 0, 1, ClientByteBudget)
+	if hold := schedule.plan(view, "own", 0, 0, 2, ClientByteBudget); hold.enemies["far"] {
+		t.Fatal("enemy held after a frame that was dropped")
+	}
+	schedule.record(view, replicationHold{}, 2)
+	if hold := schedule.plan(view, "own", 0, 0, 3, ClientByteBudget); !hold.enemies["far"] {
+		t.Error("distant enemy sent a tick after it was, want it held")
+	}
+}
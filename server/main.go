@@ -3,15 +3,19 @@ package main
 import (
 	// "bytes"
 	// "encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
 	"net/http"
+	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	// "strconv"
-	// "sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -21,11 +25,15 @@ import (
 const (
 	NumZones          = 9
 	TickInterval      = 100 * time.Millisecond
+	PlayerMoveSpeed   = 6.22 * 32
+)
+
+// World dimensions, set from the world file by InitializeWorld
+var (
 	ZoneWidthPixels   = 256 * 32 // Tiles
 	ZoneHeightPixels  = 256 * 32 // Tiles
 	TileSize          = 32       // Pixels
 	NumEnemiesPerZone = 100
-	PlayerMoveSpeed   = 6.22 * 32
 )
 
 // xp consts
@@ -44,38 +52,6 @@ func init() {
 	}
 }
 
-// ClientManager manages client connections
-type ClientManager struct {
-    // Add fields as needed (e.g., map of client connections)
-    Clients map[string]*websocket.Conn
-}
-
-// AddClient stores the connection.
-func (cm *ClientManager) AddClient(sessionID string, conn *websocket.Conn) {
-	log.Println("AddClient(): ", sessionID)
-	cm.Clients[sessionID] = conn
-}
-
-// RemoveClient removes the connection.
-func (cm *ClientManager) RemoveClient(sessionID string) {
-	log.Println("RemoveClient(): ", sessionID)
-	delete(cm.Clients, sessionID)
-}
-
-// GetClient retrieves a player's WebSocket.
-func (cm *ClientManager) GetClient(playerID string) (*websocket.Conn, bool) {
-	conn, exists := cm.Clients[playerID]
-	return conn, exists
-}
-
-func NewClientManager() *ClientManager {
-	return &ClientManager{
-		Clients: make(map[string]*websocket.Conn),
-	}
-}
-
-var clientManager = NewClientManager()
-
 // Stats holds common game statistics for both players and enemies
 type Stats struct {
     MaxHP int
@@ -99,6 +75,13 @@ type Message struct {
 	Type     string      `json:"type"`
 	Data     interface{} `json:"data"`
 	PlayerID string      `json:"-"` // Not serialized to JSON (we add this in our input reading func)
+
+	// Outbound addressing (see routing.go), never serialized
+	Scope     MessageScope `json:"-"`
+	Recipient string       `json:"-"`
+	ZoneID    int          `json:"-"`
+	X, Y      float32      `json:"-"`
+	Radius    float32      `json:"-"`
 }
 
 // Zone represents a game zone
@@ -109,9 +92,32 @@ type Zone struct {
 	GridY      int // Grid position (e.g., 0,0 for bottom-left)
 	WorldX     float32
 	WorldY     float32
-	Players    map[string]*Player
+	Neighbors  [8]int             // [N, NE, E, SE, S, SW, W, NW], 0 for none
+	Players    map[string]*Player // owned by the zone's worker, see handoff.go
 	Enemies    map[string]*Enemy
 	Inbound    chan Message
+	Control    chan ZoneCommand
+
+	// commands sent while Control is full, see handoff.go
+	overflowMu sync.Mutex
+	overflow   []ZoneCommand
+	commands   []ZoneCommand // the worker's batch of commands to apply
+
+	// the same players and enemies by position, see zoneindex.go
+	PlayerGrid *SpatialHash[*Player]
+	EnemyGrid  *SpatialHash[*Enemy]
+
+	// the zone's map and the enemy layers spawning on it, see spawner.go
+	Tilemap  *Tilemap
+	Spawners []*EnemySpawner
+
+	// ways to the players for pursuing enemies, see pathfind.go and
+	// flowfield.go
+	Paths pathfinder
+	Flow  flowField
+
+	// entity state as of the last tick, the only way other zones read this one
+	snapshot atomic.Pointer[ZoneSnapshot]
 }
 
 
@@ -125,8 +131,9 @@ type ZoneInfo struct {
 
 // GameServer represents the game server
 type GameServer struct {
-	Zones map[int]*Zone
-	ClientManager *ClientManager
+	Zones    map[int]*Zone
+	Sessions *SessionRegistry
+	Clock    SimulationClock
 }
 
 // EnemyUpdate represents enemy data sent to clients
@@ -135,6 +142,7 @@ type EnemyUpdate struct {
 	X         float32 `json:"x"`
 	Y         float32 `json:"y"`
 	ZoneID    int     `json:"zoneId"`
+	Tick      uint64  `json:"tick"`
 	Timestamp int64   `json:"timestamp"`
 
 	Type      string `json:"type"`
@@ -155,18 +163,19 @@ type ActiveZoneList struct {
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
+	Subprotocols:    supportedSubprotocols,
 	CheckOrigin: func(r *http.Request) bool {
 		return r.Header.Get("Origin") == "http://localhost:5173"
 	},
 }
 
+// NewGameServer creates the zones of the world loaded by InitializeWorld and
+// populates them
 func NewGameServer() *GameServer {
-	// Ensure world configuration is initialized
-	InitializeWorld()
-
 	gs := &GameServer{
-		Zones: make(map[int]*Zone),
-		ClientManager: clientManager,
+		Zones:    make(map[int]*Zone),
+		Sessions: NewSessionRegistry(),
+		Clock:    SimulationClock{Epoch: time.Now()},
 	}
 
 	// Populate zones from world configs
@@ -179,11 +188,16 @@ func NewGameServer() *GameServer {
 			GridY:      zoneConfig.GridY, // Use calculated GridY
 			WorldX:     zoneConfig.WorldX,
 			WorldY:     zoneConfig.WorldY,
+			Neighbors:  zoneConfig.Neighbors,
 			Players:    make(map[string]*Player),
 			Enemies:    make(map[string]*Enemy),
 			Inbound:    make(chan Message, 1000),
+			Control:    make(chan ZoneCommand, 1000),
+			PlayerGrid: NewSpatialHash[*Player](ZoneCellSize),
+			EnemyGrid:  NewSpatialHash[*Enemy](ZoneCellSize),
 		}
 
+		zone.snapshot.Store(&ZoneSnapshot{ZoneID: zone.ID})
 		gs.Zones[zoneConfig.ID] = zone
 
 		// log.Println("Added zone ", zoneConfig.ID, " to gs.Zones. Start populating...")
@@ -194,30 +208,43 @@ func NewGameServer() *GameServer {
 			continue
 		}
 
-		// Spawn enemies within the zone's bounds
-		for j := 0; j < NumEnemiesPerZone; j++ {
-			enemyType := "easy"
-			randType := rand.Intn(3)
-			if randType == 0 {
-				enemyType = "easy"
-			} else if randType == 1 {
-				enemyType = "medium"
-			} else {
-				enemyType = "hard"
-			}
+		// Spawn enemies from the enemy layers of the zone's map, if it has any
+		if tilemap := World.Tilemaps[zoneConfig.TilemapRef]; tilemap != nil {
+			zone.Tilemap = tilemap
+			zone.Spawners = enemySpawners(tilemap)
+		}
+		if len(zone.Spawners) > 0 {
+			zone.populate(gs.Clock.Epoch)
+			log.Println("Spawned", len(zone.Enemies), "enemies from", len(zone.Spawners), "enemy layers in zone", zone.ID)
+			continue
+		}
+
+		// Otherwise spawn enemies within the zone's bounds
+		for j := 0; j < zoneConfig.Enemies; j++ {
+			// the tier decides how many of the enemy types can appear
+			enemyType := EnemyTiers[rand.Intn(zoneConfig.DifficultyTier)]
 			// enemyType = "hard"
-			localX := float32(rand.Intn(ZoneWidthPixels))
-			localY := float32(rand.Intn(ZoneHeightPixels))
-			if localX < 0.6*float32(ZoneWidthPixels) && localX > 0.4*float32(ZoneWidthPixels) &&
-				localY < 0.6*float32(ZoneHeightPixels) && localY > 0.4*float32(ZoneHeightPixels) {
-				// we don't want enemies in the centre of the zone, make it
-				// a safe area for player to spawn
-				continue
+			var localX, localY float32
+			if len(zoneConfig.SpawnPoints) > 0 {
+				point := zoneConfig.SpawnPoints[rand.Intn(len(zoneConfig.SpawnPoints))]
+				angle := rand.Float64() * 2 * math.Pi
+				distance := point.Radius * float32(math.Sqrt(rand.Float64()))
+				localX = min(max(point.X+distance*float32(math.Cos(angle)), 0), float32(ZoneWidthPixels))
+				localY = min(max(point.Y+distance*float32(math.Sin(angle)), 0), float32(ZoneHeightPixels))
+			} else {
+				localX = float32(rand.Intn(ZoneWidthPixels))
+				localY = float32(rand.Intn(ZoneHeightPixels))
+				if localX < 0.6*float32(ZoneWidthPixels) && localX > 0.4*float32(ZoneWidthPixels) &&
+					localY < 0.6*float32(ZoneHeightPixels) && localY > 0.4*float32(ZoneHeightPixels) {
+					// we don't want enemies in the centre of the zone, make it
+					// a safe area for player to spawn
+					continue
+				}
 			}
 			x := zoneConfig.WorldX + localX
 			y := zoneConfig.WorldY + localY
 			enemy := NewEnemy(zoneConfig.ID, x, y, enemyType)
-			gs.Zones[zoneConfig.ID].Enemies[enemy.ID] = enemy
+			gs.Zones[zoneConfig.ID].addEnemy(enemy)
 		}
 	}
 
@@ -231,13 +258,15 @@ func (gs *GameServer) StartWorkers() {
 	}
 }
 
-// worker handles updates for a single zone
+// worker handles updates for a single zone, one tick at each tick boundary
+// of the simulation clock
 func (gs *GameServer) worker(zone *Zone) {
-	ticker := time.NewTicker(TickInterval)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		gs.processZone(zone)
+	tick := gs.Clock.TickAt(time.Now()) + 1
+	for {
+		time.Sleep(time.Until(gs.Clock.TickTime(tick)))
+		gs.processZone(zone, tick)
+		// a tick that overran skips the ticks it missed, as a time.Ticker would
+		tick = max(tick+1, gs.Clock.TickAt(time.Now()))
 	}
 }
 
@@ -248,21 +277,79 @@ func (gs *GameServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
-	defer conn.Close()
 
-	// Create session ID and store connection
-	sessionID := fmt.Sprintf("session%d", time.Now().UnixNano())
-	gs.ClientManager.AddClient(sessionID, conn)
+	// Resume the session named by the token if there is one, otherwise start
+	// a new session. All writes go through the client's writer goroutine.
+	var session *Session
+	var client *Client
+	var resumeErr error
+	if token := r.URL.Query().Get("resume"); token != "" {
+		session, resumeErr = gs.findResumableSession(token)
+		if resumeErr == nil {
+			client = NewClient(session.ID, conn)
+			if err := gs.resumeSession(session, client, r.RemoteAddr); err != nil {
+				// expired while we were looking it up, let the client start over
+				log.Printf("Resume from %s failed: %v", r.RemoteAddr, err)
+				client.Close()
+				return
+			}
+		}
+	}
+	if client == nil {
+		client = NewClient(newSessionID(), conn)
+		session = gs.Sessions.Register(client, r.RemoteAddr)
+		if resumeErr != nil {
+			log.Printf("Resume from %s rejected: %v", r.RemoteAddr, resumeErr)
+			client.Send([]Message{NewUnicastMessage(session.ID, "resumeRejected", map[string]interface{}{
+				"reason": resumeErr.Error(),
+			})})
+		}
+	}
+	defer client.Close()
+	sessionID := session.ID
+
+	// clients that go quiet for too long (half-open sockets) time out here
+	client.startHeartbeat()
 
 	// No immediate player creation; wait for spawnPlayerCharacter
 	for {
-		var msg Message
-		if err := conn.ReadJSON(&msg); err != nil {
+		frameType, raw, err := conn.ReadMessage()
+		if err != nil {
 			log.Printf("Error reading from %s: %v", sessionID, err)
 			break
 		}
+		receivedAt := time.Now()
+		client.extendReadDeadline()
+
+		// anything that isn't in the message catalogue gets an error reply
+		msg, protocolErr := decodeClientFrame(client.Codec(), frameType, raw)
+		if protocolErr != nil {
+			rejectClientMessage(session, protocolErr)
+			continue
+		}
 		msg.PlayerID = sessionID // Use sessionID as playerID for now
 
+		// acks are bookkeeping for the connection, the zone never sees them
+		if ack, ok := msg.Data.(*AckData); ok {
+			if !client.AckSnapshot(ack.Seq) {
+				rejectClientMessage(session, &ProtocolError{
+					Code:        ErrCodeInvalidPayload,
+					Reason:      fmt.Sprintf("snapshot %d was never sent", ack.Seq),
+					MessageType: msg.Type,
+				})
+			}
+			continue
+		}
+
+		if clockSync, ok := msg.Data.(*ClockSyncData); ok {
+			gs.handleClockSync(session, clockSync, receivedAt)
+			continue
+		}
+
+		if spawn, ok := msg.Data.(*SpawnPlayerCharacterData); ok {
+			session.SetProtocolVersion(spawn.ProtocolVersion)
+		}
+
 		// Forward message to the appropriate zone if player exists
 		zone := gs.getZoneByPlayerID(sessionID)
 		if zone != nil {
@@ -272,12 +359,12 @@ func (gs *GameServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				log.Printf("Inbound channel full for Zone %d", zone.ID)
 			}
 		} else {
-			// Handle spawnPlayerCharacter to create player
-			if msg.Type == "spawnPlayerCharacter" {
-				player := gs.CreatePlayer(sessionID, conn)
-				zone := gs.Zones[player.ZoneID]
-				if zone != nil {
-					zone.Players[sessionID] = player
+			// Handle spawnPlayerCharacter to create player, the zone worker
+			// acknowledges the arrival before we pass it the spawn message
+			if msg.Type == MsgSpawnPlayerCharacter {
+				player := gs.CreatePlayer(sessionID)
+				if gs.joinZone(player) {
+					zone := gs.Zones[player.ZoneID]
 					select {
 					case zone.Inbound <- msg:
 					default:
@@ -288,10 +375,8 @@ func (gs *GameServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Handle disconnect
-	gs.RemovePlayer(sessionID)
-	gs.ClientManager.RemoveClient(sessionID)
-	log.Printf("Session %s disconnected", sessionID)
+	// Handle disconnect, the player lingers in case the client resumes
+	gs.detachSession(session, client)
 }
 
 // getActiveZones calculates the 4 active zones for a player based on position and neighbors
@@ -395,8 +480,11 @@ func (gs *GameServer) getActiveZones(player *Player) ActiveZoneList {
 	return activeZoneList
 }
 
-func (gs *GameServer) processZone(zone *Zone) {
-	var allPendingMessages []Message
+func (gs *GameServer) processZone(zone *Zone, tick uint64) {
+	// events raised this tick (abilities, deaths, level ups...), each one
+	// addressed to a session, the zone or an area
+	// Apply joins, leaves, handoffs and effects from other goroutines
+	pendingMessages := gs.processZoneCommands(zone)
 
 	// Process inbound messages for players
 	for len(zone.Inbound) > 0 {
@@ -404,9 +492,10 @@ func (gs *GameServer) processZone(zone *Zone) {
 		if player, exists := zone.Players[msg.PlayerID]; exists {
 			messages := player.HandleInput(msg, gs, zone)
 			if messages != nil {
-				allPendingMessages = append(allPendingMessages, messages...)
+				pendingMessages = append(pendingMessages, messages...)
 			}
-		} else {
+			zone.playerMoved(player) // spawning puts the player somewhere else
+		} else if !gs.forwardMessage(zone, msg) {
 			log.Printf("Player %s not found in zone %d", msg.PlayerID, zone.ID)
 		}
 	}
@@ -420,113 +509,76 @@ func (gs *GameServer) processZone(zone *Zone) {
 	for _, player := range playersToUpdate {
 		messages := player.UpdatePlayer(gs, zone, dt)
 		if messages != nil {
-			allPendingMessages = append(allPendingMessages, messages...)
+			pendingMessages = append(pendingMessages, messages...)
 		}
+		zone.playerMoved(player)
 	}
 
-	// Update enemies
+	// Update enemies, bringing back the ones due to respawn first
+	zone.respawnEnemies(gs.Clock.TickTime(tick))
+	zone.Paths.startTick(tick)
+	zone.Flow.update(zone)
 	for enemyID, enemy := range zone.Enemies {
 		messages, keep := enemy.UpdateEnemy(gs, zone)
 		if messages != nil {
-			allPendingMessages = append(allPendingMessages, messages...)
+			pendingMessages = append(pendingMessages, messages...)
 		}
-		if !keep {
-			delete(zone.Enemies, enemyID)
+		if keep {
+			zone.enemyMoved(enemy)
+		} else {
+			zone.removeEnemy(enemyID)
 		}
 	}
 
+	// players that left the zone this tick still get their unicasts, and
+	// players over the border the area events reaching them
+	gs.deliverOrphanedUnicasts(pendingMessages, zone)
+	gs.shareAreaMessages(zone, pendingMessages)
+
+	// Publish this tick's state for the neighbouring zones to read, stamped
+	// with the tick's nominal time so all zones share one timeline
+	zone.publishSnapshot(tick, gs.Clock.TickTime(tick).UnixMilli())
+
 	// Prepare and send updates for each player in this zone
-	timestamp := time.Now().UnixMilli()
 	playersToSend := make([]*Player, 0, len(zone.Players))
 	for _, player := range zone.Players {
 		playersToSend = append(playersToSend, player)
 	}
 	for _, player := range playersToSend {
-		conn, exists := gs.ClientManager.GetClient(player.ID)
-		if !exists || conn == nil {
-			log.Printf("Connection for player %s is closed, marking for removal", player.ID)
+		session, exists := gs.Sessions.Get(player.ID)
+		if !exists {
+			log.Printf("Session for player %s is gone, marking for removal", player.ID)
 			player.ToBeRemoved = true
 			continue
 		}
 
-		activeZones := gs.getActiveZones(player)
-		allPendingMessages = append(allPendingMessages, Message{
-			Type: "activeZones",
-			Data: activeZones,
-		})
-
-		activeZoneIDs := []int{activeZones.CurrentZoneID, activeZones.XAxisZoneID, activeZones.YAxisZoneID, activeZones.DiagonalZoneID}
-		for _, zoneID := range activeZoneIDs {
-			if zoneID == 0 {
-				continue
-			}
-			targetZone := gs.Zones[zoneID]
-			if targetZone == nil {
-				log.Printf("Warning: Zone ID %d not found for player %s", zoneID, player.ID)
-				continue
-			}
-
-			for _, p := range targetZone.Players {
-				allPendingMessages = append(allPendingMessages, Message{
-					Type: "playerUpdate",
-					Data: PlayerUpdate{
-						PlayerID:  p.ID,
-						X:         p.X,
-						Y:         p.Y,
-						ZoneID:    p.ZoneID,
-						Timestamp: timestamp,
-						Species:   p.Species,
-						SpeciesID: p.SpeciesID,
-						Direction: p.Direction,
-						MaxHP:     p.Stats.MaxHP,
-						HP:        p.Stats.HP,
-						MaxAP:     p.Stats.MaxAP,
-						AP:        p.Stats.AP,
-						GameXP:                  p.GameXP,
-						GameLevel:               p.GameLevel,
-						GameXPOnCurrentLevel:    p.GameXPOnCurrentLevel,
-						GameXPTotalForNextLevel: p.GameXPTotalForNextLevel,
-					},
-				})
-			}
-			for _, e := range targetZone.Enemies {
-				allPendingMessages = append(allPendingMessages, Message{
-					Type: "enemyUpdate",
-					Data: EnemyUpdate{
-						EnemyID:   e.ID,
-						X:         e.X,
-						Y:         e.Y,
-						ZoneID:    e.ZoneID,
-						Timestamp: timestamp,
-						Type:      e.Type,
-						Direction: e.Direction,
-						MaxHP:     e.Stats.MaxHP,
-						HP:        e.Stats.HP,
-					},
-				})
-			}
+		// events addressed to this player must arrive, so they go out reliably
+		// ahead of the entity snapshot which can be dropped if the client lags
+		if !session.Send(routeMessages(pendingMessages, player)) {
+			log.Printf("Error queueing events for %s", player.ID)
+			player.ToBeRemoved = true
+			continue
 		}
 
-		var batch []Message
-		for _, pendingMsg := range allPendingMessages {
-			if pendingMsg.Type != "" {
-				batch = append(batch, pendingMsg)
-			} else {
-				log.Printf("Warning: Skipping pending message with empty Type: %v", pendingMsg)
-			}
+		// players waiting for their client to resume stand still
+		if session.IsDetached() {
+			player.VX, player.VY = 0, 0
+			continue
 		}
 
-		// conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
-		if err := conn.WriteJSON(batch); err != nil {
-			log.Printf("Error sending batch to %s: %v", player.ID, err)
-			player.ToBeRemoved = true
-		}
+		// the active zones tell the client which tilemaps to show, entities
+		// are replicated by area of interest regardless of zone
+		batch := []Message{{
+			Type: "activeZones",
+			Data: gs.getActiveZones(player),
+		}}
+		gs.sendEntityState(session, player, batch, tick)
 	}
 
 	// Remove players marked for removal
 	for playerID, player := range zone.Players {
 		if player.ToBeRemoved {
-			gs.RemovePlayer(playerID)
+			gs.RemovePlayer(zone, playerID)
 		}
 	}
 }
@@ -545,7 +597,7 @@ func (gs *GameServer) calculateZoneID(x, y float32, player *Player) int {
 	if zoneID == 0 {
 		// If the current grid position is empty, check neighbors of the player's current zone
 		if player != nil {
-			currentPlayerZone := gs.getZoneByPlayerID(player.ID)
+			currentPlayerZone := gs.Zones[player.ZoneID]
 			if currentPlayerZone != nil {
 				currentConfig := World.ZoneConfigs[currentPlayerZone.ID-1] // Adjust index since IDs start at 1
 				for _, neighborID := range currentConfig.Neighbors {
@@ -567,26 +619,13 @@ func (gs *GameServer) calculateZoneID(x, y float32, player *Player) int {
 	return zoneID
 }
 
-// switchZone transfers a player
-func (gs *GameServer) switchZone(player *Player, oldZone *Zone, newZoneID int) {
-	log.Println("Deleting ", player.ID, " from zone ", oldZone.ID)
-	delete(oldZone.Players, player.ID)
-	player.ZoneID = newZoneID
-	log.Println("Set to new zone: ", player.ZoneID)
-	newZone := gs.Zones[newZoneID]
-	newZone.Players[player.ID] = player
-	log.Printf("Player %s switched from Zone %d (%d,%d) to Zone %d (%d,%d)",
-		player.ID, oldZone.ID, oldZone.GridX, oldZone.GridY, newZone.ID, newZone.GridX, newZone.GridY)
-}
-
 // getZoneByPlayerID finds the current zone of a player
 func (gs *GameServer) getZoneByPlayerID(playerID string) *Zone {
-	for _, zone := range gs.Zones { // Iterate over map values
-		if _, exists := zone.Players[playerID]; exists {
-			return zone
-		}
+	zoneID, exists := gs.Sessions.Zone(playerID)
+	if !exists {
+		return nil
 	}
-	return nil
+	return gs.Zones[zoneID]
 }
 
 func getZoneConfigByZoneID(zoneId int) (ZoneConfig, error) {
@@ -601,15 +640,70 @@ func getZoneConfigByZoneID(zoneId int) (ZoneConfig, error) {
 
 
 func main() {
+	slowClientPolicy := flag.String("slow-client-policy", "drop", "what to do when a client's send queue is full: drop (stale snapshots) or disconnect")
+	flag.IntVar(&SendQueueSize, "send-queue-size", SendQueueSize, "max outbound frames queued per client")
+	flag.IntVar(&ReliableHeadroom, "reliable-headroom", ReliableHeadroom, "frames of reliable messages queued past -send-queue-size under the drop policy")
+	flag.DurationVar(&WriteWait, "write-timeout", WriteWait, "write deadline for each outbound frame")
+	flag.DurationVar(&PingInterval, "ping-interval", PingInterval, "how often clients are pinged to measure latency")
+	flag.DurationVar(&IdleTimeout, "idle-timeout", IdleTimeout, "disconnect clients that send nothing for this long")
+	flag.DurationVar(&ResumeGraceWindow, "resume-grace", ResumeGraceWindow, "how long a dropped session can be resumed")
+	interestRadius := flag.Float64("interest-radius", float64(InterestRadius), "how far in pixels players see other entities")
+	flag.IntVar(&PathSearchBudget, "path-budget", PathSearchBudget, "tiles the pathfinding of each zone can expand per tick")
+	flag.IntVar(&ClientByteBudget, "client-budget", ClientByteBudget, "estimated bytes of entity updates sent to each client per tick")
+	resumeSecretFlag := flag.String("resume-secret", "", "key used to sign resume tokens (random per process if empty)")
+	worldFile := flag.String("world", "world.json", "world definition file, see world_file.go")
+	collisionLayers := flag.String("collision-layers", strings.Join(CollisionLayers, ","), "comma separated names of the tilemap layers that are walls")
+	flag.StringVar(&TilemapDir, "tilemaps", TilemapDir, "directory of the Tiled maps, TMX or JSON, named by the world's tilemap refs")
+	addr := flag.String("addr", ":8080", "address the websocket server listens on")
+	// the validate subcommand can come before its flags or after them
+	args := os.Args[1:]
+	validate := len(args) > 0 && args[0] == "validate"
+	if validate {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	validate = validate || flag.Arg(0) == "validate"
+	CollisionLayers = strings.FieldsFunc(*collisionLayers, func(r rune) bool { return r == ',' })
+
+	if validate {
+		if !validateWorld(os.Stdout, *worldFile) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := initResumeSecret(*resumeSecretFlag); err != nil {
+		log.Fatalf("Failed to initialise resume tokens: %v", err)
+	}
+
+	policy, err := ParseSlowClientPolicy(*slowClientPolicy)
+	if err != nil {
+		log.Fatalf("Invalid -slow-client-policy: %v", err)
+	}
+	SlowClientAction = policy
+	InterestRadius = float32(*interestRadius)
+
 	runtime.GOMAXPROCS(runtime.NumCPU()) // Adapt to available cores
 
+	definition, err := LoadWorldDefinition(*worldFile)
+	if err != nil {
+		log.Fatalf("Failed to load the world: %v", err)
+	}
+	if err := InitializeWorld(definition); err != nil {
+		log.Fatalf("Invalid world %s: %v", *worldFile, err)
+	}
+	if err := LoadTilemaps(); err != nil {
+		log.Fatalf("Failed to load the tilemaps: %v", err)
+	}
+
 	gs := NewGameServer()
 
 	gs.StartWorkers()
 
 	http.HandleFunc("/ws", gs.handleWebSocket)
-	log.Println("Starting WebSocket server on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	http.HandleFunc("/admin/sessions", gs.handleAdminSessions)
+	log.Println("Starting WebSocket server on", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
 		log.Fatalf("WebSocket server failed: %v", err)
 	}
 }
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A small MessagePack (https://msgpack.org) implementation covering what the
+// wire protocol needs. Values are encoded the way encoding/json would see
+// them: structs become maps keyed by their json tag names (honouring
+// omitempty and "-"), so both wire formats carry the same document. The one
+// difference is []byte, which goes out as bin rather than as encoding/json's
+// base64 string.
+
+// appendMsgpack appends the MessagePack encoding of v to buf
+func appendMsgpack(buf []byte, v interface{}) ([]byte, error) {
+	// fast paths for the types that make up most of a snapshot
+	switch value := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case string:
+		return appendMsgpackString(buf, value), nil
+	case bool:
+		return appendMsgpackBool(buf, value), nil
+	case int:
+		return appendMsgpackInt(buf, int64(value)), nil
+	case int64:
+		return appendMsgpackInt(buf, value), nil
+	case float32:
+		return appendMsgpackFloat32(buf, value), nil
+	case float64:
+		return appendMsgpackFloat64(buf, value), nil
+	case []byte:
+		if value == nil {
+			return append(buf, 0xc0), nil
+		}
+		return appendMsgpackBin(buf, value), nil
+	case map[string]interface{}:
+		return appendMsgpackMap(buf, value)
+	}
+	return appendMsgpackValue(buf, reflect.ValueOf(v))
+}
+
+func appendMsgpackMap(buf []byte, m map[string]interface{}) ([]byte, error) {
+	if m == nil {
+		return append(buf, 0xc0), nil
+	}
+	buf = appendMsgpackMapHeader(buf, len(m))
+	for key, element := range m {
+		buf = appendMsgpackString(buf, key)
+		var err error
+		if buf, err = appendMsgpack(buf, element); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+func appendMsgpackValue(buf []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return append(buf, 0xc0), nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		return appendMsgpackValue(buf, v.Elem())
+	case reflect.Bool:
+		return appendMsgpackBool(buf, v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendMsgpackUint(buf, v.Uint()), nil
+	case reflect.Float32:
+		return appendMsgpackFloat32(buf, float32(v.Float())), nil
+	case reflect.Float64:
+		return appendMsgpackFloat64(buf, v.Float()), nil
+	case reflect.String:
+		return appendMsgpackString(buf, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendMsgpackBin(buf, v.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		buf = appendMsgpackArrayHeader(buf, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if buf, err = appendMsgpackValue(buf, v.Index(i)); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return buf, fmt.Errorf("msgpack: unsupported map key type %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		// the changed fields of delta snapshots, see delta.go
+		if m, ok := v.Interface().(map[string]interface{}); ok {
+			return appendMsgpackMap(buf, m)
+		}
+		buf = appendMsgpackMapHeader(buf, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			buf = appendMsgpackString(buf, iter.Key().String())
+			var err error
+			if buf, err = appendMsgpackValue(buf, iter.Value()); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	case reflect.Struct:
+		fields := wireFieldsFor(v.Type())
+		// the header takes a byte for up to 15 entries, patched in once the
+		// fields left out are known rather than walking them twice
+		if len(fields) > 15 {
+			count := 0
+			for _, field := range fields {
+				if fieldValue, ok := field.value(v); ok && (!field.omitEmpty || !fieldValue.IsZero()) {
+					count++
+				}
+			}
+			buf = appendMsgpackMapHeader(buf, count)
+		} else {
+			buf = append(buf, 0x80)
+		}
+		header, count := len(buf)-1, 0
+		for _, field := range fields {
+			fieldValue, ok := field.value(v)
+			if !ok || field.omitEmpty && fieldValue.IsZero() {
+				continue
+			}
+			count++
+			buf = appendMsgpackString(buf, field.name)
+			var err error
+			if buf, err = appendMsgpackValue(buf, fieldValue); err != nil {
+				return buf, err
+			}
+		}
+		if len(fields) <= 15 {
+			buf[header] = 0x80 | byte(count)
+		}
+		return buf, nil
+	default:
+		return buf, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+}
+
+// wireField is an exported struct field as encoding/json would name it,
+// fields of embedded structs included.
+type wireField struct {
+	index     []int // as for reflect.Value.FieldByIndex
+	name      string
+	omitEmpty bool
+
+	tagged bool // named by its json tag, which wins conflicts
+}
+
+// value returns the field of struct v, false if it is in an embedded struct
+// behind a nil pointer, which encoding/json leaves out
+func (f wireField) value(v reflect.Value) (reflect.Value, bool) {
+	for i, index := range f.index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(index)
+	}
+	return v, true
+}
+
+var wireFieldCache sync.Map // reflect.Type -> []wireField
+
+// wireFieldsFor returns the fields of a struct type that encoding/json
+// encodes, in the same order. Like encoding/json it flattens the fields of
+// embedded structs without a json name into the outer struct, and where
+// several fields end up with a name the shallowest wins, then the one
+// named by its tag; if that leaves more than one, none is encoded.
+func wireFieldsFor(t reflect.Type) []wireField {
+	if cached, ok := wireFieldCache.Load(t); ok {
+		return cached.([]wireField)
+	}
+	var candidates []wireField
+	collectWireFields(t, nil, map[reflect.Type]bool{t: true}, &candidates)
+
+	dominant := make(map[string]int, len(candidates))
+	conflicted := make(map[string]bool)
+	for i, field := range candidates {
+		best, seen := dominant[field.name]
+		switch {
+		case !seen || len(field.index) < len(candidates[best].index):
+			dominant[field.name] = i
+			conflicted[field.name] = false
+		case len(field.index) > len(candidates[best].index):
+		case field.tagged && !candidates[best].tagged:
+			dominant[field.name] = i
+			conflicted[field.name] = false
+		case field.tagged == candidates[best].tagged:
+			conflicted[field.name] = true
+		}
+	}
+	fields := make([]wireField, 0, len(dominant))
+	for i, field := range candidates {
+		if dominant[field.name] == i && !conflicted[field.name] {
+			fields = append(fields, field)
+		}
+	}
+	wireFieldCache.Store(t, fields)
+	return fields
+}
+
+// collectWireFields appends every field encoding/json could encode for
+// struct type t, depth first so they come in encoding/json's order. visiting
+// holds the embedded structs being walked, so embedding cycles end.
+func collectWireFields(t reflect.Type, index []int, visiting map[reflect.Type]bool, fields *[]wireField) {
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		fieldType := structField.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		// unexported embedded structs still promote their exported fields
+		embeddedStruct := structField.Anonymous && fieldType.Kind() == reflect.Struct
+		if !structField.IsExported() && !embeddedStruct {
+			continue
+		}
+		tag, tagged := structField.Tag.Lookup("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if name == "" && embeddedStruct {
+			if !visiting[fieldType] {
+				visiting[fieldType] = true
+				collectWireFields(fieldType, fieldIndex, visiting, fields)
+				delete(visiting, fieldType)
+			}
+			continue
+		}
+		if !structField.IsExported() {
+			continue
+		}
+		*fields = append(*fields, wireField{
+			index:     fieldIndex,
+			name:      cmp.Or(name, structField.Name),
+			omitEmpty: tagged && strings.Contains(options, "omitempty"),
+			tagged:    name != "",
+		})
+	}
+}
+
+func appendMsgpackBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 0xc3)
+	}
+	return append(buf, 0xc2)
+}
+
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgpackUint(buf, uint64(i))
+	case i >= -32:
+		return append(buf, byte(i))
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgpackUint(buf []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(buf, byte(u))
+	case u <= math.MaxUint8:
+		return append(buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(u))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), u)
+	}
+}
+
+func appendMsgpackFloat32(buf []byte, f float32) []byte {
+	return binary.BigEndian.AppendUint32(append(buf, 0xca), math.Float32bits(f))
+}
+
+func appendMsgpackFloat64(buf []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendMsgpackBin(buf []byte, b []byte) []byte {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xc5), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xc6), uint32(n))
+	}
+	return append(buf, b...)
+}
+
+func appendMsgpackArrayHeader(buf []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xdc), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, 0xdd), uint32(n))
+	}
+}
+
+func appendMsgpackMapHeader(buf []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xde), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, 0xdf), uint32(n))
+	}
+}
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+// decodeMsgpack decodes a single MessagePack value into the generic types
+// encoding/json produces (map[string]interface{}, []interface{}, float64,
+// string, bool, nil), so decoded client messages can go through the same
+// strict validation as JSON ones. bin decodes to []byte, which re-encodes as
+// the base64 string a JSON client would have sent.
+func decodeMsgpack(data []byte) (interface{}, error) {
+	value, rest, err := readMsgpack(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("msgpack: unexpected data after value")
+	}
+	return value, nil
+}
+
+// msgpackMaxDepth stops hostile input from nesting us into a stack overflow
+const msgpackMaxDepth = 32
+
+func readMsgpack(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > msgpackMaxDepth {
+		return nil, nil, errors.New("msgpack: nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, errMsgpackShort
+	}
+	tag, data := data[0], data[1:]
+
+	switch {
+	case tag <= 0x7f:
+		return float64(tag), data, nil
+	case tag >= 0xe0:
+		return float64(int8(tag)), data, nil
+	case tag&0xe0 == 0xa0:
+		return readMsgpackString(data, int(tag&0x1f))
+	case tag&0xf0 == 0x90:
+		return readMsgpackArray(data, int(tag&0x0f), depth)
+	case tag&0xf0 == 0x80:
+		return readMsgpackMap(data, int(tag&0x0f), depth)
+	}
+
+	switch tag {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, rest, err := readMsgpackUint(data, 1<<(tag-0xc4))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgpackBin(rest, int(n))
+	case 0xca:
+		bits, rest, err := readMsgpackUint(data, 4)
+		return float64(math.Float32frombits(uint32(bits))), rest, err
+	case 0xcb:
+		bits, rest, err := readMsgpackUint(data, 8)
+		return math.Float64frombits(bits), rest, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, rest, err := readMsgpackUint(data, 1<<(tag-0xcc))
+		return float64(u), rest, err
+	case 0xd0:
+		u, rest, err := readMsgpackUint(data, 1)
+		return float64(int8(u)), rest, err
+	case 0xd1:
+		u, rest, err := readMsgpackUint(data, 2)
+		return float64(int16(u)), rest, err
+	case 0xd2:
+		u, rest, err := readMsgpackUint(data, 4)
+		return float64(int32(u)), rest, err
+	case 0xd3:
+		u, rest, err := readMsgpackUint(data, 8)
+		return float64(int64(u)), rest, err
+	case 0xd9, 0xda, 0xdb:
+		n, rest, err := readMsgpackUint(data, 1<<(tag-0xd9))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgpackString(rest, int(n))
+	case 0xdc, 0xdd:
+		n, rest, err := readMsgpackUint(data, 2<<(tag-0xdc))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgpackArray(rest, int(n), depth)
+	case 0xde, 0xdf:
+		n, rest, err := readMsgpackUint(data, 2<<(tag-0xde))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgpackMap(rest, int(n), depth)
+	}
+	return nil, nil, fmt.Errorf("msgpack: unsupported type 0x%02x", tag)
+}
+
+func readMsgpackUint(data []byte, size int) (uint64, []byte, error) {
+	if len(data) < size {
+		return 0, nil, errMsgpackShort
+	}
+	var u uint64
+	for _, b := range data[:size] {
+		u = u<<8 | uint64(b)
+	}
+	return u, data[size:], nil
+}
+
+func readMsgpackString(data []byte, n int) (interface{}, []byte, error) {
+	if n < 0 || len(data) < n {
+		return nil, nil, errMsgpackShort
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+func readMsgpackBin(data []byte, n int) (interface{}, []byte, error) {
+	if n < 0 || len(data) < n {
+		return nil, nil, errMsgpackShort
+	}
+	return append([]byte(nil), data[:n]...), data[n:], nil
+}
+
+func readMsgpackArray(data []byte, n int, depth int) (interface{}, []byte, error) {
+	// every element takes at least one byte
+	if n < 0 || n > len(data) {
+		return nil, nil, errMsgpackShort
+	}
+	array := make([]interface{}, n)
+	for i := range array {
+		var err error
+		if array[i], data, err = readMsgpack(data, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return array, data, nil
+}
+
+func readMsgpackMap(data []byte, n int, depth int) (interface{}, []byte, error) {
+	// every entry takes at least two bytes
+	if n < 0 || 2*n > len(data) {
+		return nil, nil, errMsgpackShort
+	}
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, rest, err := readMsgpack(data, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			return nil, nil, errors.New("msgpack: map keys must be strings")
+		}
+		if object[keyString], data, err = readMsgpack(rest, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return object, data, nil
+}
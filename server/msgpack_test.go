@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type wireTestPosition struct {
+	X, Y  float32
+	Zone  int `json:"zoneId"`
+	Label string
+}
+
+type wireTestStats struct {
+	HP    int `json:"hp"`
+	Label string
+}
+
+type wireTestTagged struct {
+	Note string `json:"note,omitempty"`
+}
+
+type wireTestHidden struct {
+	Secret string `json:"secret"`
+}
+
+type wireTestEntity struct {
+	ID string `json:"id"`
+	wireTestPosition
+	*wireTestStats
+	Tagged  wireTestTagged `json:"tagged"`
+	Missing *wireTestHidden
+	Label   string `json:"label"`
+	Blob    []byte `json:"blob,omitempty"`
+	skipped int
+	Ignored int `json:"-"`
+}
+
+// TestMsgpackMatchesJSON checks that both wire formats carry the same
+// documents, embedded structs flattened as encoding/json flattens them.
+// []byte is bin in MessagePack and base64 in JSON, so the decoded document
+// is compared the way the server reads client frames: re-encoded as JSON.
+func TestMsgpackMatchesJSON(t *testing.T) {
+	entities := []wireTestEntity{
+		{ID: "a", wireTestPosition: wireTestPosition{X: 1.5, Y: -2, Zone: 3, Label: "position"}, wireTestStats: &wireTestStats{HP: 10, Label: "stats"}, Label: "entity"},
+		{ID: "b", wireTestPosition: wireTestPosition{Label: "conflict"}, Tagged: wireTestTagged{Note: "note"}, Missing: &wireTestHidden{Secret: "s"}, skipped: 1, Ignored: 2},
+		{ID: "c", Blob: []byte{0, 1, 0xfe, 0xff}},
+		{ID: "d", Blob: make([]byte, 300)},
+	}
+	for _, entity := range entities {
+		asJSON, err := json.Marshal(entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want interface{}
+		if err := json.Unmarshal(asJSON, &want); err != nil {
+			t.Fatal(err)
+		}
+
+		asMsgpack, err := appendMsgpack(nil, entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeMsgpack(asMsgpack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entity.Blob != nil {
+			if blob, _ := decoded.(map[string]interface{})["blob"].([]byte); !bytes.Equal(blob, entity.Blob) {
+				t.Errorf("blob decoded as %#v, want bin %v", decoded.(map[string]interface{})["blob"], entity.Blob)
+			}
+		}
+		reencoded, err := json.Marshal(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got interface{}
+		if err := json.Unmarshal(reencoded, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("msgpack document %s, JSON %s", reencoded, asJSON)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"math"
+)
+
+// Pathfinding
+//
+// Pursuing enemies find their way around walls with A* over the tiles of
+// their zone, see collision.go for which tiles are walls. Moves go to any of
+// the 8 neighbouring tiles, diagonals only when both tiles beside the corner
+// are free so paths never clip a wall's corner.
+//
+// Every zone has a pathfinder used by its worker only. It caches paths by
+// their start and goal tiles; walls never move, so a path stays good until
+// its entry expires, and every tile along a found path is cached as the
+// start of the rest of it, which is what enemies following one another ask
+// for. Searches are bounded twice: each expands at most MaxPathNodes tiles,
+// returning the way to the tile closest to the goal when it gives up, and
+// all searches in a zone expand at most PathSearchBudget tiles per tick.
+// Enemies that find the budget spent, or whose search it cuts short, head
+// straight for their target and ask again next tick; only searches that
+// reached the goal or gave up after MaxPathNodes tiles are cached. Targets
+// over the zone's border are searched for at the nearest free tile on this
+// side of it, from where enemies head straight for them.
+
+// PathSearchBudget is how many tiles a zone's searches can expand per tick,
+// overridable from the command line
+var PathSearchBudget = 10000
+
+// MaxPathNodes is how many tiles a single search can expand
+const MaxPathNodes = 2048
+
+// pathCacheTicks is how long a cached path is kept, in ticks
+const pathCacheTicks = 50
+
+// maxCachedPaths is how many cached paths a zone keeps before dropping the
+// expired ones
+const maxCachedPaths = 20000
+
+// Move costs, a diagonal is about √2 times an orthogonal step
+const (
+	straightCost = 10
+	diagonalCost = 14
+)
+
+type pathKey struct {
+	from, to TilePosition
+}
+
+type cachedPath struct {
+	path    []TilePosition
+	expires uint64
+}
+
+// pathfinder finds paths for the enemies of one zone
+type pathfinder struct {
+	cache     map[pathKey]cachedPath
+	nextSweep uint64 // when expired paths are dropped next, once there are too many
+	tick      uint64
+	budget    int
+
+	// search state reused by every search, nodes by tile in the zone
+	nodes  []pathNode
+	open   []int32
+	search uint32
+}
+
+// startTick resets the search budget, called by the zone's worker at the
+// start of a tick
+func (pf *pathfinder) startTick(tick uint64) {
+	pf.tick = tick
+	pf.budget = PathSearchBudget
+	if len(pf.cache) > maxCachedPaths && tick >= pf.nextSweep {
+		for key, entry := range pf.cache {
+			if entry.expires <= tick {
+				delete(pf.cache, key)
+			}
+		}
+		pf.nextSweep = tick + pathCacheTicks
+	}
+}
+
+// find returns the tiles to walk through from one tile of the zone to
+// another, not including the start, and false when the tick's budget is
+// spent before the search is done. The path leads as close to the goal as
+// the search got when there is no way all the way there.
+func (pf *pathfinder) find(zone *Zone, from, to TilePosition) ([]TilePosition, bool) {
+	bounds := zone.tileBounds()
+	to = goalWithin(bounds, to)
+	key := pathKey{from, to}
+	if entry, cached := pf.cache[key]; cached && entry.expires > pf.tick {
+		return entry.path, true
+	}
+	if pf.budget <= 0 {
+		return nil, false
+	}
+
+	maxNodes := min(MaxPathNodes, pf.budget)
+	path, expanded, done := pf.findPath(bounds, from, to, maxNodes)
+	pf.budget -= expanded
+	if !done && maxNodes < MaxPathNodes {
+		// cut short by the budget, not the search's own bound
+		return nil, false
+	}
+
+	if pf.cache == nil {
+		pf.cache = make(map[pathKey]cachedPath)
+	}
+	expires := pf.tick + pathCacheTicks
+	pf.cache[key] = cachedPath{path: path, expires: expires}
+	// the last tile is the goal or where the search gave up, not a start
+	for i := 0; i < len(path)-1; i++ {
+		pf.cache[pathKey{path[i], to}] = cachedPath{path: path[i+1:], expires: expires}
+	}
+	return path, true
+}
+
+// tileRect is a rectangle of world tiles, Max excluded
+type tileRect struct {
+	Min, Max TilePosition
+}
+
+func (r tileRect) contains(tile TilePosition) bool {
+	return tile.X >= r.Min.X && tile.X < r.Max.X && tile.Y >= r.Min.Y && tile.Y < r.Max.Y
+}
+
+// tileBounds returns the world tiles of the zone
+func (z *Zone) tileBounds() tileRect {
+	minX, minY := int(z.WorldX)/TileSize, int(z.WorldY)/TileSize
+	return tileRect{
+		Min: TilePosition{X: minX, Y: minY},
+		Max: TilePosition{X: minX + ZoneWidthPixels/TileSize, Y: minY + ZoneHeightPixels/TileSize},
+	}
+}
+
+// goalSearchRadius is how many tiles from the edge of a zone goalWithin
+// looks for a free tile
+const goalSearchRadius = 8
+
+// goalWithin returns the tile to search for on the way to a goal: the goal
+// itself when it is inside bounds, otherwise the free tile of bounds nearest
+// to it, so a target over the zone's border is headed for through the
+// border instead of searched for in vain over MaxPathNodes tiles
+func goalWithin(bounds tileRect, goal TilePosition) TilePosition {
+	if bounds.contains(goal) {
+		return goal
+	}
+	clamped := TilePosition{
+		X: min(max(goal.X, bounds.Min.X), bounds.Max.X-1),
+		Y: min(max(goal.Y, bounds.Min.Y), bounds.Max.Y-1),
+	}
+	walkable := zoneWalkable(bounds)
+	if walkable(clamped.X-bounds.Min.X, clamped.Y-bounds.Min.Y) {
+		return clamped
+	}
+	nearest, nearestDistance := clamped, math.MaxInt
+	for y := clamped.Y - goalSearchRadius; y <= clamped.Y+goalSearchRadius; y++ {
+		for x := clamped.X - goalSearchRadius; x <= clamped.X+goalSearchRadius; x++ {
+			tile := TilePosition{X: x, Y: y}
+			if !walkable(x-bounds.Min.X, y-bounds.Min.Y) {
+				continue
+			}
+			if distance := octileDistance(tile, goal); distance < nearestDistance {
+				nearest, nearestDistance = tile, distance
+			}
+		}
+	}
+	return nearest
+}
+
+// tileAt returns the world tile containing x, y
+func tileAt(x, y float32) TilePosition {
+	return TilePosition{
+		X: int(math.Floor(float64(x / float32(TileSize)))),
+		Y: int(math.Floor(float64(y / float32(TileSize)))),
+	}
+}
+
+// tileCentre returns the middle of a world tile in pixels
+func tileCentre(tile TilePosition) (float32, float32) {
+	return (float32(tile.X) + 0.5) * float32(TileSize), (float32(tile.Y) + 0.5) * float32(TileSize)
+}
+
+// octileDistance is the cost of the shortest way between two tiles with no
+// walls in between
+func octileDistance(a, b TilePosition) int {
+	dx, dy := a.X-b.X, a.Y-b.Y
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	return straightCost*max(dx, dy) + (diagonalCost-straightCost)*min(dx, dy)
+}
+
+// zoneWalkable returns a test of whether the tile at x, y from the top left
+// corner of a zone is free to walk on. The inside of the zone is looked up
+// in its map directly, its edges go through solidTile for the seams with
+// its neighbours.
+func zoneWalkable(bounds tileRect) func(x, y int) bool {
+	width, height := bounds.Max.X-bounds.Min.X, bounds.Max.Y-bounds.Min.Y
+	tilemap, _ := zoneTilemapAt(bounds.Min.X/max(World.ZoneSize, 1), bounds.Min.Y/max(World.ZoneSize, 1))
+	if tilemap != nil && (tilemap.Width != width || tilemap.Height != height) {
+		tilemap = nil
+	}
+	return func(x, y int) bool {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return false
+		}
+		if tilemap == nil || x == 0 || y == 0 || x == width-1 || y == height-1 {
+			return !solidTile(bounds.Min.X+x, bounds.Min.Y+y)
+		}
+		return !tilemap.Collision[y*width+x]
+	}
+}
+
+// pathNode is the search state of a tile
+type pathNode struct {
+	cost      int32 // from the start
+	estimate  int32 // cost plus the heuristic to the goal
+	parent    int32 // tile index, -1 for the start
+	heapIndex int32 // -1 once expanded
+	search    uint32
+}
+
+var pathDirections = [8]TilePosition{
+	{X: 1}, {X: -1}, {Y: 1}, {Y: -1},
+	{X: 1, Y: 1}, {X: 1, Y: -1}, {X: -1, Y: 1}, {X: -1, Y: -1},
+}
+
+// findPath runs A* from one tile to another within bounds, expanding at
+// most maxNodes tiles. Returns the path without the start tile, leading to
+// the reached tile closest to the goal if the goal wasn't reached, how many
+// tiles it expanded and whether the search is done, having reached the goal
+// or every tile it could, rather than stopped at maxNodes.
+func (pf *pathfinder) findPath(bounds tileRect, from, to TilePosition, maxNodes int) ([]TilePosition, int, bool) {
+	if from == to || !bounds.contains(from) {
+		return nil, 0, true
+	}
+	width, height := bounds.Max.X-bounds.Min.X, bounds.Max.Y-bounds.Min.Y
+	if len(pf.nodes) != width*height {
+		pf.nodes = make([]pathNode, width*height)
+		pf.search = 0
+	}
+	// nodes last touched by an older search count as unseen
+	pf.search++
+	pf.open = pf.open[:0]
+
+	walkable := zoneWalkable(bounds)
+	heuristic := func(x, y int) int32 {
+		return int32(octileDistance(TilePosition{X: x, Y: y}, TilePosition{X: to.X - bounds.Min.X, Y: to.Y - bounds.Min.Y}))
+	}
+
+	startX, startY := from.X-bounds.Min.X, from.Y-bounds.Min.Y
+	goal := int32((to.Y-bounds.Min.Y)*width + to.X - bounds.Min.X)
+	start := int32(startY*width + startX)
+	pf.nodes[start] = pathNode{estimate: heuristic(startX, startY), parent: -1, search: pf.search}
+	pf.push(start)
+	closest := start
+	expanded := 0
+	reached := false
+
+	for len(pf.open) > 0 && expanded < maxNodes {
+		index := pf.pop()
+		node := &pf.nodes[index]
+		expanded++
+		if index == goal {
+			closest, reached = index, true
+			break
+		}
+		if best := &pf.nodes[closest]; node.estimate-node.cost < best.estimate-best.cost {
+			closest = index
+		}
+
+		x, y := int(index)%width, int(index)/width
+		for i, direction := range pathDirections {
+			nextX, nextY := x+direction.X, y+direction.Y
+			if !walkable(nextX, nextY) {
+				continue
+			}
+			cost := node.cost + straightCost
+			if i >= 4 {
+				// no cutting corners: both tiles beside the diagonal must be free
+				if !walkable(nextX, y) || !walkable(x, nextY) {
+					continue
+				}
+				cost = node.cost + diagonalCost
+			}
+
+			next := int32(nextY*width + nextX)
+			neighbour := &pf.nodes[next]
+			if neighbour.search != pf.search {
+				*neighbour = pathNode{cost: cost, estimate: cost + heuristic(nextX, nextY), parent: index, search: pf.search}
+				pf.push(next)
+			} else if neighbour.heapIndex >= 0 && cost < neighbour.cost {
+				neighbour.estimate += cost - neighbour.cost
+				neighbour.cost = cost
+				neighbour.parent = index
+				pf.up(int(neighbour.heapIndex))
+			}
+		}
+	}
+
+	var path []TilePosition
+	for index := closest; pf.nodes[index].parent >= 0; index = pf.nodes[index].parent {
+		path = append(path, TilePosition{X: bounds.Min.X + int(index)%width, Y: bounds.Min.Y + int(index)/width})
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, expanded, reached || len(pf.open) == 0
+}
+
+// The open set is a binary heap of tile indices, ordered by estimate and
+// then deepest first
+
+func (pf *pathfinder) less(i, j int) bool {
+	a, b := &pf.nodes[pf.open[i]], &pf.nodes[pf.open[j]]
+	if a.estimate != b.estimate {
+		return a.estimate < b.estimate
+	}
+	return a.cost > b.cost
+}
+
+func (pf *pathfinder) swap(i, j int) {
+	pf.open[i], pf.open[j] = pf.open[j], pf.open[i]
+	pf.nodes[pf.open[i]].heapIndex = int32(i)
+	pf.nodes[pf.open[j]].heapIndex = int32(j)
+}
+
+func (pf *pathfinder) push(index int32) {
+	pf.open = append(pf.open, index)
+	pf.nodes[index].heapIndex = int32(len(pf.open) - 1)
+	pf.up(len(pf.open) - 1)
+}
+
+func (pf *pathfinder) pop() int32 {
+	last := len(pf.open) - 1
+	pf.swap(0, last)
+	index := pf.open[last]
+	pf.open = pf.open[:last]
+	pf.nodes[index].heapIndex = -1
+	for i := 0; ; {
+		smallest, left, right := i, 2*i+1, 2*i+2
+		if left < last && pf.less(left, smallest) {
+			smallest = left
+		}
+		if right < last && pf.less(right, smallest) {
+			smallest = right
+		}
+		if smallest == i {
+			break
+		}
+		pf.swap(i, smallest)
+		i = smallest
+	}
+	return index
+}
+
+func (pf *pathfinder) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !pf.less(i, parent) {
+			break
+		}
+		pf.swap(i, parent)
+		i = parent
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// TestFindPath checks the searches of a walled zone next to an open one
+func TestFindPath(t *testing.T) {
+	ring := func(centre TilePosition) []TilePosition {
+		var walls []TilePosition
+		for _, direction := range pathDirections {
+			walls = append(walls, TilePosition{X: centre.X + direction.X, Y: centre.Y + direction.Y})
+		}
+		return walls
+	}
+
+	for _, test := range []struct {
+		name     string
+		walls    []TilePosition
+		from, to TilePosition
+		want     TilePosition // where the path ends
+		length   int          // how many steps it takes, 0 for any
+	}{
+		{name: "reaches the goal", from: TilePosition{X: 1, Y: 1}, to: TilePosition{X: 5, Y: 5}, want: TilePosition{X: 5, Y: 5}, length: 4},
+		{
+			name:  "detours around a wall",
+			walls: []TilePosition{{X: 4, Y: 1}, {X: 4, Y: 2}, {X: 4, Y: 3}, {X: 4, Y: 4}, {X: 4, Y: 5}},
+			from:  TilePosition{X: 2, Y: 2}, to: TilePosition{X: 6, Y: 2}, want: TilePosition{X: 6, Y: 2}, length: 10,
+		},
+		{
+			name:  "doesn't cut corners",
+			walls: []TilePosition{{X: 3, Y: 3}},
+			from:  TilePosition{X: 3, Y: 2}, to: TilePosition{X: 4, Y: 3}, want: TilePosition{X: 4, Y: 3}, length: 2,
+		},
+		{
+			// the closest tiles are two steps from the goal, beside the ring
+			name:  "unreachable goal",
+			walls: ring(TilePosition{X: 4, Y: 4}),
+			from:  TilePosition{X: 1, Y: 1}, to: TilePosition{X: 4, Y: 4},
+		},
+		{name: "goal over the border", from: TilePosition{X: 2, Y: 3}, to: TilePosition{X: 12, Y: 3}, want: TilePosition{X: 7, Y: 3}, length: 5},
+		// the nearest tile on the zone's edge is the world's wall above it
+		{name: "goal beyond a wall", from: TilePosition{X: 2, Y: 3}, to: TilePosition{X: 3, Y: -5}, want: TilePosition{X: 3, Y: 1}, length: 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tilemaps := map[string]*Tilemap{}
+			useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+			tilemaps["a"], tilemaps["b"] = borderTilemap(test.walls...), borderTilemap()
+			zone := &Zone{ID: 1}
+			bounds := zone.tileBounds()
+			walkable := zoneWalkable(bounds)
+			free := func(tile TilePosition) bool { return walkable(tile.X-bounds.Min.X, tile.Y-bounds.Min.Y) }
+
+			zone.Paths.startTick(1)
+			path, ok := zone.Paths.find(zone, test.from, test.to)
+			if !ok {
+				t.Fatal("the search ran out of budget")
+			}
+			// every search here stays within the 64 tiles of the zone
+			if spent := PathSearchBudget - zone.Paths.budget; spent > 64 {
+				t.Errorf("the search expanded %d tiles", spent)
+			}
+			if len(path) == 0 {
+				t.Fatal("no path")
+			}
+
+			at := test.from
+			for _, step := range path {
+				dx, dy := step.X-at.X, step.Y-at.Y
+				if max(dx, -dx, dy, -dy) != 1 || !free(step) {
+					t.Fatalf("step from %v to %v in %v", at, step, path)
+				}
+				if dx != 0 && dy != 0 && (!free(TilePosition{X: step.X, Y: at.Y}) || !free(TilePosition{X: at.X, Y: step.Y})) {
+					t.Fatalf("the step from %v to %v cuts a corner", at, step)
+				}
+				at = step
+			}
+			if test.want == (TilePosition{}) {
+				if distance := octileDistance(at, test.to); distance != 2*straightCost {
+					t.Errorf("the path ends at %v, %d from the goal, want %d", at, distance, 2*straightCost)
+				}
+			} else if at != test.want {
+				t.Errorf("the path ends at %v, want %v", at, test.want)
+			}
+			if test.length != 0 && len(path) != test.length {
+				t.Errorf("the path takes %d steps, want %d: %v", len(path), test.length, path)
+			}
+		})
+	}
+}
+
+// TestFindPathCached checks that paths, and what is left of them along the
+// way, are found again without searching
+func TestFindPathCached(t *testing.T) {
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+	tilemaps["a"] = borderTilemap(TilePosition{X: 4, Y: 2}, TilePosition{X: 4, Y: 3}, TilePosition{X: 4, Y: 4})
+	zone := &Zone{ID: 1}
+	from, to := TilePosition{X: 2, Y: 3}, TilePosition{X: 6, Y: 3}
+
+	zone.Paths.startTick(1)
+	path, _ := zone.Paths.find(zone, from, to)
+	if len(path) < 2 {
+		t.Fatalf("path %v", path)
+	}
+
+	zone.Paths.startTick(2)
+	again, ok := zone.Paths.find(zone, from, to)
+	if !ok || !slices.Equal(again, path) {
+		t.Errorf("found %v again, want %v", again, path)
+	}
+	rest, ok := zone.Paths.find(zone, path[0], to)
+	if !ok || !slices.Equal(rest, path[1:]) {
+		t.Errorf("found %v from the first step, want %v", rest, path[1:])
+	}
+	if zone.Paths.budget != PathSearchBudget {
+		t.Errorf("cached paths spent %d of the budget", PathSearchBudget-zone.Paths.budget)
+	}
+
+	zone.Paths.startTick(1 + pathCacheTicks)
+	zone.Paths.find(zone, from, to)
+	if zone.Paths.budget == PathSearchBudget {
+		t.Error("the path is still cached once expired")
+	}
+}
+
+// BenchmarkPathfinding compares unbounded searches with the budgeted, cached
+// ones, see benchmarkPathfinding
+func BenchmarkPathfinding(b *testing.B) {
+	b.Run("unbounded", func(b *testing.B) { benchmarkPathfinding(b, false) })
+	b.Run("budgeted", func(b *testing.B) { benchmarkPathfinding(b, true) })
+}
+
+// benchmarkPathfinding times the worst tick of pathfinding: 300 enemies
+// chasing 10 players through a zone strewn with short walls, every player
+// having moved far enough since the last tick that all of them look for a
+// new path. Unbounded searches every path with no cache or budget, as the
+// pursuit would without them; budgeted stops at PathSearchBudget and leaves
+// the rest to later ticks.
+func benchmarkPathfinding(b *testing.B, bounded bool) {
+	const enemies, players = 300, 10
+	random := rand.New(rand.NewSource(1))
+
+	saved, savedBudget := World, PathSearchBudget
+	defer func() { World, PathSearchBudget = saved, savedBudget }()
+	size := benchmarkWalledWorld(random)
+	if !bounded {
+		PathSearchBudget = math.MaxInt
+	}
+
+	zone := &Zone{ID: 1, Enemies: make(map[string]*Enemy), EnemyGrid: NewSpatialHash[*Enemy](ZoneCellSize)}
+	targets := make([]TilePosition, players)
+	for i := range targets {
+		targets[i] = TilePosition{X: 20 + random.Intn(size-40), Y: 20 + random.Intn(size-40)}
+	}
+	pursuers := make([]*Enemy, enemies)
+	for i := range pursuers {
+		target := targets[i%players]
+		x, y := tileCentre(TilePosition{X: target.X - 8 + random.Intn(17), Y: target.Y - 8 + random.Intn(17)})
+		pursuers[i] = NewEnemy(zone.ID, x, y, "easy")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !bounded {
+			zone.Paths = pathfinder{}
+		}
+		zone.Paths.startTick(uint64(i))
+		for t := range targets {
+			targets[t].X += RepathTiles + 1
+			if targets[t].X >= size-20 {
+				targets[t].X -= size - 40
+			}
+		}
+		for j, enemy := range pursuers {
+			x, y := tileCentre(targets[j%players])
+			enemy.followPath(zone, x, y)
+		}
+	}
+	benchmarkSink = len(zone.Paths.cache)
+}
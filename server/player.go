@@ -7,8 +7,6 @@ import (
 	"net/http"
 	"strconv"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 // Player represents a player entity
@@ -36,6 +34,11 @@ type Player struct {
 	BaseAttackTimerS    float32
 	BaseAttackIntervalS float32
 
+	// sequence number of the last input applied, echoed to the client, and
+	// the inputs received but not applied yet, one is applied per tick
+	LastInputSeq uint64
+	inputs       []*InputData
+
 	ToBeRemoved bool
 }
 
@@ -45,6 +48,7 @@ type PlayerUpdate struct {
 	X         float32 `json:"x"`
 	Y         float32 `json:"y"`
 	ZoneID    int     `json:"zoneId"`
+	Tick      uint64  `json:"tick"`
 	Timestamp int64   `json:"timestamp"`
 
 	// species data
@@ -63,6 +67,10 @@ type PlayerUpdate struct {
 	GameLevel               int `json:"gameLevel"`
 	GameXPOnCurrentLevel    int `json:"gameXpOnCurrentLevel"`
 	GameXPTotalForNextLevel int `json:"gameXpTotalForNextLevel"`
+
+	// only set in the update a player receives about themselves, see
+	// GameServer.sendEntityState
+	LastInputSeq uint64 `json:"lastInputSeq,omitempty"`
 }
 
 // PlayableCharacter represents the structure of a playable character sent from the client
@@ -142,145 +150,47 @@ func NewPlayer(id string, zoneID int, x, y float32) *Player {
 	return player
 }
 
-// Update CreatePlayer to remove Conn parameter (handled by ClientManager)
-func (gs *GameServer) CreatePlayer(playerID string, conn *websocket.Conn) *Player {
-	player := NewPlayer(playerID, 0, 1.5*float32(ZoneWidthPixels), 1.5*float32(ZoneHeightPixels))
+// CreatePlayer spawns a new player, the connection is handled by the session registry
+func (gs *GameServer) CreatePlayer(playerID string) *Player {
+	player := NewPlayer(playerID, 0, World.PlayerSpawnX, World.PlayerSpawnY)
 	player.ZoneID = gs.calculateZoneID(player.X, player.Y, player)
+	log.Printf("CreatePlayer(): Player %s spawning in Zone %d", playerID, player.ZoneID)
 
-	initialZone := gs.Zones[player.ZoneID]
-	if initialZone != nil {
-		initialZone.Players[playerID] = player
-		log.Printf("CreatePlayer(): Player %s spawned in Zone %d", playerID, initialZone.ID)
-	} else {
-		log.Printf("Error: Initial zone %d not found for player %s", player.ZoneID, playerID)
-	}
+	// the zone takes ownership once the player is handed over with joinZone
 	return player
 }
 
-func (gs *GameServer) RemovePlayer(playerID string) {
-	zone := gs.getZoneByPlayerID(playerID)
-	if zone != nil {
-		if _, exists := zone.Players[playerID]; exists {
-			delete(zone.Players, playerID)
-			log.Printf("RemovePlayer(): Player %s deleted from Zone %d", playerID, zone.ID)
-		}
+// RemovePlayer deletes a player from the world. Must be called by the zone's worker.
+func (gs *GameServer) RemovePlayer(zone *Zone, playerID string) {
+	if _, exists := zone.Players[playerID]; exists {
+		zone.removePlayer(playerID)
+		gs.Sessions.ClearZone(playerID, zone.ID)
+		log.Printf("RemovePlayer(): Player %s deleted from Zone %d", playerID, zone.ID)
 	}
 }
 
-// HandleInput processes incoming input messages and updates player velocity or performs other actions
+// HandleInput processes incoming messages, queueing inputs for UpdatePlayer to apply one per tick
 func (p *Player) HandleInput(msg Message, gs *GameServer, zone *Zone) []Message {
 	var messages []Message
 	
-	// Handle different message types
-	switch msg.Type {
-	case "input":
-		// Process input for movement and abilities
-		data, ok := msg.Data.(map[string]interface{})
-		if !ok {
-			log.Printf("Invalid input message data for player %s: expected map", p.ID)
-			return messages
-		}
-		
-		keys, ok := data["keys"].(map[string]interface{})
-		if !ok {
-			log.Printf("Invalid input message keys for player %s: expected map", p.ID)
-			return messages
-		}
-
-		// Reset velocity before updating
-		p.VX = 0
-		p.VY = 0
-		speed := float32(PlayerMoveSpeed)
-		
-		// Handle movement keys
-		if w, ok := keys["W"].(bool); ok && w {
-			p.VY = -speed
-			p.Direction = 3
-		}
-		if s, ok := keys["S"].(bool); ok && s {
-			p.VY = speed
-			p.Direction = 0
-		}
-		if a, ok := keys["A"].(bool); ok && a {
-			p.VX = -speed
-			p.Direction = 1
-		}
-		if d, ok := keys["D"].(bool); ok && d {
-			p.VX = speed
-			p.Direction = 2
-		}
-		
-		// Handle spacebar for ability use (e.g., HammerSwing)
-		if space, ok := keys["SPACE"].(bool); ok && space {
-			log.Printf("Player %s pressed SPACE in Zone %d", p.ID, zone.ID)
-			// Execute HammerSwing ability
-			messages = append(messages, ExecuteAbility(p, "HammerSwing", gs, zone)...)
-		}
-		break
-	case "spawnPlayerCharacter":
-		// Handle player spawning message
-		data, ok := msg.Data.(map[string]interface{})
-		if !ok {
-			log.Printf("Invalid selectCharacter message data for player %s: expected map", p.ID)
-			return messages
-		}
-
-		// Convert the data into the PlayableCharacter struct
-		var character PlayableCharacter
-		characterJSON, err := json.Marshal(data)
-		if err != nil {
-			log.Printf("Error marshalling character data for player %s: %v", p.ID, err)
-			return messages
-		}
-
-		// Unmarshal into the PlayableCharacter struct
-		err = json.Unmarshal(characterJSON, &character)
-		if err != nil {
-			log.Printf("Error unmarshalling character data for player %s: %v", p.ID, err)
-			return messages
-		}
-
+	// Handle different message types, payloads were decoded and validated
+	// against the message catalogue in protocol.go
+	switch data := msg.Data.(type) {
+	case *InputData:
+		p.queueInput(data)
+	case *SpawnPlayerCharacterData:
 		// assign species and id
-		p.Species = character.Species
-		p.SpeciesID = character.SpeciesID
+		p.Species = data.Species
+		p.SpeciesID = data.SpeciesID
 
 		// move the player to spawn location
-		p.X = 1.5 * float32(ZoneWidthPixels)
-		p.Y = 1.5 * float32(ZoneHeightPixels)
-
-		// Prepare welcome message with world zones
-		zonesInfo := make([]ZoneInfo, 0, len(gs.Zones))
-		for _, z := range gs.Zones {
-			var config ZoneConfig
-			for _, c := range World.ZoneConfigs {
-				if c.ID == z.ID {
-					config = c
-					break
-				}
-			}
-			zonesInfo = append(zonesInfo, ZoneInfo{
-				ID:         z.ID,
-				TilemapRef: config.TilemapRef,
-				WorldX:     config.WorldX,
-				WorldY:     config.WorldY,
-			})
-		}
+		p.X = World.PlayerSpawnX
+		p.Y = World.PlayerSpawnY
 
-		// Send welcome message
-		batch := []Message{
-			{Type: "welcome", Data: map[string]interface{}{
-				"playerId": p.ID,
-				"zones":    zonesInfo,
-			}},
-		}
-		conn, exists := gs.ClientManager.GetClient(p.ID)
-		if exists && conn != nil {
-			if err := conn.WriteJSON(batch); err != nil {
-				log.Printf("Error sending welcome message to %s: %v", p.ID, err)
-			}
+		// Send welcome message with world zones
+		if session, exists := gs.Sessions.Get(p.ID); exists {
+			messages = append(messages, gs.welcomeMessage(session, false))
 		}
-
-		break
 	default:
 		log.Printf("Unhandled message type for player %s: %s", p.ID, msg.Type)
 	}
@@ -288,20 +198,103 @@ func (p *Player) HandleInput(msg Message, gs *GameServer, zone *Zone) []Message
 	return messages
 }
 
+// MaxQueuedInputs is how many inputs a player can have waiting to be applied,
+// a second's worth at the client's input rate. Beyond that the oldest ones
+// are dropped so a burst after a stall doesn't leave the player lagging.
+const MaxQueuedInputs = 10
+
+// queueInput queues an input to be applied in a later tick. Inputs that
+// don't come after the ones already queued or applied are stale and
+// dropped, applying them would undo what the client already predicted.
+func (p *Player) queueInput(data *InputData) {
+	if data.Seq != 0 {
+		last := p.LastInputSeq
+		if len(p.inputs) > 0 {
+			last = max(last, p.inputs[len(p.inputs)-1].Seq)
+		}
+		if data.Seq <= last {
+			return
+		}
+	}
+	if len(p.inputs) >= MaxQueuedInputs {
+		p.inputs[0] = nil
+		p.inputs = p.inputs[1:]
+	}
+	p.inputs = append(p.inputs, data)
+}
+
+// applyNextInput sets the player's velocity and uses its abilities from the
+// oldest queued input. The velocity stays as it is when there is none. Only
+// the input applied is acknowledged, so the client replays the ones after it
+// on top of the position the tick ends with.
+func (p *Player) applyNextInput(gs *GameServer, zone *Zone) []Message {
+	if len(p.inputs) == 0 {
+		return nil
+	}
+	data := p.inputs[0]
+	p.inputs[0] = nil
+	p.inputs = p.inputs[1:]
+	if data.Seq != 0 {
+		p.LastInputSeq = data.Seq
+	}
+
+	// Reset velocity before updating
+	p.VX = 0
+	p.VY = 0
+	speed := float32(PlayerMoveSpeed)
+
+	// Handle movement keys
+	if data.Keys.W {
+		p.VY = -speed
+		p.Direction = 3
+	}
+	if data.Keys.S {
+		p.VY = speed
+		p.Direction = 0
+	}
+	if data.Keys.A {
+		p.VX = -speed
+		p.Direction = 1
+	}
+	if data.Keys.D {
+		p.VX = speed
+		p.Direction = 2
+	}
+
+	// Handle spacebar for ability use (e.g., HammerSwing)
+	if data.Keys.SPACE {
+		log.Printf("Player %s pressed SPACE in Zone %d", p.ID, zone.ID)
+		// Execute HammerSwing ability
+		return ExecuteAbility(p, "HammerSwing", gs, zone)
+	}
+	return nil
+}
+
 // UpdatePlayer updates the player's position, handles zone switching & general ability handling
 func (p *Player) UpdatePlayer(gs *GameServer, zone *Zone, dt float32) []Message {
-	var messages []Message
+	// this tick's input, see applyNextInput
+	messages := p.applyNextInput(gs, zone)
 	
-	p.X += p.VX * dt
-	p.Y += p.VY * dt
+	// walls, void zones and the edge of the world stop the player, see collision.go
+	lastX, lastY := p.X, p.Y
+	p.X, p.Y, _, _ = moveAndSlide(p.X, p.Y, p.VX*dt, p.VY*dt)
 	newZoneID := gs.calculateZoneID(p.X, p.Y, p)
 
 	// Check for null zone or out of bounds
 	if newZoneID == 0 || IsEmptyTilemapGridName(gs.Zones[newZoneID].TilemapRef) || p.X < 0 || p.Y < 0 {
-		p.X -= p.VX * dt
-		p.Y -= p.VY * dt
+		p.X, p.Y = lastX, lastY
+		return messages
+	}
+
+	// portals can take the player anywhere, see portal.go
+	portalMessages, left := p.takePortal(gs, zone, lastX, lastY)
+	messages = append(messages, portalMessages...)
+	if left {
 		return messages
 	}
+	if len(portalMessages) > 0 {
+		newZoneID = gs.calculateZoneID(p.X, p.Y, p) // a portal within the zone moved it
+	}
 
 	if newZoneID != p.ZoneID {
 		var lastZoneUpdates []PlayerUpdate
@@ -323,17 +316,11 @@ func (p *Player) UpdatePlayer(gs *GameServer, zone *Zone, dt float32) []Message
 			GameXPTotalForNextLevel: p.GameXPTotalForNextLevel,
 		})
 
-		batch := []Message{
-			{Type: "playerUpdates", Data: lastZoneUpdates},
-		}
-		conn, exists := gs.ClientManager.GetClient(p.ID)
-		if exists && conn != nil {
-			if err := conn.WriteJSON(batch); err != nil {
-				log.Printf("Error sending batch to %s: %v", p.ID, err)
-			}
-		}
+		messages = append(messages, NewUnicastMessage(p.ID, "playerUpdates", lastZoneUpdates))
 
+		// the new zone's worker owns the player from here on
 		gs.switchZone(p, zone, newZoneID)
+		return messages
 	}
 
 	// activate base HammerSwing ability if enemies within range
@@ -351,7 +338,7 @@ func (p *Player) UpdatePlayer(gs *GameServer, zone *Zone, dt float32) []Message
 
 	// check for player death (we set isspawned to false)
 	if p.Stats.HP <= 0 {
-		p.HandleDeath(gs)
+		messages = append(messages, p.HandleDeath(gs)...)
 	}
 
 	return messages
@@ -371,12 +358,11 @@ func isEnemiesOnScreen(p *Player, zone *Zone) bool {
 	minY := p.Y - halfHeight
 	maxY := p.Y + halfHeight
 
-	for _, enemy := range zone.Enemies {
-		if enemy.X >= minX && enemy.X <= maxX && enemy.Y >= minY && enemy.Y <= maxY {
-			return true // At least one enemy is onscreen
-		}
-	}
-	return false // No enemies onscreen
+	onScreen := false
+	zone.EnemyGrid.QueryRect(minX, minY, maxX, maxY, func(*Enemy, float32, float32) {
+		onScreen = true // At least one enemy is onscreen
+	})
+	return onScreen
 }
 
 // fetchGotchiStats fetches stats for a player based on their gotchi ID
@@ -408,10 +394,10 @@ func fetchGotchiStats(gotchiId string) (int, error) {
 	return brs, nil
 }
 
-// Update HandleDeath to tag for removal
-func (p *Player) HandleDeath(gs *GameServer) {
+// HandleDeath tags the player for removal and returns the playerDeath message
+func (p *Player) HandleDeath(gs *GameServer) []Message {
 	if p.ToBeRemoved {
-		return
+		return nil
 	}
 	log.Printf("HandleDeath(): Player %s died.", p.ID)
 
@@ -419,37 +405,29 @@ func (p *Player) HandleDeath(gs *GameServer) {
 	p.ToBeRemoved = true
 
 	// send playerDeath message to client
-	conn, exists := gs.ClientManager.GetClient(p.ID)
-	if exists {
-		if err := conn.WriteJSON([]Message{{
-			Type: "playerDeath",
-			Data: map[string]interface{}{
-				"respawnTime": 10,
-			}},
-		}); err != nil {
-			log.Printf("Error sending death message to %s: %v", p.ID, err)
-		}
-	}
+	return []Message{NewUnicastMessage(p.ID, "playerDeath", map[string]interface{}{
+		"respawnTime": 10,
+	})}
 }
 
 // Update RespawnPlayer to recreate player
 func (gs *GameServer) RespawnPlayer(playerID string) {
-	conn, exists := gs.ClientManager.GetClient(playerID)
+	session, exists := gs.Sessions.Get(playerID)
 	if !exists {
-		log.Printf("Cannot respawn player %s: no active connection.", playerID)
+		log.Printf("Cannot respawn player %s: no active session.", playerID)
 		return
 	}
 
-	player := gs.CreatePlayer(playerID, conn)
+	player := gs.CreatePlayer(playerID)
+	if !gs.joinZone(player) {
+		return
+	}
 
-	conn.WriteJSON(Message{
-		Type: "playerRespawn",
-		Data: map[string]interface{}{
-			"zoneId": player.ZoneID,
-			"x":      player.X,
-			"y":      player.Y,
-		},
-	})
+	session.Send([]Message{NewUnicastMessage(playerID, "playerRespawn", map[string]interface{}{
+		"zoneId": player.ZoneID,
+		"x":      player.X,
+		"y":      player.Y,
+	})})
 }
 
 
@@ -464,8 +442,9 @@ func calculatePlayerStats(brs int) (hp, atk, ap int, rgn, speed float32) {
 	return
 }
 
-// addPlayerXP adds XP to a player and handles leveling up
-func addPlayerXP(p *Player, amount int, gs *GameServer) {
+// addPlayerXP adds XP to a player and handles leveling up, returning any levelUp messages
+func addPlayerXP(p *Player, amount int, gs *GameServer) []Message {
+	var messages []Message
 	p.GameXP += amount
 
 	totalXpRequiredForCurrentLevel := totalXpRequiredForLevel[p.GameLevel]
@@ -481,9 +460,8 @@ func addPlayerXP(p *Player, amount int, gs *GameServer) {
 		p.GameXPOnCurrentLevel = p.GameXP - totalXpRequiredForCurrentLevel
 		p.GameXPTotalForNextLevel = totalXpRequiredForNextLevel - totalXpRequiredForCurrentLevel
 
-		levelUpMsg := []Message{{
-			Type: "levelUp",
-			Data: struct {
+		messages = append(messages, NewUnicastMessage(p.ID, "levelUp",
+			struct {
 				NewLevel                int `json:"newLevel"`
 				NewATK                  int `json:"newATK"`
 				GameXPOnCurrentLevel    int `json:"gameXpOnCurrentLevel"`
@@ -493,13 +471,9 @@ func addPlayerXP(p *Player, amount int, gs *GameServer) {
 				NewATK:                  p.Stats.ATK,
 				GameXPOnCurrentLevel:    p.GameXPOnCurrentLevel,
 				GameXPTotalForNextLevel: p.GameXPTotalForNextLevel,
-			}},
-		}
-		conn, exists := gs.ClientManager.GetClient(p.ID)
-		if exists && conn != nil {
-			if err := conn.WriteJSON(levelUpMsg); err != nil {
-				log.Println("Failed to send level-up message to", p.ID, ":", err)
-			}
-		}
+			},
+		))
 	}
+
+	return messages
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+// TestInputsAppliedOnePerTick checks that inputs arriving together are
+// applied over as many ticks, each acknowledged only once it has moved the
+// player, and that stale ones are dropped
+func TestInputsAppliedOnePerTick(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	tilemaps := map[string]*Tilemap{}
+	useTestWorld(t, [][]string{{"a"}}, tilemaps, WorldPoint{X: 128, Y: 128})
+	tilemaps["a"] = openTilemap()
+	gs := NewGameServer()
+	zone := gs.Zones[1]
+	player := gs.CreatePlayer("player")
+	zone.addPlayer(player)
+
+	for _, seq := range []uint64{1, 2, 2, 3, 1} {
+		player.HandleInput(Message{Type: "input", PlayerID: player.ID, Data: &InputData{Keys: InputKeys{D: true}, Seq: seq}}, gs, zone)
+	}
+	dt := float32(TickInterval.Seconds())
+	step := float32(PlayerMoveSpeed) * dt
+	for tick := uint64(1); tick <= 4; tick++ {
+		player.UpdatePlayer(gs, zone, dt)
+		applied := min(tick, 3)
+		if player.LastInputSeq != applied {
+			t.Errorf("tick %d: input %d acknowledged, want %d", tick, player.LastInputSeq, applied)
+		}
+		// the last input keeps the player going until the next one
+		if want := 128 + float32(tick)*step; player.X < want-0.01 || player.X > want+0.01 {
+			t.Errorf("tick %d: player at x %g, want %g", tick, player.X, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Portals
+//
+// Portals take players who step onto them anywhere in the world, to any
+// zone and not only the neighbouring ones. They are drawn in the zones'
+// tilemaps, either as a tile layer, every tile of which is the portal, or
+// as objects on an object layer, each its own portal, with these custom
+// properties on the layer or object:
+//
+//	targetZone     int     ID of the zone to go to, or
+//	targetTilemap  string  tilemap ref of the zone to go to, the first zone
+//	                       using it going row by row through the grid
+//	targetX        float   where to go in pixels from the destination
+//	targetY        float   zone's top left corner
+//	minLevel       int     the game level players need, optional
+//
+// Layers and objects with neither targetZone nor targetTilemap aren't
+// portals. The shipped maps, default, yield_fields_1 and mmorpg, draw a
+// starter_portal layer but give it no destination yet, so no portal is
+// configured in the shipped world and the layer is only drawn.
+//
+// A player is teleported when a move takes it onto a portal, not while it
+// stands on one, so arriving on a portal doesn't send it straight on. It
+// gets a teleport message and is handed to the destination zone like a
+// player walking over the border, see handoff.go. Players below the level a
+// portal needs get a portalLocked message instead, and portals leading onto
+// a wall don't take anyone.
+
+// Server to client portal messages
+const (
+	MsgTeleport     = "teleport"
+	MsgPortalLocked = "portalLocked"
+)
+
+// TeleportEvent is the data of a teleport message
+type TeleportEvent struct {
+	Portal     string  `json:"portal"`
+	FromZoneID int     `json:"fromZoneId"`
+	ZoneID     int     `json:"zoneId"`
+	X          float32 `json:"x"`
+	Y          float32 `json:"y"`
+}
+
+// PortalLockedEvent is the data of a portalLocked message
+type PortalLockedEvent struct {
+	Portal        string `json:"portal"`
+	RequiredLevel int    `json:"requiredLevel"`
+}
+
+// Portal is a portal of a tilemap
+type Portal struct {
+	Name          string
+	TargetZone    int
+	TargetTilemap string
+	TargetX       float32
+	TargetY       float32
+	MinLevel      int
+
+	// object portals cover their shape, in pixels from the map's top left
+	// corner; tile layer portals are found through Tilemap.portalTiles
+	object *TilemapObject
+
+	// why the portal's properties make it unusable, nil if they don't
+	invalid error
+}
+
+// portalProperties are the properties that make a layer or object a portal
+var portalProperties = []string{"targetZone", "targetTilemap"}
+
+// buildPortals finds the portals of the map
+func (t *Tilemap) buildPortals() {
+	t.Portals = nil
+	t.portalTiles = make(map[int]*Portal)
+	t.EachLayer(func(layer *TilemapLayer) {
+		switch layer.Type {
+		case "tilelayer":
+			if layer.Width != t.Width || layer.Height != t.Height {
+				return
+			}
+			if portal := newPortal(layer.Name, layer.Properties); portal != nil {
+				t.Portals = append(t.Portals, portal)
+				for _, tile := range layer.OccupiedTiles() {
+					t.portalTiles[tile.Y*layer.Width+tile.X] = portal
+				}
+			}
+		case "objectgroup":
+			for i := range layer.Objects {
+				object := &layer.Objects[i]
+				name := object.Name
+				if name == "" {
+					name = fmt.Sprintf("%s object %d", layer.Name, object.ID)
+				}
+				if portal := newPortal(name, object.Properties); portal != nil {
+					portal.object = object
+					t.Portals = append(t.Portals, portal)
+				}
+			}
+		}
+	})
+}
+
+// newPortal reads a portal from the properties of a layer or object, nil if
+// they don't make one
+func newPortal(name string, properties []TilemapProperty) *Portal {
+	values := make(map[string]interface{}, len(properties))
+	for _, property := range properties {
+		values[property.Name] = property.Value
+	}
+	isPortal := false
+	for _, property := range portalProperties {
+		_, set := values[property]
+		isPortal = isPortal || set
+	}
+	if !isPortal {
+		return nil
+	}
+
+	portal := &Portal{Name: name}
+	var problems []error
+	number := func(name string, required bool) float64 {
+		value, set := values[name]
+		n, ok := value.(float64)
+		if set && !ok || !set && required {
+			problems = append(problems, fmt.Errorf("%s must be a number", name))
+		}
+		return n
+	}
+	if _, set := values["targetZone"]; set {
+		portal.TargetZone = int(number("targetZone", true))
+	}
+	if value, set := values["targetTilemap"]; set {
+		ref, ok := value.(string)
+		if !ok || ref == "" {
+			problems = append(problems, errors.New("targetTilemap must be a tilemap ref"))
+		}
+		portal.TargetTilemap = ref
+	}
+	if portal.TargetZone != 0 && portal.TargetTilemap != "" {
+		problems = append(problems, errors.New("targetZone and targetTilemap can't both be set"))
+	}
+	portal.TargetX = float32(number("targetX", true))
+	portal.TargetY = float32(number("targetY", true))
+	portal.MinLevel = int(number("minLevel", false))
+	portal.invalid = errors.Join(problems...)
+	return portal
+}
+
+// destination returns the zone a portal leads to and where in it, in world
+// pixels, and an error if it leads nowhere a player can stand
+func (p *Portal) destination() (ZoneConfig, float32, float32, error) {
+	if p.invalid != nil {
+		return ZoneConfig{}, 0, 0, p.invalid
+	}
+	var zoneConfig ZoneConfig
+	found := false
+	for _, candidate := range World.ZoneConfigs {
+		if p.TargetTilemap != "" && candidate.TilemapRef == p.TargetTilemap || p.TargetTilemap == "" && candidate.ID == p.TargetZone {
+			zoneConfig, found = candidate, true
+			break
+		}
+	}
+	switch {
+	case !found && p.TargetTilemap != "":
+		return zoneConfig, 0, 0, fmt.Errorf("no zone uses tilemap %s", p.TargetTilemap)
+	case !found || IsEmptyTilemapGridName(zoneConfig.TilemapRef):
+		return zoneConfig, 0, 0, fmt.Errorf("there is no zone %d", p.TargetZone)
+	}
+	if p.TargetX < 0 || p.TargetY < 0 || p.TargetX >= float32(ZoneWidthPixels) || p.TargetY >= float32(ZoneHeightPixels) {
+		return zoneConfig, 0, 0, fmt.Errorf("target %g,%g is outside the zone's %dx%d pixels", p.TargetX, p.TargetY, ZoneWidthPixels, ZoneHeightPixels)
+	}
+	x, y := zoneConfig.WorldX+p.TargetX, zoneConfig.WorldY+p.TargetY
+	if tile := tileAt(x, y); solidTile(tile.X, tile.Y) {
+		return zoneConfig, 0, 0, fmt.Errorf("target %g,%g is on a wall", p.TargetX, p.TargetY)
+	}
+	return zoneConfig, x, y, nil
+}
+
+// contains reports whether a point in pixels from the map's top left corner
+// is on an object portal
+func (p *Portal) contains(x, y float32) bool {
+	object := p.object
+	if object.Point || object.Width == 0 || object.Height == 0 {
+		// points and empty shapes cover the tile they are in
+		return tileAt(x, y) == tileAt(object.X, object.Y)
+	}
+	dx, dy := x-object.X, y-object.Y
+	if dx < 0 || dy < 0 || dx >= object.Width || dy >= object.Height {
+		return false
+	}
+	if object.Ellipse {
+		nx, ny := 2*dx/object.Width-1, 2*dy/object.Height-1
+		return nx*nx+ny*ny <= 1
+	}
+	return true
+}
+
+// portalAt returns the portal at a position in world pixels, nil if there
+// is none
+func portalAt(x, y float32) *Portal {
+	size := World.ZoneSize
+	if x < 0 || y < 0 || size == 0 {
+		return nil
+	}
+	tile := tileAt(x, y)
+	tilemap, _ := zoneTilemapAt(tile.X/size, tile.Y/size)
+	if tilemap == nil {
+		return nil
+	}
+	if portal := tilemap.portalTiles[(tile.Y%size)*tilemap.Width+tile.X%size]; portal != nil {
+		return portal
+	}
+	// objects of infinite maps are placed from where Tiled's origin is
+	localX := x - float32(tile.X/size*ZoneWidthPixels) + float32(tilemap.OriginX*tilemap.TileWidth)
+	localY := y - float32(tile.Y/size*ZoneHeightPixels) + float32(tilemap.OriginY*tilemap.TileHeight)
+	for _, portal := range tilemap.Portals {
+		if portal.object != nil && portal.contains(localX, localY) {
+			return portal
+		}
+	}
+	return nil
+}
+
+// takePortal teleports a player whose move from lastX, lastY went onto a
+// portal. Returns the messages for the player and whether it left the zone.
+func (p *Player) takePortal(gs *GameServer, zone *Zone, lastX, lastY float32) ([]Message, bool) {
+	portal := portalAt(p.X, p.Y)
+	if portal == nil || portal == portalAt(lastX, lastY) {
+		return nil, false
+	}
+	if p.GameLevel < portal.MinLevel {
+		return []Message{NewUnicastMessage(p.ID, MsgPortalLocked, PortalLockedEvent{Portal: portal.Name, RequiredLevel: portal.MinLevel})}, false
+	}
+	destination, x, y, err := portal.destination()
+	if err != nil {
+		log.Printf("Portal %s in zone %d goes nowhere: %v", portal.Name, zone.ID, err)
+		return nil, false
+	}
+
+	p.X, p.Y = x, y
+	messages := []Message{NewUnicastMessage(p.ID, MsgTeleport, TeleportEvent{
+		Portal:     portal.Name,
+		FromZoneID: zone.ID,
+		ZoneID:     destination.ID,
+		X:          x,
+		Y:          y,
+	})}
+	if destination.ID == zone.ID {
+		return messages, false
+	}
+	gs.switchZone(p, zone, destination.ID)
+	return messages, true
+}
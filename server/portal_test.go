@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+// TestTakePortal checks where portals take players stepping onto them, and
+// when they don't
+func TestTakePortal(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	for _, test := range []struct {
+		name       string
+		targetZone int
+		targetX    float64
+		targetY    float64
+		minLevel   int
+		fromTile   TilePosition // where the move starts, it ends on 3,3
+		teleported bool
+		left       bool
+		locked     bool
+	}{
+		{name: "within the zone", targetZone: 1, targetX: 208, targetY: 208, fromTile: TilePosition{X: 2, Y: 3}, teleported: true},
+		{name: "to another zone", targetZone: 2, targetX: 48, targetY: 48, fromTile: TilePosition{X: 2, Y: 3}, teleported: true, left: true},
+		{name: "below the level", targetZone: 2, targetX: 48, targetY: 48, minLevel: 5, fromTile: TilePosition{X: 2, Y: 3}, locked: true},
+		{name: "standing on it", targetZone: 2, targetX: 48, targetY: 48, fromTile: TilePosition{X: 3, Y: 2}},
+		{name: "onto a wall", targetZone: 2, targetX: 112, targetY: 112, fromTile: TilePosition{X: 2, Y: 3}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tilemaps := map[string]*Tilemap{}
+			useTestWorld(t, [][]string{{"a", "b"}}, tilemaps, WorldPoint{X: 48, Y: 48})
+			tilemaps["a"], tilemaps["b"] = openTilemap(), borderTilemap(TilePosition{X: 3, Y: 3})
+			portalStrip(tilemaps["a"], 3, 3, test.targetZone, test.targetX, test.targetY)
+			if test.minLevel != 0 {
+				layer := &tilemaps["a"].Layers[len(tilemaps["a"].Layers)-1]
+				layer.Properties = append(layer.Properties, TilemapProperty{Name: "minLevel", Type: "int", Value: float64(test.minLevel)})
+				tilemaps["a"].buildPortals()
+			}
+			NumEnemiesPerZone = 0
+			gs := NewGameServer()
+			home := gs.Zones[1]
+
+			addTestSession(gs, "player")
+			player := gs.CreatePlayer("player")
+			player.GameLevel = 1
+			lastX, lastY := tileCentre(test.fromTile)
+			player.X, player.Y = tileCentre(TilePosition{X: 3, Y: 3})
+			player.ZoneID = home.ID
+			sendZoneCommand(home, ZoneCommand{Type: ZoneJoin, PlayerID: player.ID, Player: player})
+			gs.Sessions.SetZone(player.ID, home.ID)
+			gs.processZoneCommands(home)
+			x, y := player.X, player.Y
+
+			messages, left := player.takePortal(gs, home, lastX, lastY)
+			if left != test.left {
+				t.Errorf("left the zone: %v, want %v", left, test.left)
+			}
+			var teleport *TeleportEvent
+			var lock *PortalLockedEvent
+			for _, msg := range messages {
+				switch data := msg.Data.(type) {
+				case TeleportEvent:
+					teleport = &data
+				case PortalLockedEvent:
+					lock = &data
+				}
+			}
+
+			if !test.teleported {
+				if teleport != nil || player.X != x || player.Y != y {
+					t.Errorf("the player was teleported to %g,%g", player.X, player.Y)
+				}
+			} else {
+				destination := gs.Zones[test.targetZone]
+				wantX, wantY := destination.WorldX+float32(test.targetX), destination.WorldY+float32(test.targetY)
+				if player.X != wantX || player.Y != wantY {
+					t.Errorf("the player is at %g,%g, want %g,%g", player.X, player.Y, wantX, wantY)
+				}
+				if teleport == nil || teleport.ZoneID != destination.ID || teleport.X != wantX || teleport.Y != wantY {
+					t.Errorf("teleport message %+v", teleport)
+				}
+			}
+			if locked := lock != nil; locked != test.locked {
+				t.Errorf("portalLocked message: %v, want %v", locked, test.locked)
+			} else if locked && lock.RequiredLevel != test.minLevel {
+				t.Errorf("the portal needs level %d, want %d", lock.RequiredLevel, test.minLevel)
+			}
+
+			if test.left {
+				over := gs.Zones[test.targetZone]
+				gs.processZoneCommands(over)
+				if _, there := over.Players[player.ID]; !there {
+					t.Error("the destination zone didn't take the player")
+				}
+				if _, still := home.Players[player.ID]; still {
+					t.Error("the player is still in the zone it left")
+				}
+				if zoneID, _ := gs.Sessions.Zone(player.ID); zoneID != over.ID {
+					t.Errorf("the session is in zone %d, want %d", zoneID, over.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// ProtocolVersion is the client/server protocol spoken by this server. Clients
+// announce their version in spawnPlayerCharacter and get ours in welcome.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// Client to server message types
+const (
+	MsgInput                = "input"
+	MsgSpawnPlayerCharacter = "spawnPlayerCharacter"
+	MsgAck                  = "ack"
+	MsgClockSync            = "clockSync" // answered with a clockSync reply
+)
+
+// Server to client message types
+const (
+	MsgError    = "error"
+	MsgSnapshot = "snapshot"
+)
+
+// Error codes sent back in error messages
+const (
+	ErrCodeMalformedMessage    = "malformed_message"
+	ErrCodeUnknownMessageType  = "unknown_message_type"
+	ErrCodeInvalidPayload      = "invalid_payload"
+	ErrCodeUnsupportedProtocol = "unsupported_protocol_version"
+)
+
+// ClientMessage is the envelope of every message a client sends
+type ClientMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// InputKeys is the state of the movement and ability keys
+type InputKeys struct {
+	W     bool `json:"W"`
+	A     bool `json:"A"`
+	S     bool `json:"S"`
+	D     bool `json:"D"`
+	SPACE bool `json:"SPACE"`
+}
+
+// InputData is the payload of an input message. Seq numbers a client's
+// inputs in increasing order so it can tell which ones the server has
+// applied; clients that don't predict movement may leave it out.
+type InputData struct {
+	Keys InputKeys `json:"keys"`
+	Seq  uint64    `json:"seq,omitempty"`
+}
+
+// SpawnPlayerCharacterData is the payload of a spawnPlayerCharacter message.
+// Clients from before the protocol was versioned leave ProtocolVersion out,
+// they speak version 1.
+type SpawnPlayerCharacterData struct {
+	PlayableCharacter
+	ProtocolVersion int `json:"protocolVersion"`
+}
+
+// Validate checks the client speaks a protocol version we support
+func (d *SpawnPlayerCharacterData) Validate() *ProtocolError {
+	if d.ProtocolVersion == 0 {
+		d.ProtocolVersion = 1
+	}
+	if d.ProtocolVersion < MinProtocolVersion || d.ProtocolVersion > ProtocolVersion {
+		return &ProtocolError{
+			Code:   ErrCodeUnsupportedProtocol,
+			Reason: fmt.Sprintf("protocol version %d not supported (server speaks %d to %d)", d.ProtocolVersion, MinProtocolVersion, ProtocolVersion),
+		}
+	}
+	return nil
+}
+
+// AckData is the payload of an ack message, acknowledging a delta snapshot
+type AckData struct {
+	Seq uint64 `json:"seq"`
+}
+
+// clientMessageCatalogue maps every message type a client may send to a
+// constructor for its payload. Payloads implementing payloadValidator are
+// validated after decoding.
+var clientMessageCatalogue = map[string]func() interface{}{
+	MsgInput:                func() interface{} { return &InputData{} },
+	MsgSpawnPlayerCharacter: func() interface{} { return &SpawnPlayerCharacterData{} },
+	MsgAck:                  func() interface{} { return &AckData{} },
+	MsgClockSync:            func() interface{} { return &ClockSyncData{} },
+}
+
+type payloadValidator interface {
+	Validate() *ProtocolError
+}
+
+// ProtocolError describes why a client message was rejected. It is sent back
+// to the client as the data of an error message.
+type ProtocolError struct {
+	Code        string `json:"code"`
+	Reason      string `json:"reason"`
+	MessageType string `json:"messageType,omitempty"`
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Reason
+}
+
+// decodeClientMessage strictly decodes a raw client message into a Message
+// whose Data is the typed payload from the catalogue
+func decodeClientMessage(raw []byte) (Message, *ProtocolError) {
+	var envelope ClientMessage
+	if err := decodeStrict(raw, &envelope); err != nil {
+		return Message{}, &ProtocolError{Code: ErrCodeMalformedMessage, Reason: err.Error()}
+	}
+
+	newPayload, known := clientMessageCatalogue[envelope.Type]
+	if !known {
+		return Message{}, &ProtocolError{
+			Code:        ErrCodeUnknownMessageType,
+			Reason:      fmt.Sprintf("unknown message type %q", envelope.Type),
+			MessageType: envelope.Type,
+		}
+	}
+
+	payload := newPayload()
+	if err := decodeStrict(envelope.Data, payload); err != nil {
+		return Message{}, &ProtocolError{Code: ErrCodeInvalidPayload, Reason: err.Error(), MessageType: envelope.Type}
+	}
+	if validator, ok := payload.(payloadValidator); ok {
+		if protocolErr := validator.Validate(); protocolErr != nil {
+			protocolErr.MessageType = envelope.Type
+			return Message{}, protocolErr
+		}
+	}
+
+	return Message{Type: envelope.Type, Data: payload}, nil
+}
+
+// decodeStrict unmarshals exactly one JSON value, rejecting unknown fields
+// and trailing data
+func decodeStrict(raw []byte, v interface{}) error {
+	if len(raw) == 0 {
+		return errors.New("missing data")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after message")
+	}
+	return nil
+}
+
+// rejectClientMessage counts a bad message against the session and tells the
+// client what was wrong with it
+func rejectClientMessage(session *Session, protocolErr *ProtocolError) {
+	if protocolErr.Code == ErrCodeUnknownMessageType {
+		session.unknownMessages.Add(1)
+	} else {
+		session.invalidMessages.Add(1)
+	}
+	log.Printf("Rejected message from %s: %v", session.ID, protocolErr)
+	session.Send([]Message{NewUnicastMessage(session.ID, MsgError, protocolErr)})
+}
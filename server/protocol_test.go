@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestDecodeClientMessage checks what the message catalogue accepts and the
+// errors it answers the rest with
+func TestDecodeClientMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		code string // empty when the message is accepted
+	}{
+		{"input", `{"type":"input","data":{"keys":{"W":true},"seq":3}}`, ""},
+		{"spawn", `{"type":"spawnPlayerCharacter","data":{"name":"Gotchi","protocolVersion":2}}`, ""},
+		{"spawn of an unversioned client", `{"type":"spawnPlayerCharacter","data":{"name":"Gotchi"}}`, ""},
+		{"spawn of a newer client", `{"type":"spawnPlayerCharacter","data":{"name":"Gotchi","protocolVersion":3}}`, ErrCodeUnsupportedProtocol},
+		{"unknown type", `{"type":"teleportMe","data":{}}`, ErrCodeUnknownMessageType},
+		{"unknown envelope field", `{"type":"input","data":{"keys":{}},"extra":1}`, ErrCodeMalformedMessage},
+		{"unknown payload field", `{"type":"input","data":{"keys":{},"speed":99}}`, ErrCodeInvalidPayload},
+		{"wrong field type", `{"type":"input","data":{"keys":{"W":"yes"}}}`, ErrCodeInvalidPayload},
+		{"missing data", `{"type":"ack"}`, ErrCodeInvalidPayload},
+		{"trailing data", `{"type":"ack","data":{"seq":1}} {}`, ErrCodeMalformedMessage},
+		{"not json", `input`, ErrCodeMalformedMessage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, protocolErr := decodeClientMessage([]byte(test.raw))
+			switch {
+			case test.code == "" && protocolErr != nil:
+				t.Errorf("rejected: %v", protocolErr)
+			case test.code != "" && protocolErr == nil:
+				t.Errorf("accepted as %+v", msg)
+			case test.code != "" && protocolErr.Code != test.code:
+				t.Errorf("rejected with %s, want %s", protocolErr.Code, test.code)
+			}
+		})
+	}
+}
+
+// TestUnversionedClient checks that a spawn without a protocol version is
+// taken for version 1
+func TestUnversionedClient(t *testing.T) {
+	msg, protocolErr := decodeClientMessage([]byte(`{"type":"spawnPlayerCharacter","data":{"name":"Gotchi"}}`))
+	if protocolErr != nil {
+		t.Fatal(protocolErr)
+	}
+	if version := msg.Data.(*SpawnPlayerCharacterData).ProtocolVersion; version != 1 {
+		t.Errorf("protocol version %d, want 1", version)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Session resume
+//
+// Every welcome message carries a resume token. When a connection drops the
+// session is detached rather than removed: its player stays in the world
+// (standing still) for ResumeGraceWindow. A new /ws connection presenting the
+// token as ?resume=<token> gets the same session and player back, together
+// with any reliable messages raised while it was away. The Phaser client
+// keeps the latest token for its browser tab and reconnects with it, after a
+// dropped connection or a reload, until it gets a resumeRejected message.
+
+// ResumeGraceWindow is how long a detached session waits to be resumed,
+// overridable from the command line
+var ResumeGraceWindow = 60 * time.Second
+
+// resumeSecret signs resume tokens, random per process unless configured
+var resumeSecret []byte
+
+// initResumeSecret sets the token signing key. An empty secret generates a
+// random one, which invalidates outstanding tokens on restart.
+func initResumeSecret(secret string) error {
+	if secret != "" {
+		resumeSecret = []byte(secret)
+		return nil
+	}
+	resumeSecret = make([]byte, 32)
+	if _, err := rand.Read(resumeSecret); err != nil {
+		return fmt.Errorf("generating resume secret: %w", err)
+	}
+	return nil
+}
+
+// newSessionID returns an unguessable session ID
+func newSessionID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand never fails on supported platforms
+		log.Fatalf("Failed to generate session ID: %v", err)
+	}
+	return "session" + hex.EncodeToString(id)
+}
+
+// issueResumeToken signs a new token for the session, invalidating any
+// previously issued one
+func issueResumeToken(session *Session) string {
+	generation := session.tokenGeneration.Add(1)
+	payload := session.ID + "." + strconv.FormatUint(generation, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signResumePayload(payload))
+}
+
+func signResumePayload(payload string) []byte {
+	mac := hmac.New(sha256.New, resumeSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// parseResumeToken verifies a token's signature and returns what it refers to
+func parseResumeToken(token string) (string, uint64, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return "", 0, errors.New("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", 0, errors.New("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", 0, errors.New("malformed token")
+	}
+	if !hmac.Equal(signature, signResumePayload(string(payload))) {
+		return "", 0, errors.New("invalid signature")
+	}
+
+	sessionID, generationText, found := strings.Cut(string(payload), ".")
+	if !found {
+		return "", 0, errors.New("malformed token")
+	}
+	generation, err := strconv.ParseUint(generationText, 10, 64)
+	if err != nil {
+		return "", 0, errors.New("malformed token")
+	}
+	return sessionID, generation, nil
+}
+
+// findResumableSession looks up the session a resume token refers to
+func (gs *GameServer) findResumableSession(token string) (*Session, error) {
+	sessionID, generation, err := parseResumeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	session, exists := gs.Sessions.Get(sessionID)
+	if !exists {
+		return nil, errors.New("session expired")
+	}
+	if session.tokenGeneration.Load() != generation {
+		return nil, errors.New("token superseded")
+	}
+	return session, nil
+}
+
+// resumeSession attaches a new connection to an existing session and resends
+// the welcome along with everything the client missed
+func (gs *GameServer) resumeSession(session *Session, client *Client, remoteAddr string) error {
+	// the welcome's new token supersedes the one presented, so it is only
+	// issued once the session is sure to be resumed
+	previous, err := session.attach(client, remoteAddr, func() []Message {
+		return []Message{gs.welcomeMessage(session, true)}
+	})
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		// the old connection hasn't noticed it is dead yet
+		previous.Close()
+	}
+	log.Printf("Session %s resumed from %s", session.ID, remoteAddr)
+	return nil
+}
+
+// detachSession is called when a connection closes. Sessions with a player in
+// the world wait ResumeGraceWindow for the client to come back.
+func (gs *GameServer) detachSession(session *Session, client *Client) {
+	if _, inWorld := session.ZoneID(); !inWorld {
+		if session.detach(client, 0, nil) {
+			gs.expireSession(session)
+		}
+		return
+	}
+	if session.detach(client, ResumeGraceWindow, func() { gs.expireSession(session) }) {
+		log.Printf("Session %s detached, resumable for %v", session.ID, ResumeGraceWindow)
+	}
+}
+
+// expireSession removes a detached session and its player for good
+func (gs *GameServer) expireSession(session *Session) {
+	if !session.expire() {
+		// resumed just in time
+		return
+	}
+	gs.leaveZone(session.ID)
+	gs.Sessions.Unregister(session.ID)
+	log.Printf("Session %s disconnected", session.ID)
+}
+
+// welcomeMessage builds the welcome message with the world zones and a fresh
+// resume token
+func (gs *GameServer) welcomeMessage(session *Session, resumed bool) Message {
+	zonesInfo := make([]ZoneInfo, 0, len(gs.Zones))
+	for _, z := range gs.Zones {
+		var config ZoneConfig
+		for _, c := range World.ZoneConfigs {
+			if c.ID == z.ID {
+				config = c
+				break
+			}
+		}
+		zonesInfo = append(zonesInfo, ZoneInfo{
+			ID:         z.ID,
+			TilemapRef: config.TilemapRef,
+			WorldX:     config.WorldX,
+			WorldY:     config.WorldY,
+		})
+	}
+
+	return NewUnicastMessage(session.ID, "welcome", map[string]interface{}{
+		"playerId":        session.ID,
+		"zones":           zonesInfo,
+		"resumeToken":     issueResumeToken(session),
+		"resumed":         resumed,
+		"protocolVersion": ProtocolVersion,
+	})
+}
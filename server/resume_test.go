@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// useResumeSecret signs resume tokens with a fixed key for the duration of a
+// test
+func useResumeSecret(t *testing.T) {
+	t.Helper()
+	saved := resumeSecret
+	t.Cleanup(func() { resumeSecret = saved })
+	if err := initResumeSecret("test secret"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestResumeToken checks which tokens find their session
+func TestResumeToken(t *testing.T) {
+	useResumeSecret(t)
+	gs := NewGameServer()
+	session := addTestSession(gs, "session1")
+	superseded := issueResumeToken(session)
+	token := issueResumeToken(session)
+	gone := issueResumeToken(addTestSession(gs, "session2"))
+	gs.Sessions.Unregister("session2")
+
+	payload, _, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("session1.3"))
+
+	tests := []struct {
+		name  string
+		token string
+		err   string // empty when the token resumes session1
+	}{
+		{"latest token", token, ""},
+		{"superseded token", superseded, "token superseded"},
+		{"expired session", gone, "session expired"},
+		{"tampered signature", payload + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")), "invalid signature"},
+		{"tampered payload", forged + strings.TrimPrefix(token, payload), "invalid signature"},
+		{"no signature", payload, "malformed token"},
+		{"not base64", "!." + strings.TrimPrefix(token, payload+"."), "malformed token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, err := gs.findResumableSession(test.token)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("rejected: %v", err)
+			case test.err == "" && found != session:
+				t.Errorf("resumed %v, want session1", found)
+			case test.err != "" && (err == nil || err.Error() != test.err):
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+// TestFailedResumeKeepsToken checks that a resume the session turns down
+// doesn't use up the client's token
+func TestFailedResumeKeepsToken(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+	useResumeSecret(t)
+
+	gs := NewGameServer()
+	session := addTestSession(gs, "session")
+	token := issueResumeToken(session)
+	session.expire()
+
+	if err := gs.resumeSession(session, newTestClient(t), "test"); err == nil {
+		t.Fatal("an expired session was resumed")
+	}
+	if _, err := gs.findResumableSession(token); err != nil {
+		t.Errorf("the token no longer works: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+)
+
+// MessageScope determines which connections a Message is delivered to
+type MessageScope int
+
+const (
+	ScopeNone    MessageScope = iota // not addressed, will be dropped
+	ScopeUnicast                     // a single session (Message.Recipient)
+	ScopeZone                        // every player in Message.ZoneID
+	ScopeArea                        // every player within Message.Radius of (Message.X, Message.Y)
+)
+
+// EffectBroadcastRadius is how far (in pixels) area events such as ability
+// effects travel. Roughly the diagonal of the client viewport so anything
+// visible on screen gets drawn.
+const EffectBroadcastRadius float32 = 1280
+
+// NewUnicastMessage addresses a message to a single session
+func NewUnicastMessage(sessionID string, msgType string, data interface{}) Message {
+	return Message{
+		Type:      msgType,
+		Data:      data,
+		Scope:     ScopeUnicast,
+		Recipient: sessionID,
+	}
+}
+
+// NewZoneMessage addresses a message to every player in a zone
+func NewZoneMessage(zoneID int, msgType string, data interface{}) Message {
+	return Message{
+		Type:   msgType,
+		Data:   data,
+		Scope:  ScopeZone,
+		ZoneID: zoneID,
+	}
+}
+
+// NewAreaMessage addresses a message to every player within radius of a point
+func NewAreaMessage(zoneID int, x, y, radius float32, msgType string, data interface{}) Message {
+	return Message{
+		Type:   msgType,
+		Data:   data,
+		Scope:  ScopeArea,
+		ZoneID: zoneID,
+		X:      x,
+		Y:      y,
+		Radius: radius,
+	}
+}
+
+// IsAddressedTo reports whether the message should be delivered to the player
+func (m *Message) IsAddressedTo(p *Player) bool {
+	switch m.Scope {
+	case ScopeUnicast:
+		return m.Recipient == p.ID
+	case ScopeZone:
+		return m.ZoneID == p.ZoneID
+	case ScopeArea:
+		dx := p.X - m.X
+		dy := p.Y - m.Y
+		return dx*dx+dy*dy <= m.Radius*m.Radius
+	default:
+		return false
+	}
+}
+
+// routeMessages picks the pending messages addressed to the given player
+func routeMessages(pending []Message, p *Player) []Message {
+	var routed []Message
+	for i := range pending {
+		if pending[i].IsAddressedTo(p) {
+			routed = append(routed, pending[i])
+		}
+	}
+	return routed
+}
+
+// deliverOrphanedUnicasts sends unicast messages whose recipient is no longer
+// in the zone that produced them (e.g. the player just switched zones this
+// tick) straight to the recipient's connection.
+func (gs *GameServer) deliverOrphanedUnicasts(pending []Message, zone *Zone) {
+	orphaned := make(map[string][]Message)
+	for _, msg := range pending {
+		if msg.Scope == ScopeNone {
+			log.Printf("Warning: Dropping unaddressed %s message from zone %d", msg.Type, zone.ID)
+			continue
+		}
+		if msg.Scope != ScopeUnicast {
+			continue
+		}
+		if _, exists := zone.Players[msg.Recipient]; exists {
+			continue
+		}
+		orphaned[msg.Recipient] = append(orphaned[msg.Recipient], msg)
+	}
+
+	for sessionID, batch := range orphaned {
+		if session, exists := gs.Sessions.Get(sessionID); exists {
+			session.Send(batch)
+		}
+	}
+}
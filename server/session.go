@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxSessionBacklog caps how many reliable messages are kept for a detached
+// session waiting to resume
+const MaxSessionBacklog = 256
+
+// Session is everything the server tracks about one connected player. A
+// session outlives its connection for a grace window (see resume.go) so a
+// client that drops can pick up the same player again.
+type Session struct {
+	ID string
+
+	// connection currently attached, nil while detached
+	client atomic.Pointer[Client]
+
+	// the zone that owns the session's player, 0 while not in the world
+	zoneID atomic.Int64
+
+	// resume token generation, only the latest issued token is accepted
+	tokenGeneration atomic.Uint64
+
+	// rejected client messages, see protocol.go
+	unknownMessages atomic.Uint64
+	invalidMessages atomic.Uint64
+
+	mu              sync.Mutex
+	connectedAt     time.Time
+	remoteAddr      string
+	protocolVersion int
+	graceTimer      *time.Timer
+	expired         bool
+	backlog         []Message // reliable messages raised while detached
+}
+
+// SessionInfo is a copy of a session's connection metadata
+type SessionInfo struct {
+	ID              string    `json:"id"`
+	ConnectedAt     time.Time `json:"connectedAt"`
+	RemoteAddr      string    `json:"remoteAddr"`
+	ProtocolVersion int       `json:"protocolVersion"`
+	ZoneID          int       `json:"zoneId"`
+	Detached        bool      `json:"detached"`
+
+	WireFormat      string       `json:"wireFormat,omitempty"`
+	Latency         LatencyStats `json:"latency"`
+	UnknownMessages uint64       `json:"unknownMessages"`
+	InvalidMessages uint64       `json:"invalidMessages"`
+}
+
+// Info returns the session's connection metadata
+func (s *Session) Info() SessionInfo {
+	zoneID, _ := s.ZoneID()
+	client := s.Client()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := SessionInfo{
+		ID:              s.ID,
+		ConnectedAt:     s.connectedAt,
+		RemoteAddr:      s.remoteAddr,
+		ProtocolVersion: s.protocolVersion,
+		ZoneID:          zoneID,
+		Detached:        client == nil,
+		UnknownMessages: s.unknownMessages.Load(),
+		InvalidMessages: s.invalidMessages.Load(),
+	}
+	if client != nil {
+		info.WireFormat = client.Codec().Name()
+		info.Latency = client.Latency()
+	}
+	return info
+}
+
+// SetProtocolVersion records the protocol version the client speaks
+func (s *Session) SetProtocolVersion(version int) {
+	s.mu.Lock()
+	s.protocolVersion = version
+	s.mu.Unlock()
+}
+
+// ProtocolVersion returns the protocol version the client announced, 0 before
+// it has spawned
+func (s *Session) ProtocolVersion() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocolVersion
+}
+
+// ZoneID returns the zone that currently owns the session's player
+func (s *Session) ZoneID() (int, bool) {
+	zoneID := int(s.zoneID.Load())
+	return zoneID, zoneID != 0
+}
+
+// Client returns the attached connection, or nil while detached
+func (s *Session) Client() *Client {
+	return s.client.Load()
+}
+
+// IsDetached reports whether the session is waiting for its client to resume
+func (s *Session) IsDetached() bool {
+	return s.Client() == nil
+}
+
+// Send queues reliable messages for the client. While detached they are kept
+// in the backlog and replayed when the client resumes.
+func (s *Session) Send(batch []Message) bool {
+	if len(batch) == 0 {
+		return true
+	}
+	if client := s.Client(); client != nil {
+		return client.Send(batch)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the client may have resumed while we waited for the lock
+	if client := s.Client(); client != nil {
+		return client.Send(batch)
+	}
+	s.backlog = append(s.backlog, batch...)
+	if overflow := len(s.backlog) - MaxSessionBacklog; overflow > 0 {
+		s.backlog = s.backlog[overflow:]
+	}
+	return true
+}
+
+// SendSnapshot queues entity state for the client. Dropped while detached,
+// a fresh snapshot follows the resume anyway.
+func (s *Session) SendSnapshot(batch []Message) bool {
+	if client := s.Client(); client != nil {
+		return client.SendSnapshot(batch)
+	}
+	return true
+}
+
+// attach connects a client to the session. The greeting, built once the
+// session is known not to have expired, goes out first, followed by anything
+// raised while the session was detached. Returns the client it replaced, if
+// any.
+func (s *Session) attach(client *Client, remoteAddr string, greeting func() []Message) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expired {
+		return nil, errors.New("session expired")
+	}
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	s.connectedAt = time.Now()
+	s.remoteAddr = remoteAddr
+
+	client.Send(append(greeting(), s.backlog...))
+	s.backlog = nil
+	return s.client.Swap(client), nil
+}
+
+// detach disconnects client from the session, if it is still the attached one.
+// onExpire, if set, runs when nobody resumes the session within grace.
+func (s *Session) detach(client *Client, grace time.Duration, onExpire func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.client.CompareAndSwap(client, nil) {
+		// a newer connection already took over
+		return false
+	}
+	if onExpire != nil {
+		s.graceTimer = time.AfterFunc(grace, onExpire)
+	}
+	return true
+}
+
+// expire marks a detached session as gone for good so it can no longer be
+// resumed. Returns false if a client is attached again.
+func (s *Session) expire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Client() != nil {
+		return false
+	}
+	s.expired = true
+	return true
+}
+
+// SessionRegistry maps session IDs to sessions. Safe for concurrent use, and
+// every lookup is a single map access no matter how big the world gets.
+type SessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Register adds a session for a freshly accepted connection
+func (r *SessionRegistry) Register(client *Client, remoteAddr string) *Session {
+	session := &Session{
+		ID:          client.SessionID,
+		connectedAt: time.Now(),
+		remoteAddr:  remoteAddr,
+	}
+	session.client.Store(client)
+
+	r.mu.Lock()
+	r.sessions[session.ID] = session
+	r.mu.Unlock()
+
+	log.Println("Register(): ", session.ID, " from ", remoteAddr)
+	return session
+}
+
+// Unregister removes a session
+func (r *SessionRegistry) Unregister(sessionID string) {
+	r.mu.Lock()
+	delete(r.sessions, sessionID)
+	r.mu.Unlock()
+
+	log.Println("Unregister(): ", sessionID)
+}
+
+// Get retrieves a session
+func (r *SessionRegistry) Get(sessionID string) (*Session, bool) {
+	r.mu.RLock()
+	session, exists := r.sessions[sessionID]
+	r.mu.RUnlock()
+	return session, exists
+}
+
+// All returns the registered sessions in no particular order
+func (r *SessionRegistry) All() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// SetZone records that a zone now owns the session's player
+func (r *SessionRegistry) SetZone(sessionID string, zoneID int) {
+	if session, exists := r.Get(sessionID); exists {
+		session.zoneID.Store(int64(zoneID))
+	}
+}
+
+// ClearZone takes the session's player out of the world, but only if zoneID
+// still owns it (a handoff may already have moved it on)
+func (r *SessionRegistry) ClearZone(sessionID string, zoneID int) {
+	if session, exists := r.Get(sessionID); exists {
+		session.zoneID.CompareAndSwap(int64(zoneID), 0)
+	}
+}
+
+// Zone returns the ID of the zone that owns the session's player
+func (r *SessionRegistry) Zone(sessionID string) (int, bool) {
+	session, exists := r.Get(sessionID)
+	if !exists {
+		return 0, false
+	}
+	return session.ZoneID()
+}
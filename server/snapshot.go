@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+)
+
+// ZoneSnapshot is an immutable copy of a zone's entity state, published by the
+// zone's worker at the end of every tick. Other zone workers only ever read a
+// neighbour through its snapshot, never through its Players/Enemies maps.
+// Nothing may modify a snapshot once it has been published.
+type ZoneSnapshot struct {
+	ZoneID    int
+	Tick      uint64
+	Timestamp int64
+	Players   []PlayerUpdate
+	Enemies   []EnemyUpdate
+
+	// positions of Players and Enemies by slice index, see aoi.go
+	playerGrid *SpatialHash[int]
+	enemyGrid  *SpatialHash[int]
+
+	// the updates encoded once per wire format for every recipient
+	json    encodedSnapshot
+	msgpack encodedSnapshot
+}
+
+// encodedSnapshot holds a snapshot's updates encoded in one wire format, each
+// a complete playerUpdate or enemyUpdate message in a single shared buffer
+type encodedSnapshot struct {
+	once    sync.Once
+	players [][]byte // by index into the snapshot's Players
+	enemies [][]byte
+	err     error
+}
+
+// Snapshot returns the zone's most recently published snapshot. Safe to call
+// from any goroutine.
+func (z *Zone) Snapshot() *ZoneSnapshot {
+	return z.snapshot.Load()
+}
+
+// encoded returns the snapshot's updates in codec's wire format, encoding
+// them the first time the format is asked for. Safe to call from any
+// goroutine; the returned chunks must not be modified.
+func (s *ZoneSnapshot) encoded(codec WireCodec) *encodedSnapshot {
+	encoding := &s.json
+	if _, ok := codec.(msgpackCodec); ok {
+		encoding = &s.msgpack
+	}
+	encoding.once.Do(func() { encoding.encode(s, codec) })
+	return encoding
+}
+
+func (e *encodedSnapshot) encode(s *ZoneSnapshot, codec WireCodec) {
+	messages := make([]Message, 0, len(s.Players)+len(s.Enemies))
+	for i := range s.Players {
+		messages = append(messages, Message{Type: "playerUpdate", Data: &s.Players[i]})
+	}
+	for i := range s.Enemies {
+		messages = append(messages, Message{Type: "enemyUpdate", Data: &s.Enemies[i]})
+	}
+	buf := make([]byte, 0, len(s.Players)*playerUpdateCost+len(s.Enemies)*enemyUpdateCost)
+	buf, ends, err := codec.AppendMessages(buf, messages)
+	if err != nil {
+		e.err = err
+		return
+	}
+
+	chunks := make([][]byte, len(ends))
+	start := 0
+	for i, end := range ends {
+		chunks[i] = buf[start:end:end]
+		start = end
+	}
+	e.players, e.enemies = chunks[:len(s.Players)], chunks[len(s.Players):]
+}
+
+// publishSnapshot captures the zone's current entity state and makes it
+// visible to other goroutines. Called by the zone worker.
+func (z *Zone) publishSnapshot(tick uint64, timestamp int64) *ZoneSnapshot {
+	snapshot := &ZoneSnapshot{
+		ZoneID:    z.ID,
+		Tick:      tick,
+		Timestamp: timestamp,
+		Players:   make([]PlayerUpdate, 0, len(z.Players)),
+		Enemies:   make([]EnemyUpdate, 0, len(z.Enemies)),
+
+		playerGrid: NewSpatialHash[int](InterestCellSize),
+		enemyGrid:  NewSpatialHash[int](InterestCellSize),
+	}
+	for _, p := range z.Players {
+		snapshot.playerGrid.Insert(len(snapshot.Players), p.X, p.Y)
+		snapshot.Players = append(snapshot.Players, newPlayerUpdate(p, tick, timestamp))
+	}
+	for _, e := range z.Enemies {
+		snapshot.enemyGrid.Insert(len(snapshot.Enemies), e.X, e.Y)
+		snapshot.Enemies = append(snapshot.Enemies, newEnemyUpdate(e, tick, timestamp))
+	}
+
+	z.snapshot.Store(snapshot)
+	return snapshot
+}
+
+// newPlayerUpdate copies the replicated state of a player
+func newPlayerUpdate(p *Player, tick uint64, timestamp int64) PlayerUpdate {
+	return PlayerUpdate{
+		PlayerID:                p.ID,
+		X:                       p.X,
+		Y:                       p.Y,
+		ZoneID:                  p.ZoneID,
+		Tick:                    tick,
+		Timestamp:               timestamp,
+		Species:                 p.Species,
+		SpeciesID:               p.SpeciesID,
+		Direction:               p.Direction,
+		MaxHP:                   p.Stats.MaxHP,
+		HP:                      p.Stats.HP,
+		MaxAP:                   p.Stats.MaxAP,
+		AP:                      p.Stats.AP,
+		GameXP:                  p.GameXP,
+		GameLevel:               p.GameLevel,
+		GameXPOnCurrentLevel:    p.GameXPOnCurrentLevel,
+		GameXPTotalForNextLevel: p.GameXPTotalForNextLevel,
+	}
+}
+
+// newEnemyUpdate copies the replicated state of an enemy
+func newEnemyUpdate(e *Enemy, tick uint64, timestamp int64) EnemyUpdate {
+	return EnemyUpdate{
+		EnemyID:   e.ID,
+		X:         e.X,
+		Y:         e.Y,
+		ZoneID:    e.ZoneID,
+		Tick:      tick,
+		Timestamp: timestamp,
+		Type:      e.Type,
+		Direction: e.Direction,
+		MaxHP:     e.Stats.MaxHP,
+		HP:        e.Stats.HP,
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// BenchmarkZoneBroadcast compares encoding every recipient's updates with
+// sharing the ones encoded per snapshot, see benchmarkZoneBroadcast
+func BenchmarkZoneBroadcast(b *testing.B) {
+	b.Run("json/per-player", func(b *testing.B) { benchmarkZoneBroadcast(b, jsonCodec{}, false) })
+	b.Run("json/shared", func(b *testing.B) { benchmarkZoneBroadcast(b, jsonCodec{}, true) })
+	b.Run("msgpack/per-player", func(b *testing.B) { benchmarkZoneBroadcast(b, msgpackCodec{}, false) })
+	b.Run("msgpack/shared", func(b *testing.B) { benchmarkZoneBroadcast(b, msgpackCodec{}, true) })
+}
+
+// benchmarkZoneBroadcast measures a tick of per-entity updates for every
+// player in a zone, each seeing all four zones, from publishing the snapshots
+// to the assembled frames. per-player encodes each recipient's batch from
+// scratch, the way the zone used to; shared copies the updates encoded once
+// per snapshot into pooled frame buffers. Compare allocs/op, each op a tick.
+func benchmarkZoneBroadcast(b *testing.B, codec WireCodec, shared bool) {
+	zones := benchmarkWorld(rand.New(rand.NewSource(1)))
+	header := []Message{{Type: "activeZones", Data: ActiveZoneList{CurrentZoneID: 6, XAxisZoneID: 7, YAxisZoneID: 10, DiagonalZoneID: 11}}}
+	var encoded [][]byte
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		snapshots := benchmarkSnapshots(zones, uint64(i+1))
+		for recipient := 0; recipient < benchmarkPlayersPerZone; recipient++ {
+			if !shared {
+				batch := append([]Message(nil), header...)
+				for _, snapshot := range snapshots {
+					for j := range snapshot.Players {
+						batch = append(batch, Message{Type: "playerUpdate", Data: &snapshot.Players[j]})
+					}
+					for j := range snapshot.Enemies {
+						batch = append(batch, Message{Type: "enemyUpdate", Data: &snapshot.Enemies[j]})
+					}
+				}
+				if _, err := codec.EncodeBatch(batch); err != nil {
+					b.Fatal(err)
+				}
+				continue
+			}
+
+			encoded = encoded[:0]
+			for _, snapshot := range snapshots {
+				chunks := snapshot.encoded(codec)
+				if chunks.err != nil {
+					b.Fatal(chunks.err)
+				}
+				encoded = append(encoded, chunks.players...)
+				encoded = append(encoded, chunks.enemies...)
+			}
+			payload, err := codec.AppendBatch(getFrameBuffer(), header, encoded)
+			if err != nil {
+				b.Fatal(err)
+			}
+			putFrameBuffer(payload)
+		}
+	}
+}
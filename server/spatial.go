@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+)
+
+// SpatialHash buckets keyed positions into square cells so radius and
+// rectangle queries only visit entities in nearby cells instead of all of
+// them. Not safe for concurrent use: a hash is owned by whoever builds it,
+// and a snapshot's hashes are read-only once the snapshot is published.
+type SpatialHash[K comparable] struct {
+	cellSize float32
+	cells    map[spatialCell][]K
+	entries  map[K]spatialEntry
+}
+
+type spatialCell struct {
+	x, y int32
+}
+
+type spatialEntry struct {
+	x, y float32
+	cell spatialCell
+}
+
+// NewSpatialHash creates an empty hash with cells of cellSize pixels
+func NewSpatialHash[K comparable](cellSize float32) *SpatialHash[K] {
+	return &SpatialHash[K]{
+		cellSize: cellSize,
+		cells:    make(map[spatialCell][]K),
+		entries:  make(map[K]spatialEntry),
+	}
+}
+
+func (h *SpatialHash[K]) cellAt(x, y float32) spatialCell {
+	return spatialCell{
+		x: int32(math.Floor(float64(x / h.cellSize))),
+		y: int32(math.Floor(float64(y / h.cellSize))),
+	}
+}
+
+// Insert adds key at x, y, or moves it there if it is already in the hash
+func (h *SpatialHash[K]) Insert(key K, x, y float32) {
+	cell := h.cellAt(x, y)
+	if entry, exists := h.entries[key]; exists {
+		if entry.cell != cell {
+			h.removeFromCell(key, entry.cell)
+			h.cells[cell] = append(h.cells[cell], key)
+		}
+	} else {
+		h.cells[cell] = append(h.cells[cell], key)
+	}
+	h.entries[key] = spatialEntry{x: x, y: y, cell: cell}
+}
+
+// Move updates the position of key, same as Insert
+func (h *SpatialHash[K]) Move(key K, x, y float32) {
+	h.Insert(key, x, y)
+}
+
+// Remove drops key from the hash
+func (h *SpatialHash[K]) Remove(key K) {
+	entry, exists := h.entries[key]
+	if !exists {
+		return
+	}
+	h.removeFromCell(key, entry.cell)
+	delete(h.entries, key)
+}
+
+func (h *SpatialHash[K]) removeFromCell(key K, cell spatialCell) {
+	keys := h.cells[cell]
+	for i, k := range keys {
+		if k == key {
+			keys[i] = keys[len(keys)-1]
+			keys = keys[:len(keys)-1]
+			break
+		}
+	}
+	if len(keys) == 0 {
+		delete(h.cells, cell)
+	} else {
+		h.cells[cell] = keys
+	}
+}
+
+// Len returns the number of keys in the hash
+func (h *SpatialHash[K]) Len() int {
+	return len(h.entries)
+}
+
+// Position returns where key is
+func (h *SpatialHash[K]) Position(key K) (float32, float32, bool) {
+	entry, exists := h.entries[key]
+	return entry.x, entry.y, exists
+}
+
+// QueryRect calls visit for every key inside the rectangle, edges included
+func (h *SpatialHash[K]) QueryRect(minX, minY, maxX, maxY float32, visit func(key K, x, y float32)) {
+	from, to := h.cellAt(minX, minY), h.cellAt(maxX, maxY)
+	for cy := from.y; cy <= to.y; cy++ {
+		for cx := from.x; cx <= to.x; cx++ {
+			for _, key := range h.cells[spatialCell{cx, cy}] {
+				entry := h.entries[key]
+				if entry.x >= minX && entry.x <= maxX && entry.y >= minY && entry.y <= maxY {
+					visit(key, entry.x, entry.y)
+				}
+			}
+		}
+	}
+}
+
+// QueryRadius calls visit for every key within radius of x, y, passing its
+// squared distance
+func (h *SpatialHash[K]) QueryRadius(x, y, radius float32, visit func(key K, distanceSquared float32)) {
+	radiusSquared := radius * radius
+	h.QueryRect(x-radius, y-radius, x+radius, y+radius, func(key K, kx, ky float32) {
+		dx, dy := kx-x, ky-y
+		if distanceSquared := dx*dx + dy*dy; distanceSquared <= radiusSquared {
+			visit(key, distanceSquared)
+		}
+	})
+}
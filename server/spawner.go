@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Enemy spawners
+//
+// Enemies come from the enemy layers of a zone's tilemap, the ones with the
+// isEnemyLayer property: every tile painted on such a layer is a spot an
+// enemy of the layer's enemyType can appear on. A layer becomes a spawner
+// with its own population target, spawnChance of its tiles, and its own
+// timer: every respawnInterval_s it tops its population back up on free
+// tiles. Zones whose tilemap has no enemy layers get the world file's random
+// population instead.
+//
+// Spawners belong to their zone and are only touched by its worker.
+
+// EnemySpawner keeps the population of one enemy layer of a zone
+type EnemySpawner struct {
+	Name            string
+	EnemyType       string
+	SpawnChance     float64
+	RespawnInterval time.Duration
+	Tiles           []TilePosition // shared with other zones using the map, read only
+	Target          int            // how many enemies the layer keeps alive
+
+	alive       map[string]int // enemy ID to the index of its tile
+	nextRespawn time.Time
+}
+
+// enemySpawners returns the spawners of the enemy layers of a tilemap
+func enemySpawners(tilemap *Tilemap) []*EnemySpawner {
+	var spawners []*EnemySpawner
+	tilemap.EachLayer(func(layer *TilemapLayer) {
+		if layer.Type != "tilelayer" || !layer.BoolProperty("isEnemyLayer") {
+			return
+		}
+		spawner := &EnemySpawner{
+			Name:            layer.Name,
+			EnemyType:       layer.StringProperty("enemyType"),
+			SpawnChance:     layer.FloatProperty("spawnChance"),
+			RespawnInterval: time.Duration(layer.FloatProperty("respawnInterval_s") * float64(time.Second)),
+			Tiles:           layer.OccupiedTiles(),
+			alive:           make(map[string]int),
+		}
+		if err := checkEnemyLayer(layer); err != nil {
+			log.Printf("Ignoring enemy layer %s: %v", layer.Name, err)
+			return
+		}
+		spawner.Target = int(float64(len(spawner.Tiles)) * min(spawner.SpawnChance, 1))
+		spawners = append(spawners, spawner)
+	})
+	return spawners
+}
+
+// checkEnemyLayer reports what keeps an enemy layer from spawning enemies
+func checkEnemyLayer(layer *TilemapLayer) error {
+	var problems []error
+	if enemyType := layer.StringProperty("enemyType"); enemyType == "" {
+		problems = append(problems, errors.New("enemyType is missing"))
+	} else if _, known := EnemyConfigs[enemyType]; !known {
+		problems = append(problems, fmt.Errorf("enemyType %q is not one of %s", enemyType, strings.Join(EnemyTiers, ", ")))
+	}
+	for _, name := range []string{"spawnChance", "respawnInterval_s"} {
+		if layer.FloatProperty(name) <= 0 {
+			problems = append(problems, fmt.Errorf("%s must be a positive number", name))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// populate spawns the zone's first enemies, each tile of a spawner getting
+// one with the layer's spawn chance
+func (z *Zone) populate(now time.Time) {
+	for _, spawner := range z.Spawners {
+		for i := range spawner.Tiles {
+			if rand.Float64() < spawner.SpawnChance {
+				z.spawnAt(spawner, i)
+			}
+		}
+		spawner.nextRespawn = now.Add(spawner.RespawnInterval)
+	}
+}
+
+// respawnEnemies tops up the population of every spawner whose timer is up.
+// Called by the zone's worker every tick.
+func (z *Zone) respawnEnemies(now time.Time) {
+	for _, spawner := range z.Spawners {
+		if now.Before(spawner.nextRespawn) {
+			continue
+		}
+		spawner.nextRespawn = now.Add(spawner.RespawnInterval)
+
+		occupied := make(map[int]bool, len(spawner.alive))
+		for enemyID, tile := range spawner.alive {
+			if _, exists := z.Enemies[enemyID]; !exists {
+				delete(spawner.alive, enemyID)
+				continue
+			}
+			occupied[tile] = true
+		}
+		missing := spawner.Target - len(spawner.alive)
+		for _, tile := range rand.Perm(len(spawner.Tiles)) {
+			if missing <= 0 {
+				break
+			}
+			if !occupied[tile] {
+				z.spawnAt(spawner, tile)
+				missing--
+			}
+		}
+	}
+}
+
+// spawnAt places an enemy of the spawner on the middle of one of its tiles
+func (z *Zone) spawnAt(spawner *EnemySpawner, tile int) {
+	position := spawner.Tiles[tile]
+	x := z.WorldX + (float32(position.X)+0.5)*float32(TileSize)
+	y := z.WorldY + (float32(position.Y)+0.5)*float32(TileSize)
+	enemy := NewEnemy(z.ID, x, y, spawner.EnemyType)
+	spawner.alive[enemy.ID] = tile
+	z.addEnemy(enemy)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// enemyLayer is a layer of a 4x4 map with the given tiles painted and
+// properties set
+func enemyLayer(name string, tiles int, properties ...TilemapProperty) TilemapLayer {
+	data := make([]int, 16)
+	for i := 0; i < tiles; i++ {
+		data[i] = 1
+	}
+	return TilemapLayer{Name: name, Type: "tilelayer", Width: 4, Height: 4, Data: data, Properties: properties}
+}
+
+// enemyLayerProperties makes a layer spawn enemyType with the given chance
+// and interval
+func enemyLayerProperties(enemyType string, spawnChance, respawnInterval float64) []TilemapProperty {
+	return []TilemapProperty{
+		{Name: "isEnemyLayer", Type: "bool", Value: true},
+		{Name: "enemyType", Type: "string", Value: enemyType},
+		{Name: "spawnChance", Type: "float", Value: spawnChance},
+		{Name: "respawnInterval_s", Type: "float", Value: respawnInterval},
+	}
+}
+
+// TestEnemySpawners checks which layers become spawners and how many
+// enemies they keep
+func TestEnemySpawners(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	tilemap := &Tilemap{Width: 4, Height: 4, Layers: []TilemapLayer{
+		enemyLayer("ground", 16),
+		enemyLayer("easy", 10, enemyLayerProperties("easy", 0.5, 30)...),
+		enemyLayer("unknown type", 10, enemyLayerProperties("dragon", 0.5, 30)...),
+		enemyLayer("never", 10, enemyLayerProperties("easy", 0, 30)...),
+		{Type: "group", Layers: []TilemapLayer{enemyLayer("grouped", 4, enemyLayerProperties("easy", 2, 5)...)}},
+	}}
+	spawners := enemySpawners(tilemap)
+	if len(spawners) != 2 {
+		t.Fatalf("%d spawners, want the easy and grouped layers", len(spawners))
+	}
+	if easy := spawners[0]; easy.Name != "easy" || easy.Target != 5 || easy.RespawnInterval != 30*time.Second || len(easy.Tiles) != 10 {
+		t.Errorf("easy layer spawner %+v, want 5 of 10 tiles every 30s", easy)
+	}
+	if grouped := spawners[1]; grouped.Target != 4 {
+		t.Errorf("a spawn chance over 1 keeps %d of 4 tiles alive, want every one", grouped.Target)
+	}
+}
+
+// TestEnemyRespawn checks that a spawner fills its tiles, and tops its
+// population back up on free tiles once its interval is up
+func TestEnemyRespawn(t *testing.T) {
+	tilemap := &Tilemap{Width: 4, Height: 4, Layers: []TilemapLayer{
+		enemyLayer("easy", 8, enemyLayerProperties("easy", 1, 30)...),
+	}}
+	zone := benchmarkZone(rand.New(rand.NewSource(1)), 1, 0, 0)
+	zone.Spawners = enemySpawners(tilemap)
+	start := time.Unix(1700000000, 0)
+	zone.populate(start)
+
+	// counts the enemies on each tile of the layer
+	tileCounts := func() map[TilePosition]int {
+		counts := make(map[TilePosition]int)
+		for _, enemy := range zone.Enemies {
+			tile := TilePosition{X: int(enemy.X) / TileSize, Y: int(enemy.Y) / TileSize}
+			counts[tile]++
+		}
+		return counts
+	}
+	if counts := tileCounts(); len(zone.Enemies) != 8 || len(counts) != 8 {
+		t.Fatalf("%d enemies on %d tiles, want one on each of 8", len(zone.Enemies), len(counts))
+	}
+
+	killed := 0
+	for enemyID := range zone.Enemies {
+		if killed == 3 {
+			break
+		}
+		zone.removeEnemy(enemyID)
+		killed++
+	}
+	zone.respawnEnemies(start.Add(29 * time.Second))
+	if len(zone.Enemies) != 5 {
+		t.Errorf("%d enemies before the respawn interval is up, want 5", len(zone.Enemies))
+	}
+	zone.respawnEnemies(start.Add(30 * time.Second))
+	if counts := tileCounts(); len(zone.Enemies) != 8 || len(counts) != 8 {
+		t.Errorf("%d enemies on %d tiles after respawning, want one on each of 8", len(zone.Enemies), len(counts))
+	}
+}
@@ -1,206 +1,472 @@
 package main
 
-/*
-package main
-
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
+	"io"
 	"os"
-	"time"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// Tiled maps
+//
+// Every zone's TilemapRef names a map made with Tiled, either its TMX source
+// or a JSON export, the client renders the JSON. The server reads them from
+// TilemapDir: a ref with a .tmx or .json extension is a path in it, any
+// other ref is looked for as <ref>.json, <ref>.tmx, maps/<ref>.json and
+// maps/<ref>.tmx in that order. Both formats load into the same Tilemap,
+// keeping what the simulation needs: the layers with their tiles, objects
+// and custom properties. Zones sharing a ref share one parsed map, it is
+// never modified after loading.
+//
+// Layer data can be CSV, base64 or base64 compressed with zlib or gzip.
+// Infinite maps store their layers as chunks; they are flattened to one
+// finite map covering every chunk, whose top left corner goes in the top
+// left corner of the zone.
+
+// TilemapDir is where the Tiled maps are read from, overridable from the
+// command line
+var TilemapDir = "../shared/tilemap"
+
+// Tilemap is a parsed Tiled map
+type Tilemap struct {
+	Width      int               `json:"width"`  // in tiles
+	Height     int               `json:"height"` // in tiles
+	TileWidth  int               `json:"tilewidth"`
+	TileHeight int               `json:"tileheight"`
+	Infinite   bool              `json:"infinite"`
+	Properties []TilemapProperty `json:"properties"`
+	Layers     []TilemapLayer    `json:"layers"`
+	Tilesets   []TilemapTileset  `json:"tilesets"`
+
+	// tile coordinates of the top left corner of an infinite map, as placed
+	// in Tiled
+	OriginX, OriginY int `json:"-"`
+
+	// whether each tile, row by row, blocks movement, see collision.go
+	Collision []bool `json:"-"`
+
+	// the map's portals and the ones drawn as tiles by tile index, see
+	// portal.go
+	Portals     []*Portal `json:"-"`
+	portalTiles map[int]*Portal
+}
+
+// TilemapTileset is a tileset embedded in a map, only its tiles with custom
+// properties are kept
+type TilemapTileset struct {
+	FirstGID  int           `json:"firstgid"`
+	Name      string        `json:"name"`
+	Source    string        `json:"source"` // set instead of the tiles for external tilesets
+	Image     string        `json:"image"`  // relative to the map's file
+	TileCount int           `json:"tilecount"`
+	Tiles     []TilesetTile `json:"tiles"`
+}
+
+// TilesetTile is a tile of a tileset with custom properties
+type TilesetTile struct {
+	ID         int               `json:"id"`
+	Properties []TilemapProperty `json:"properties"`
+}
+
+// TilemapLayer is a tile layer, an object layer, or a group of layers
 type TilemapLayer struct {
 	Name       string            `json:"name"`
-	Type       string            `json:"type"`
+	Type       string            `json:"type"` // "tilelayer", "group", "objectgroup"...
 	Properties []TilemapProperty `json:"properties"`
-	Data       []int             `json:"data"`
+	Data       []int             `json:"-"` // global tile IDs row by row, 0 for no tile
 	Layers     []TilemapLayer    `json:"layers"`
 	Objects    []TilemapObject   `json:"objects"`
 	Width      int               `json:"width"`
 	Height     int               `json:"height"`
-}
 
-type TilemapProperty struct {
-	Name  string      `json:"name"`
-	Type  string      `json:"type"`
-	Value interface{} `json:"value"`
+	// tiles of an infinite map, until flattenChunks turns them into Data
+	chunks []tileChunk
 }
 
+// TilemapObject is a shape placed on an object layer, in pixels from the
+// map's top left corner
 type TilemapObject struct {
+	ID         int               `json:"id"`
+	Name       string            `json:"name"`
+	Type       string            `json:"type"` // the class since Tiled 1.9
+	X          float32           `json:"x"`
+	Y          float32           `json:"y"`
+	Width      float32           `json:"width"`
+	Height     float32           `json:"height"`
+	Point      bool              `json:"point"`
+	Ellipse    bool              `json:"ellipse"`
 	Properties []TilemapProperty `json:"properties"`
 }
 
-type EnemyLayer struct {
-	Name             string
-	EnemyType        string
-	RespawnIntervalS float64
-	SpawnChance      float64
-	OccupiedTiles    []TilePosition
-	EnemiesOnLayer   map[string]*Enemy
-	LastRespawnTime  int64
+// TilemapProperty is a custom property set on a map, layer, object or tile
+// in Tiled. Values are bools for bool properties, float64s for int, float
+// and object properties, maps for class properties and strings otherwise,
+// as decoded from JSON.
+type TilemapProperty struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
 }
 
+// TilePosition is a tile's column and row in its map
 type TilePosition struct {
 	X int
 	Y int
 }
 
-var enemyLayers = make(map[string]*EnemyLayer)
+// tileChunk is a rectangle of tiles of an infinite map
+type tileChunk struct {
+	X, Y, Width, Height int
+	Data                []int
+}
 
-func loadTilemapForZone(zone *Zone) error {
-	tilemapData, err := os.ReadFile("../shared/tilemap/mmorpg.json")
+// LoadTilemap reads a Tiled map, TMX if the file ends in .tmx and JSON
+// otherwise
+func LoadTilemap(path string) (*Tilemap, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tilemap *Tilemap
+	if strings.EqualFold(filepath.Ext(path), ".tmx") {
+		tilemap, err = parseTMX(raw)
+	} else {
+		tilemap = &Tilemap{}
+		err = json.Unmarshal(raw, tilemap)
+	}
 	if err != nil {
-		log.Println("Failed to read tilemap file:", err)
+		return nil, fmt.Errorf("tilemap %s: %w", path, err)
+	}
+	if tilemap.Infinite {
+		if err := tilemap.flattenChunks(); err != nil {
+			return nil, fmt.Errorf("tilemap %s: %w", path, err)
+		}
+	}
+	var problems []error
+	tilemap.EachLayer(func(layer *TilemapLayer) {
+		if layer.Type == "tilelayer" && len(layer.Data) != layer.Width*layer.Height {
+			problems = append(problems, fmt.Errorf("tilemap %s: layer %s has %d tiles for %dx%d",
+				path, layer.Name, len(layer.Data), layer.Width, layer.Height))
+		}
+	})
+	if err := errors.Join(problems...); err != nil {
+		return nil, err
+	}
+	tilemap.buildCollision()
+	tilemap.buildPortals()
+	return tilemap, nil
+}
+
+// UnmarshalJSON decodes a layer of a Tiled JSON map, whose tiles are either
+// an array or an encoded string, in chunks for infinite maps
+func (l *TilemapLayer) UnmarshalJSON(raw []byte) error {
+	type plainLayer TilemapLayer
+	var layer struct {
+		plainLayer
+		Data        json.RawMessage `json:"data"`
+		Encoding    string          `json:"encoding"`
+		Compression string          `json:"compression"`
+		Chunks      []struct {
+			X      int             `json:"x"`
+			Y      int             `json:"y"`
+			Width  int             `json:"width"`
+			Height int             `json:"height"`
+			Data   json.RawMessage `json:"data"`
+		} `json:"chunks"`
+	}
+	if err := json.Unmarshal(raw, &layer); err != nil {
 		return err
 	}
-	var tilemap struct {
-		Layers []TilemapLayer `json:"layers"`
+	*l = TilemapLayer(layer.plainLayer)
+
+	var err error
+	if len(layer.Data) > 0 {
+		if l.Data, err = decodeJSONTileData(layer.Data, layer.Encoding, layer.Compression); err != nil {
+			return fmt.Errorf("layer %s: %w", l.Name, err)
+		}
 	}
-	if err := json.Unmarshal(tilemapData, &tilemap); err != nil {
-		log.Println("Failed to parse tilemap JSON:", err)
+	for _, chunk := range layer.Chunks {
+		data, err := decodeJSONTileData(chunk.Data, layer.Encoding, layer.Compression)
+		if err != nil {
+			return fmt.Errorf("layer %s: chunk at %d,%d: %w", l.Name, chunk.X, chunk.Y, err)
+		}
+		l.chunks = append(l.chunks, tileChunk{X: chunk.X, Y: chunk.Y, Width: chunk.Width, Height: chunk.Height, Data: data})
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes an object, which has a class instead of a type
+// since Tiled 1.9
+func (o *TilemapObject) UnmarshalJSON(raw []byte) error {
+	type plainObject TilemapObject
+	var object struct {
+		plainObject
+		Class string `json:"class"`
+	}
+	if err := json.Unmarshal(raw, &object); err != nil {
 		return err
 	}
-	zone.Tilemap = &Tilemap{Layers: tilemap.Layers}
-	for _, layer := range tilemap.Layers {
-		processLayer(zone, layer, "")
+	*o = TilemapObject(object.plainObject)
+	if o.Type == "" {
+		o.Type = object.Class
 	}
 	return nil
 }
 
-func processLayer(zone *Zone, layer TilemapLayer, parentGroup string) {
-	if layer.Type == "group" {
-		for _, subLayer := range layer.Layers {
-			processLayer(zone, subLayer, layer.Name)
-		}
-		return
+// decodeJSONTileData decodes the data of a JSON layer or chunk, an array of
+// global tile IDs or a base64 string
+func decodeJSONTileData(raw json.RawMessage, encoding, compression string) ([]int, error) {
+	if len(raw) > 0 && raw[0] == '[' {
+		var data []int
+		err := json.Unmarshal(raw, &data)
+		return data, err
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return nil, err
 	}
+	return decodeTileData(text, encoding, compression)
+}
 
-	var isEnemyLayer bool
-	var enemyType string
-	var respawnIntervalS float64
-	var spawnChance float64
-	for _, prop := range layer.Properties {
-		switch prop.Name {
-		case "isEnemyLayer":
-			if prop.Type == "bool" {
-				isEnemyLayer = prop.Value.(bool)
-			}
-		case "enemyType":
-			if prop.Type == "string" {
-				enemyType = prop.Value.(string)
+// decodeTileData decodes layer data encoded as CSV or base64, optionally
+// compressed, as TMX and Tiled JSON store it
+func decodeTileData(text, encoding, compression string) ([]int, error) {
+	switch encoding {
+	case "csv":
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+		})
+		data := make([]int, len(fields))
+		for i, field := range fields {
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad tile %q in CSV data", field)
 			}
-		case "respawnInterval_s":
-			if prop.Type == "float" {
-				respawnIntervalS = prop.Value.(float64)
+			data[i] = int(gid)
+		}
+		return data, nil
+
+	case "base64":
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("base64 data: %w", err)
+		}
+		var reader io.Reader = bytes.NewReader(raw)
+		switch compression {
+		case "":
+		case "zlib":
+			if reader, err = zlib.NewReader(reader); err != nil {
+				return nil, fmt.Errorf("zlib data: %w", err)
 			}
-		case "spawnChance":
-			if prop.Type == "float" {
-				spawnChance = prop.Value.(float64)
+		case "gzip":
+			if reader, err = gzip.NewReader(reader); err != nil {
+				return nil, fmt.Errorf("gzip data: %w", err)
 			}
+		default:
+			return nil, fmt.Errorf("unsupported compression %q, use zlib or gzip", compression)
+		}
+		if raw, err = io.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("%s data: %w", compression, err)
 		}
+		if len(raw)%4 != 0 {
+			return nil, fmt.Errorf("base64 data is %d bytes, not a whole number of tiles", len(raw))
+		}
+		data := make([]int, len(raw)/4)
+		for i := range data {
+			data[i] = int(binary.LittleEndian.Uint32(raw[i*4:]))
+		}
+		return data, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q, use csv or base64", encoding)
 	}
-	if isEnemyLayer && enemyType != "" && respawnIntervalS > 0 && spawnChance > 0 {
-		enemyLayer := &EnemyLayer{
-			Name:             layer.Name,
-			EnemyType:        enemyType,
-			RespawnIntervalS: respawnIntervalS,
-			SpawnChance:      spawnChance,
-			OccupiedTiles:    make([]TilePosition, 0),
-			EnemiesOnLayer:   make(map[string]*Enemy),
-			LastRespawnTime:  time.Now().UnixMilli(),
-		}
-		if layer.Type == "tilelayer" && layer.Data != nil {
-			for y := 0; y < layer.Height; y++ {
-				for x := 0; x < layer.Width; x++ {
-					tileIndex := y*layer.Width + x
-					if tileIndex < len(layer.Data) && layer.Data[tileIndex] != 0 {
-						enemyLayer.OccupiedTiles = append(enemyLayer.OccupiedTiles, TilePosition{X: x, Y: y})
-					}
-				}
+}
+
+// maxTilemapSide bounds the size in tiles of infinite maps and their
+// chunks, so a corrupt chunk can't ask for gigabytes of tiles
+const maxTilemapSide = 4096
+
+// flattenChunks turns an infinite map into a finite one just big enough for
+// all its chunks
+func (t *Tilemap) flattenChunks() error {
+	var problems []error
+	first := true
+	var minX, minY, maxX, maxY int
+	t.EachLayer(func(layer *TilemapLayer) {
+		for _, chunk := range layer.chunks {
+			switch {
+			case chunk.Width <= 0 || chunk.Height <= 0 || chunk.Width > maxTilemapSide || chunk.Height > maxTilemapSide:
+				problems = append(problems, fmt.Errorf("layer %s: chunk at %d,%d is %dx%d tiles", layer.Name, chunk.X, chunk.Y, chunk.Width, chunk.Height))
+				continue
+			case max(chunk.X, -chunk.X, chunk.Y, -chunk.Y) > maxTilemapSide*maxTilemapSide:
+				problems = append(problems, fmt.Errorf("layer %s: chunk at %d,%d is too far out", layer.Name, chunk.X, chunk.Y))
+				continue
+			case len(chunk.Data) != chunk.Width*chunk.Height:
+				problems = append(problems, fmt.Errorf("layer %s: chunk at %d,%d has %d tiles for %dx%d", layer.Name, chunk.X, chunk.Y, len(chunk.Data), chunk.Width, chunk.Height))
+				continue
 			}
+			if first {
+				minX, minY, maxX, maxY = chunk.X, chunk.Y, chunk.X+chunk.Width, chunk.Y+chunk.Height
+				first = false
+				continue
+			}
+			minX, minY = min(minX, chunk.X), min(minY, chunk.Y)
+			maxX, maxY = max(maxX, chunk.X+chunk.Width), max(maxY, chunk.Y+chunk.Height)
 		}
-		initialSpawnEnemies(zone, enemyLayer)
-		zone.Mu.Lock()
-		enemyLayers[layer.Name] = enemyLayer
-		zone.Mu.Unlock()
+	})
+	if maxX-minX > maxTilemapSide || maxY-minY > maxTilemapSide {
+		problems = append(problems, fmt.Errorf("chunks span %dx%d tiles, more than %d a side", maxX-minX, maxY-minY, maxTilemapSide))
 	}
+	if err := errors.Join(problems...); err != nil {
+		return err
+	}
+
+	t.Infinite = false
+	t.OriginX, t.OriginY = minX, minY
+	t.Width, t.Height = maxX-minX, maxY-minY
+	t.EachLayer(func(layer *TilemapLayer) {
+		if layer.Type != "tilelayer" {
+			return
+		}
+		layer.Width, layer.Height = t.Width, t.Height
+		layer.Data = make([]int, t.Width*t.Height)
+		for _, chunk := range layer.chunks {
+			for i, gid := range chunk.Data {
+				x, y := chunk.X-minX+i%chunk.Width, chunk.Y-minY+i/chunk.Width
+				layer.Data[y*t.Width+x] = gid
+			}
+		}
+		layer.chunks = nil
+	})
+	return nil
 }
 
-func initialSpawnEnemies(zone *Zone, layer *EnemyLayer) {
-	rand.Seed(time.Now().UnixNano())
-	count := 0
-	for _, tile := range layer.OccupiedTiles {
-		if rand.Float64() < layer.SpawnChance {
-			spawnEnemy(zone, layer, tile.X, tile.Y)
-			count++
+// tilemapPath finds the file of a tilemap ref in TilemapDir
+func tilemapPath(ref string) (string, error) {
+	switch strings.ToLower(filepath.Ext(ref)) {
+	case ".json", ".tmx":
+		path := filepath.Join(TilemapDir, ref)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("tilemap %s: %w", ref, err)
+		}
+		return path, nil
+	}
+	for _, dir := range []string{TilemapDir, filepath.Join(TilemapDir, "maps")} {
+		for _, extension := range []string{".json", ".tmx"} {
+			path := filepath.Join(dir, ref+extension)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
 		}
 	}
-	log.Println("Spawned", count, "enemies in zone", zone.ID)
+	return "", fmt.Errorf("no tilemap %s.json or %s.tmx in %s or %s", ref, ref, TilemapDir, filepath.Join(TilemapDir, "maps"))
 }
 
-func spawnEnemy(zone *Zone, layer *EnemyLayer, tileX, tileY int) {
-	enemyID := generateEnemyID(layer.Name, tileX, tileY)
-	x := float32(tileX * PIXELS_PER_TILE)
-	y := float32(tileY * PIXELS_PER_TILE)
-	e := NewEnemy(enemyID, layer.EnemyType, layer.Name, x, y)
-	zone.Mu.Lock()
-	zone.Enemies[enemyID] = e
-	zone.Mu.Unlock()
+// LoadTilemaps loads the map of every tilemap ref in the world into
+// World.Tilemaps, checking they match the world's zone and tile sizes.
+// Called after InitializeWorld.
+func LoadTilemaps() error {
+	World.Tilemaps = make(map[string]*Tilemap)
+	var problems []error
+	for _, zoneConfig := range World.ZoneConfigs {
+		ref := zoneConfig.TilemapRef
+		if _, loaded := World.Tilemaps[ref]; loaded || IsEmptyTilemapGridName(ref) {
+			continue
+		}
+		path, err := tilemapPath(ref)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		tilemap, err := LoadTilemap(path)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if err := tilemap.checkSize(); err != nil {
+			problems = append(problems, fmt.Errorf("tilemap %s %w", path, err))
+			continue
+		}
+		World.Tilemaps[ref] = tilemap
+	}
+	return errors.Join(problems...)
 }
 
-func generateEnemyID(layerName string, tileX, tileY int) string {
-	return fmt.Sprintf("%s_%d_%d_%d", layerName, tileX, tileY, time.Now().Nanosecond())
+// checkSize reports a map that doesn't fit the world's zones exactly
+func (t *Tilemap) checkSize() error {
+	if t.Width != World.ZoneSize || t.Height != World.ZoneSize || t.TileWidth != TileSize || t.TileHeight != TileSize {
+		return fmt.Errorf("is %dx%d tiles of %dx%d pixels, zones are %dx%d tiles of %dx%d",
+			t.Width, t.Height, t.TileWidth, t.TileHeight, World.ZoneSize, World.ZoneSize, TileSize, TileSize)
+	}
+	return nil
 }
 
-func HandleEnemyRespawns() {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		currentTime := time.Now().UnixMilli()
-		for _, zone := range zones {
-			zone.Mu.RLock()
-			for _, layer := range enemyLayers {
-				if (currentTime-layer.LastRespawnTime)/1000 >= int64(layer.RespawnIntervalS) {
-					respawnEnemies(zone, layer, currentTime)
-					zone.Mu.Lock()
-					layer.LastRespawnTime = currentTime
-					zone.Mu.Unlock()
-				}
+// EachLayer calls visit for every layer of the map, looking inside groups
+func (t *Tilemap) EachLayer(visit func(layer *TilemapLayer)) {
+	var walk func(layers []TilemapLayer)
+	walk = func(layers []TilemapLayer) {
+		for i := range layers {
+			if layers[i].Type == "group" {
+				walk(layers[i].Layers)
+				continue
 			}
-			zone.Mu.RUnlock()
+			visit(&layers[i])
 		}
 	}
+	walk(t.Layers)
 }
 
-func respawnEnemies(zone *Zone, layer *EnemyLayer, currentTime int64) {
-	totalTiles := len(layer.OccupiedTiles)
-	targetEnemies := int(float64(totalTiles) * layer.SpawnChance)
-	currentEnemies := countAliveEnemies(zone, layer)
-	enemiesToSpawn := targetEnemies - currentEnemies
-	if enemiesToSpawn <= 0 {
-		return
-	}
-	rand.Shuffle(len(layer.OccupiedTiles), func(i, j int) {
-		layer.OccupiedTiles[i], layer.OccupiedTiles[j] = layer.OccupiedTiles[j], layer.OccupiedTiles[i]
-	})
-	for i := 0; i < enemiesToSpawn && i < len(layer.OccupiedTiles); i++ {
-		tile := layer.OccupiedTiles[i]
-		spawnEnemy(zone, layer, tile.X, tile.Y)
+// Property returns the value of a custom property of the layer
+func (l *TilemapLayer) Property(name string) (interface{}, bool) {
+	for _, property := range l.Properties {
+		if property.Name == name {
+			return property.Value, true
+		}
 	}
+	return nil, false
+}
+
+// StringProperty returns a string property, or "" if it isn't set
+func (l *TilemapLayer) StringProperty(name string) string {
+	value, _ := l.Property(name)
+	s, _ := value.(string)
+	return s
 }
 
-func countAliveEnemies(zone *Zone, layer *EnemyLayer) int {
-	count := 0
-	zone.Mu.RLock()
-	defer zone.Mu.RUnlock()
-	for _, enemy := range zone.Enemies {
-		if enemy.IsAlive && enemy.LayerName == layer.Name {
-			count++
+// FloatProperty returns a float or int property, or 0 if it isn't set
+func (l *TilemapLayer) FloatProperty(name string) float64 {
+	value, _ := l.Property(name)
+	f, _ := value.(float64)
+	return f
+}
+
+// BoolProperty returns a bool property, or false if it isn't set
+func (l *TilemapLayer) BoolProperty(name string) bool {
+	value, _ := l.Property(name)
+	b, _ := value.(bool)
+	return b
+}
+
+// tileGIDMask clears the flip and rotation flags Tiled stores in the high
+// bits of global tile IDs
+const tileGIDMask = 0x0FFFFFFF
+
+// OccupiedTiles returns the positions of the layer's tiles
+func (l *TilemapLayer) OccupiedTiles() []TilePosition {
+	var tiles []TilePosition
+	for i, gid := range l.Data {
+		if gid&tileGIDMask != 0 {
+			tiles = append(tiles, TilePosition{X: i % l.Width, Y: i / l.Width})
 		}
 	}
-	return count
+	return tiles
 }
-*/
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tileDataGIDs are the tiles of the 3x2 layers of the decoding tests, one
+// flipped horizontally
+var tileDataGIDs = []int{1, 0, 2, 0x80000003, 0, 4}
+
+// encodeTileData encodes gids as Tiled does for an encoding and compression
+func encodeTileData(t *testing.T, gids []int, encoding, compression string) string {
+	t.Helper()
+	if encoding == "csv" {
+		fields := make([]string, len(gids))
+		for i, gid := range gids {
+			fields[i] = fmt.Sprint(uint32(gid))
+		}
+		return "\n" + strings.Join(fields, ",") + "\n"
+	}
+	var raw bytes.Buffer
+	var writer interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch compression {
+	case "zlib":
+		writer = zlib.NewWriter(&raw)
+	case "gzip":
+		writer = gzip.NewWriter(&raw)
+	}
+	for _, gid := range gids {
+		tile := binary.LittleEndian.AppendUint32(nil, uint32(gid))
+		if writer != nil {
+			writer.Write(tile)
+		} else {
+			raw.Write(tile)
+		}
+	}
+	if writer != nil {
+		writer.Close()
+	}
+	return base64.StdEncoding.EncodeToString(raw.Bytes())
+}
+
+var tileDataFormats = []struct{ encoding, compression string }{
+	{"csv", ""}, {"base64", ""}, {"base64", "zlib"}, {"base64", "gzip"},
+}
+
+// TestTMXTileData checks that layers decode to the same tiles in every
+// format TMX maps store them in
+func TestTMXTileData(t *testing.T) {
+	layers := map[string]string{
+		"tile elements": `<data><tile gid="1"/><tile/><tile gid="2"/><tile gid="2147483651"/><tile/><tile gid="4"/></data>`,
+	}
+	for _, format := range tileDataFormats {
+		layers[format.encoding+" "+format.compression] = fmt.Sprintf(`<data encoding=%q compression=%q>%s</data>`,
+			format.encoding, format.compression, encodeTileData(t, tileDataGIDs, format.encoding, format.compression))
+	}
+	for name, data := range layers {
+		tilemap, err := parseTMX([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<map version="1.10" orientation="orthogonal" width="3" height="2" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="tiles" tilecount="4"/>
+ <layer id="1" name="ground" width="3" height="2">` + data + `</layer>
+</map>`))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := tilemap.Layers[0].Data; !reflect.DeepEqual(got, tileDataGIDs) {
+			t.Errorf("%s: decoded %v, want %v", name, got, tileDataGIDs)
+		}
+	}
+}
+
+// TestJSONTileData checks the same for Tiled JSON maps, which store tiles
+// as an array or a base64 string
+func TestJSONTileData(t *testing.T) {
+	layers := map[string]string{"array": `"data": [1, 0, 2, 2147483651, 0, 4]`}
+	for _, format := range tileDataFormats[1:] {
+		layers[format.encoding+" "+format.compression] = fmt.Sprintf(`"encoding": %q, "compression": %q, "data": %q`,
+			format.encoding, format.compression, encodeTileData(t, tileDataGIDs, format.encoding, format.compression))
+	}
+	for name, data := range layers {
+		var tilemap Tilemap
+		err := json.Unmarshal([]byte(`{"width": 3, "height": 2, "layers": [
+			{"name": "ground", "type": "tilelayer", "width": 3, "height": 2, `+data+`}]}`), &tilemap)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := tilemap.Layers[0].Data; !reflect.DeepEqual(got, tileDataGIDs) {
+			t.Errorf("%s: decoded %v, want %v", name, got, tileDataGIDs)
+		}
+	}
+}
+
+// TestInfiniteTilemap checks that the chunks of an infinite map end up in
+// one finite layer just big enough for them, and that broken chunks are
+// errors
+func TestInfiniteTilemap(t *testing.T) {
+	dir := t.TempDir()
+	load := func(name, chunks string) (*Tilemap, error) {
+		path := filepath.Join(dir, name+".tmx")
+		tmx := `<map orientation="orthogonal" width="4" height="4" tilewidth="32" tileheight="32" infinite="1">
+ <layer id="1" name="ground" width="4" height="4"><data encoding="csv">` + chunks + `</data></layer>
+</map>`
+		if err := os.WriteFile(path, []byte(tmx), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadTilemap(path)
+	}
+
+	tilemap, err := load("chunked", `
+  <chunk x="-2" y="0" width="2" height="2">1,2,3,4</chunk>
+  <chunk x="0" y="2" width="2" height="1">5,6</chunk>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tilemap.Infinite || tilemap.OriginX != -2 || tilemap.OriginY != 0 || tilemap.Width != 4 || tilemap.Height != 3 {
+		t.Errorf("flattened to %dx%d from %d,%d, infinite %v, want 4x3 from -2,0",
+			tilemap.Width, tilemap.Height, tilemap.OriginX, tilemap.OriginY, tilemap.Infinite)
+	}
+	want := []int{
+		1, 2, 0, 0,
+		3, 4, 0, 0,
+		0, 0, 5, 6,
+	}
+	if got := tilemap.Layers[0].Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("flattened tiles %v, want %v", got, want)
+	}
+
+	for name, chunks := range map[string]string{
+		"negative width": `<chunk x="0" y="0" width="-2" height="2">1,2,3,4</chunk>`,
+		"huge":           `<chunk x="0" y="0" width="1000000000" height="1000000000">1</chunk>`,
+		"far apart":      `<chunk x="0" y="0" width="1" height="1">1</chunk><chunk x="100000" y="0" width="1" height="1">1</chunk>`,
+		"far out":        `<chunk x="-9000000000000000000" y="0" width="1" height="1">1</chunk>`,
+		"short":          `<chunk x="0" y="0" width="2" height="2">1,2,3</chunk>`,
+	} {
+		if _, err := load(strings.ReplaceAll(name, " ", "_"), chunks); err == nil {
+			t.Errorf("%s chunk loaded", name)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TMX maps
+//
+// TMX is the XML format Tiled saves maps in. It holds the same things as its
+// JSON export under other names, so it is read as a plain tree of elements,
+// which keeps layers in their drawing order, and converted to a Tilemap.
+
+// tmxElement is an XML element with everything in it
+type tmxElement struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr   `xml:",any,attr"`
+	Text     string       `xml:",chardata"`
+	Children []tmxElement `xml:",any"`
+}
+
+func (e *tmxElement) attr(name string) string {
+	for _, attr := range e.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// intAttr returns an attribute that is a whole number, 0 if it's missing.
+// Some are written as decimals, like the sizes of objects.
+func (e *tmxElement) intAttr(name string) (int, error) {
+	value := e.attr(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("<%s %s=%q> is not a number", e.XMLName.Local, name, value)
+	}
+	return int(n), nil
+}
+
+func (e *tmxElement) floatAttr(name string) (float32, error) {
+	value := e.attr(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return 0, fmt.Errorf("<%s %s=%q> is not a number", e.XMLName.Local, name, value)
+	}
+	return float32(n), nil
+}
+
+// parseTMX reads a map from the content of a TMX file
+func parseTMX(raw []byte) (*Tilemap, error) {
+	var root tmxElement
+	if err := xml.Unmarshal(raw, &root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local != "map" {
+		return nil, fmt.Errorf("root element is <%s>, not <map>", root.XMLName.Local)
+	}
+	if orientation := root.attr("orientation"); orientation != "" && orientation != "orthogonal" {
+		return nil, fmt.Errorf("%s maps are not supported, only orthogonal ones", orientation)
+	}
+
+	tilemap := &Tilemap{Infinite: root.attr("infinite") == "1"}
+	var err error
+	for name, value := range map[string]*int{
+		"width": &tilemap.Width, "height": &tilemap.Height,
+		"tilewidth": &tilemap.TileWidth, "tileheight": &tilemap.TileHeight,
+	} {
+		if *value, err = root.intAttr(name); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range root.Children {
+		child := &root.Children[i]
+		switch child.XMLName.Local {
+		case "properties":
+			if tilemap.Properties, err = parseTMXProperties(child); err != nil {
+				return nil, err
+			}
+		case "tileset":
+			tileset, err := parseTMXTileset(child)
+			if err != nil {
+				return nil, err
+			}
+			tilemap.Tilesets = append(tilemap.Tilesets, tileset)
+		}
+	}
+	if tilemap.Layers, err = parseTMXLayers(&root); err != nil {
+		return nil, err
+	}
+	return tilemap, nil
+}
+
+// parseTMXTileset reads a <tileset>, keeping the tiles with properties
+func parseTMXTileset(element *tmxElement) (TilemapTileset, error) {
+	tileset := TilemapTileset{Name: element.attr("name"), Source: element.attr("source")}
+	var err error
+	if tileset.FirstGID, err = element.intAttr("firstgid"); err != nil {
+		return tileset, err
+	}
+	if tileset.TileCount, err = element.intAttr("tilecount"); err != nil {
+		return tileset, err
+	}
+	for i := range element.Children {
+		child := &element.Children[i]
+		if child.XMLName.Local == "image" {
+			tileset.Image = child.attr("source")
+		}
+		if child.XMLName.Local != "tile" {
+			continue
+		}
+		tile := TilesetTile{}
+		if tile.ID, err = child.intAttr("id"); err != nil {
+			return tileset, err
+		}
+		for j := range child.Children {
+			if child.Children[j].XMLName.Local == "properties" {
+				if tile.Properties, err = parseTMXProperties(&child.Children[j]); err != nil {
+					return tileset, fmt.Errorf("tileset %s: tile %d: %w", tileset.Name, tile.ID, err)
+				}
+			}
+		}
+		if len(tile.Properties) > 0 {
+			tileset.Tiles = append(tileset.Tiles, tile)
+		}
+	}
+	return tileset, nil
+}
+
+// parseTMXLayers reads the layers in a <map> or <group>, in order
+func parseTMXLayers(parent *tmxElement) ([]TilemapLayer, error) {
+	var layers []TilemapLayer
+	for i := range parent.Children {
+		element := &parent.Children[i]
+		layer := TilemapLayer{Name: element.attr("name")}
+		switch element.XMLName.Local {
+		case "layer":
+			layer.Type = "tilelayer"
+		case "objectgroup":
+			layer.Type = "objectgroup"
+		case "group":
+			layer.Type = "group"
+		case "imagelayer":
+			layer.Type = "imagelayer"
+		default:
+			continue
+		}
+
+		var err error
+		if layer.Width, err = element.intAttr("width"); err != nil {
+			return nil, err
+		}
+		if layer.Height, err = element.intAttr("height"); err != nil {
+			return nil, err
+		}
+		for j := range element.Children {
+			child := &element.Children[j]
+			switch child.XMLName.Local {
+			case "properties":
+				layer.Properties, err = parseTMXProperties(child)
+			case "data":
+				err = parseTMXData(child, &layer)
+			case "object":
+				var object TilemapObject
+				object, err = parseTMXObject(child)
+				layer.Objects = append(layer.Objects, object)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("layer %s: %w", layer.Name, err)
+			}
+		}
+		if layer.Type == "group" {
+			if layer.Layers, err = parseTMXLayers(element); err != nil {
+				return nil, err
+			}
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// parseTMXData reads the tiles of a layer, or its chunks in infinite maps
+func parseTMXData(element *tmxElement, layer *TilemapLayer) error {
+	encoding, compression := element.attr("encoding"), element.attr("compression")
+
+	// without an encoding the tiles are listed as <tile gid="..."/>
+	// elements, Tiled's oldest format
+	decode := func(element *tmxElement) ([]int, error) {
+		if encoding != "" {
+			return decodeTileData(element.Text, encoding, compression)
+		}
+		var data []int
+		for i := range element.Children {
+			if element.Children[i].XMLName.Local == "tile" {
+				gid, err := element.Children[i].intAttr("gid")
+				if err != nil {
+					return nil, err
+				}
+				data = append(data, gid)
+			}
+		}
+		return data, nil
+	}
+
+	var chunked bool
+	for i := range element.Children {
+		child := &element.Children[i]
+		if child.XMLName.Local != "chunk" {
+			continue
+		}
+		chunked = true
+		var chunk tileChunk
+		var err error
+		for name, value := range map[string]*int{"x": &chunk.X, "y": &chunk.Y, "width": &chunk.Width, "height": &chunk.Height} {
+			if *value, err = child.intAttr(name); err != nil {
+				return err
+			}
+		}
+		if chunk.Data, err = decode(child); err != nil {
+			return fmt.Errorf("chunk at %d,%d: %w", chunk.X, chunk.Y, err)
+		}
+		layer.chunks = append(layer.chunks, chunk)
+	}
+	if chunked {
+		return nil
+	}
+	var err error
+	layer.Data, err = decode(element)
+	return err
+}
+
+// parseTMXObject reads an <object>
+func parseTMXObject(element *tmxElement) (TilemapObject, error) {
+	object := TilemapObject{Name: element.attr("name"), Type: element.attr("type")}
+	if object.Type == "" {
+		object.Type = element.attr("class")
+	}
+	var err error
+	if object.ID, err = element.intAttr("id"); err != nil {
+		return object, err
+	}
+	for name, value := range map[string]*float32{"x": &object.X, "y": &object.Y, "width": &object.Width, "height": &object.Height} {
+		if *value, err = element.floatAttr(name); err != nil {
+			return object, err
+		}
+	}
+	for i := range element.Children {
+		child := &element.Children[i]
+		switch child.XMLName.Local {
+		case "point":
+			object.Point = true
+		case "ellipse":
+			object.Ellipse = true
+		case "properties":
+			if object.Properties, err = parseTMXProperties(child); err != nil {
+				return object, fmt.Errorf("object %d: %w", object.ID, err)
+			}
+		}
+	}
+	return object, nil
+}
+
+// parseTMXProperties reads <properties>, giving values the types they have
+// in Tiled JSON maps
+func parseTMXProperties(element *tmxElement) ([]TilemapProperty, error) {
+	var properties []TilemapProperty
+	for i := range element.Children {
+		child := &element.Children[i]
+		if child.XMLName.Local != "property" {
+			continue
+		}
+		property := TilemapProperty{Name: child.attr("name"), Type: child.attr("type")}
+		if property.Type == "" {
+			property.Type = "string"
+		}
+		text := child.attr("value")
+		if text == "" {
+			text = child.Text // multiline strings are the element's content
+		}
+
+		switch property.Type {
+		case "bool":
+			property.Value = text == "true"
+		case "int", "float", "object":
+			n, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %q is not a %s", property.Name, text, property.Type)
+			}
+			property.Value = n
+		case "class":
+			members := make(map[string]interface{})
+			for j := range child.Children {
+				if child.Children[j].XMLName.Local != "properties" {
+					continue
+				}
+				nested, err := parseTMXProperties(&child.Children[j])
+				if err != nil {
+					return nil, fmt.Errorf("property %s: %w", property.Name, err)
+				}
+				for _, member := range nested {
+					members[member.Name] = member.Value
+				}
+			}
+			property.Value = members
+		default:
+			property.Value = text
+		}
+		properties = append(properties, property)
+	}
+	return properties, nil
+}
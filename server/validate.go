@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// World validation
+//
+// `server validate -world world.json` checks the world file given with
+// -world and every map it refers to without starting the server, so a
+// renamed map or a broken spawn layer shows up before a deploy instead of as
+// a failed start or a zone without enemies. The flags can come before or
+// after the subcommand. It reads the maps from -tilemaps with the walls of
+// -collision-layers, as the server would, and checks:
+//
+//   - the world file, as the server does when it starts
+//   - that every tilemap ref has a map that loads and is the size of a zone
+//   - that every map has the collision layers, at the size of the map
+//   - the types of the custom properties the server reads
+//   - that the tilesets cover every tile used, and that their images and
+//     external tileset files exist
+//   - that enemy layers will spawn, see spawner.go
+//   - that portals lead somewhere players can stand, see portal.go
+//
+// It prints what it found in each file and exits with status 1 if there are
+// errors: anything that stops the server from starting or a zone from
+// working as drawn. Warnings are for what loads but likely isn't what was
+// meant.
+
+// layerPropertyTypes are the Tiled types of the layer properties the server
+// reads
+var layerPropertyTypes = map[string]string{
+	"collides":          "bool",
+	"isEnemyLayer":      "bool",
+	"enemyType":         "string",
+	"spawnChance":       "float",
+	"respawnInterval_s": "float",
+	"targetZone":        "int",
+	"targetTilemap":     "string",
+	"targetX":           "float",
+	"targetY":           "float",
+	"minLevel":          "int",
+}
+
+// validationFindings are the problems found in one file
+type validationFindings struct {
+	subject  string
+	errors   []string
+	warnings []string
+}
+
+func (f *validationFindings) errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *validationFindings) warnf(format string, args ...interface{}) {
+	f.warnings = append(f.warnings, fmt.Sprintf(format, args...))
+}
+
+// addErrors adds every error joined in err
+func (f *validationFindings) addErrors(err error) {
+	for _, err := range joinedErrors(err) {
+		f.errorf("%v", err)
+	}
+}
+
+// joinedErrors returns the errors joined in err by errors.Join, or just err
+func joinedErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, joinedErrors(err)...)
+	}
+	return errs
+}
+
+// validateWorld checks a world file and its tilemaps, printing a report to
+// out. Returns false if it found errors.
+func validateWorld(out io.Writer, worldFile string) bool {
+	world := &validationFindings{subject: worldFile}
+	report := []*validationFindings{world}
+	defer func() { printValidationReport(out, report) }()
+
+	definition, err := readWorldDefinition(worldFile)
+	if err == nil {
+		err = definition.Validate()
+	}
+	if err == nil {
+		err = InitializeWorld(definition)
+	}
+	if err != nil {
+		world.addErrors(err)
+		return false
+	}
+
+	// every map once, in the order the grid first uses them
+	zonesByRef := make(map[string]int)
+	var refs []string
+	for _, zoneConfig := range World.ZoneConfigs {
+		if IsEmptyTilemapGridName(zoneConfig.TilemapRef) {
+			continue
+		}
+		if zonesByRef[zoneConfig.TilemapRef] == 0 {
+			refs = append(refs, zoneConfig.TilemapRef)
+		}
+		zonesByRef[zoneConfig.TilemapRef]++
+	}
+	World.Tilemaps = make(map[string]*Tilemap)
+	findingsByRef := make(map[string]*validationFindings)
+	for _, ref := range refs {
+		zones := countOf(zonesByRef[ref], "zone")
+		findings := &validationFindings{subject: fmt.Sprintf("tilemap %s (%s)", ref, zones)}
+		report = append(report, findings)
+		findingsByRef[ref] = findings
+		path, err := tilemapPath(ref)
+		if err != nil {
+			findings.addErrors(err)
+			continue
+		}
+		findings.subject = fmt.Sprintf("tilemap %s (%s, %s)", ref, path, zones)
+		tilemap, err := LoadTilemap(path)
+		if err != nil {
+			findings.addErrors(err)
+			continue
+		}
+		validateTilemap(tilemap, path, findings)
+		if tilemap.checkSize() == nil {
+			World.Tilemaps[ref] = tilemap
+		}
+	}
+
+	// portals need every map loaded to check where they lead
+	for ref, tilemap := range World.Tilemaps {
+		validatePortals(tilemap, findingsByRef[ref])
+	}
+
+	// what the world file says that the maps make pointless
+	for i, zone := range definition.Zones {
+		tilemap := World.Tilemaps[definition.Grid[zone.Row][zone.Col]]
+		if tilemap == nil || len(enemyLayers(tilemap)) == 0 {
+			continue
+		}
+		if zone.Enemies != nil || len(zone.SpawnPoints) > 0 {
+			world.warnf("zones[%d] (row %d, col %d) sets enemies or spawnPoints, its tilemap's enemy layers spawn instead", i, zone.Row, zone.Col)
+		}
+	}
+	tile := tileAt(World.PlayerSpawnX, World.PlayerSpawnY)
+	if solidTile(tile.X, tile.Y) {
+		world.warnf("players spawn at %g,%g, on a wall", World.PlayerSpawnX, World.PlayerSpawnY)
+	}
+
+	for _, findings := range report {
+		if len(findings.errors) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validateTilemap checks a loaded map for what LoadTilemap lets through
+func validateTilemap(tilemap *Tilemap, path string, findings *validationFindings) {
+	if err := tilemap.checkSize(); err != nil {
+		findings.errorf("the map %v", err)
+	}
+
+	for _, name := range CollisionLayers {
+		found := false
+		tilemap.EachLayer(func(layer *TilemapLayer) {
+			found = found || layer.Type == "tilelayer" && layer.Name == name
+		})
+		if !found {
+			findings.errorf("no %s tile layer, the zone has none of its walls", name)
+		}
+	}
+
+	tilemap.EachLayer(func(layer *TilemapLayer) {
+		for _, property := range layer.Properties {
+			if want, read := layerPropertyTypes[property.Name]; read && !propertyHasType(property, want) {
+				findings.errorf("layer %s: %s is %#v of type %s, not of type %s", layer.Name, property.Name, property.Value, property.Type, want)
+			}
+		}
+		for _, object := range layer.Objects {
+			for _, property := range object.Properties {
+				if want, read := layerPropertyTypes[property.Name]; read && !propertyHasType(property, want) {
+					findings.errorf("layer %s: object %d: %s is %#v of type %s, not of type %s", layer.Name, object.ID, property.Name, property.Value, property.Type, want)
+				}
+			}
+		}
+		if layer.Type == "tilelayer" && (layer.Width != tilemap.Width || layer.Height != tilemap.Height) {
+			findings.errorf("layer %s is %dx%d tiles, the map is %dx%d, it is ignored", layer.Name, layer.Width, layer.Height, tilemap.Width, tilemap.Height)
+		}
+	})
+	for _, tileset := range tilemap.Tilesets {
+		for _, tile := range tileset.Tiles {
+			for _, property := range tile.Properties {
+				if property.Name == "collides" && !propertyHasType(property, "bool") {
+					findings.errorf("tileset %s: tile %d: collides is %#v of type %s, not of type bool", tileset.Name, tile.ID, property.Value, property.Type)
+				}
+			}
+		}
+	}
+
+	validateTilesets(tilemap, filepath.Dir(path), findings)
+	validateEnemyLayers(tilemap, findings)
+}
+
+// propertyHasType reports whether a property's value is of a Tiled type,
+// ints counting as floats
+func propertyHasType(property TilemapProperty, want string) bool {
+	switch want {
+	case "bool":
+		_, ok := property.Value.(bool)
+		return ok
+	case "int":
+		n, ok := property.Value.(float64)
+		return ok && n == math.Trunc(n) && property.Type != "object"
+	case "float":
+		_, ok := property.Value.(float64)
+		return ok && property.Type != "object"
+	default:
+		_, ok := property.Value.(string)
+		return ok && property.Type != "file" && property.Type != "color"
+	}
+}
+
+// validateTilesets checks that the files of the map's tilesets exist and
+// that every tile of its layers is in one of them
+func validateTilesets(tilemap *Tilemap, dir string, findings *validationFindings) {
+	tilesets := slices.Clone(tilemap.Tilesets)
+	slices.SortFunc(tilesets, func(a, b TilemapTileset) int { return a.FirstGID - b.FirstGID })
+	for i, tileset := range tilesets {
+		name := tileset.Name
+		if name == "" {
+			name = tileset.Source
+		}
+		if tileset.FirstGID < 1 {
+			findings.errorf("tileset %s: firstgid must be at least 1, got %d", name, tileset.FirstGID)
+		}
+		for _, file := range []string{tileset.Source, tileset.Image} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+				findings.errorf("tileset %s: %s not found next to the map", name, file)
+			}
+		}
+		if i+1 < len(tilesets) && tileset.TileCount > 0 && tileset.FirstGID+tileset.TileCount > tilesets[i+1].FirstGID {
+			findings.errorf("tileset %s: its %d tiles from %d overlap tileset %s from %d",
+				name, tileset.TileCount, tileset.FirstGID, tilesets[i+1].Name, tilesets[i+1].FirstGID)
+		}
+	}
+
+	// external tilesets don't say how many tiles they have, they are taken
+	// to reach the next tileset
+	inTileset := func(gid int) bool {
+		i := len(tilesets) - 1
+		for i >= 0 && tilesets[i].FirstGID > gid {
+			i--
+		}
+		return i >= 0 && (tilesets[i].TileCount == 0 || gid < tilesets[i].FirstGID+tilesets[i].TileCount)
+	}
+	tilemap.EachLayer(func(layer *TilemapLayer) {
+		unknown, example := 0, 0
+		for _, gid := range layer.Data {
+			if gid &= tileGIDMask; gid != 0 && !inTileset(gid) {
+				unknown, example = unknown+1, gid
+			}
+		}
+		if unknown > 0 {
+			findings.errorf("layer %s: %s in no tileset, like tile %d", layer.Name, countOf(unknown, "tile"), example)
+		}
+	})
+}
+
+// enemyLayers returns the layers of a map that spawn enemies
+func enemyLayers(tilemap *Tilemap) []*TilemapLayer {
+	var layers []*TilemapLayer
+	tilemap.EachLayer(func(layer *TilemapLayer) {
+		if layer.Type == "tilelayer" && layer.BoolProperty("isEnemyLayer") && checkEnemyLayer(layer) == nil {
+			layers = append(layers, layer)
+		}
+	})
+	return layers
+}
+
+// validateEnemyLayers checks the layers that spawn enemies, and the ones
+// that look like they were meant to
+func validateEnemyLayers(tilemap *Tilemap, findings *validationFindings) {
+	tilemap.EachLayer(func(layer *TilemapLayer) {
+		if !layer.BoolProperty("isEnemyLayer") {
+			for _, name := range []string{"enemyType", "spawnChance", "respawnInterval_s"} {
+				if _, set := layer.Property(name); set {
+					findings.warnf("layer %s has %s but isEnemyLayer isn't true, it spawns nothing", layer.Name, name)
+					break
+				}
+			}
+			return
+		}
+		if layer.Type != "tilelayer" {
+			findings.errorf("layer %s: only tile layers can be enemy layers, it is a %s", layer.Name, layer.Type)
+			return
+		}
+		if err := checkEnemyLayer(layer); err != nil {
+			var problems []string
+			for _, problem := range joinedErrors(err) {
+				problems = append(problems, problem.Error())
+			}
+			findings.errorf("enemy layer %s spawns nothing: %s", layer.Name, strings.Join(problems, ", "))
+			return
+		}
+
+		tiles := layer.OccupiedTiles()
+		if len(tiles) == 0 {
+			findings.warnf("enemy layer %s has no tiles, it spawns nothing", layer.Name)
+		}
+		if chance := layer.FloatProperty("spawnChance"); chance > 1 {
+			findings.warnf("enemy layer %s: spawnChance %g is over 1, every tile spawns", layer.Name, chance)
+		}
+		walls := 0
+		for _, tile := range tiles {
+			if tilemap.Collision[tile.Y*tilemap.Width+tile.X] {
+				walls++
+			}
+		}
+		if walls > 0 {
+			findings.warnf("enemy layer %s: %d of its %d tiles are walls, enemies spawned there are stuck", layer.Name, walls, len(tiles))
+		}
+	})
+}
+
+// validatePortals checks that the portals of a map lead somewhere players
+// can stand
+func validatePortals(tilemap *Tilemap, findings *validationFindings) {
+	drawn := make(map[*Portal]bool)
+	for _, portal := range tilemap.portalTiles {
+		drawn[portal] = true
+	}
+	for _, portal := range tilemap.Portals {
+		if portal.object == nil && !drawn[portal] {
+			findings.warnf("portal layer %s has no tiles, no one can step on it", portal.Name)
+		}
+		if _, _, _, err := portal.destination(); err != nil {
+			for _, problem := range joinedErrors(err) {
+				findings.errorf("portal %s goes nowhere: %v", portal.Name, problem)
+			}
+		}
+	}
+}
+
+// printValidationReport prints the findings of every file and a total
+func printValidationReport(out io.Writer, report []*validationFindings) {
+	errorCount, warningCount := 0, 0
+	for _, findings := range report {
+		if len(findings.errors)+len(findings.warnings) == 0 {
+			fmt.Fprintf(out, "%s: ok\n", findings.subject)
+			continue
+		}
+		fmt.Fprintf(out, "%s:\n", findings.subject)
+		for _, problem := range findings.errors {
+			fmt.Fprintf(out, "  error: %s\n", problem)
+		}
+		for _, problem := range findings.warnings {
+			fmt.Fprintf(out, "  warning: %s\n", problem)
+		}
+		errorCount += len(findings.errors)
+		warningCount += len(findings.warnings)
+	}
+	fmt.Fprintf(out, "%s, %s\n", countOf(errorCount, "error"), countOf(warningCount, "warning"))
+}
+
+// countOf returns how many of a thing there are, as in "1 zone" or "2 zones"
+func countOf(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return fmt.Sprintf("%d %ss", n, thing)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validationFixture writes a world file of 4x4 tile zones using the given
+// refs, and a map for each entry of tilemaps, into a directory that is
+// TilemapDir for the test. Returns the world file's path.
+func validationFixture(t *testing.T, grid [][]string, tilemaps map[string]map[string]interface{}) string {
+	t.Helper()
+	keepWorld(t)
+	dir := t.TempDir()
+	savedDir := TilemapDir
+	TilemapDir = dir
+	t.Cleanup(func() { TilemapDir = savedDir })
+
+	write := func(name string, v interface{}) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	for ref, tilemap := range tilemaps {
+		write(ref+".json", tilemap)
+	}
+	return write("world.json", WorldDefinition{TileSize: 32, ZoneSize: 4, DifficultyTier: 1, Grid: grid})
+}
+
+// validationTilemap is a walled 4x4 map with one tileset of 4 tiles, plus
+// the given layers
+func validationTilemap(layers ...map[string]interface{}) map[string]interface{} {
+	border := []int{1, 1, 1, 1, 1, 0, 0, 1, 1, 0, 0, 1, 1, 1, 1, 1}
+	return map[string]interface{}{
+		"width": 4, "height": 4, "tilewidth": 32, "tileheight": 32,
+		"tilesets": []interface{}{map[string]interface{}{"firstgid": 1, "name": "tiles", "tilecount": 4}},
+		"layers": append([]map[string]interface{}{
+			{"name": "border", "type": "tilelayer", "width": 4, "height": 4, "data": border},
+		}, layers...),
+	}
+}
+
+// TestValidateWorld checks what the validate command reports for a world
+// and its maps
+func TestValidateWorld(t *testing.T) {
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logs)
+
+	enemies := func(spawnChance interface{}, data []int) map[string]interface{} {
+		return map[string]interface{}{
+			"name": "enemies", "type": "tilelayer", "width": 4, "height": 4, "data": data,
+			"properties": []interface{}{
+				map[string]interface{}{"name": "isEnemyLayer", "type": "bool", "value": true},
+				map[string]interface{}{"name": "enemyType", "type": "string", "value": "easy"},
+				map[string]interface{}{"name": "spawnChance", "type": "float", "value": spawnChance},
+				map[string]interface{}{"name": "respawnInterval_s", "type": "float", "value": 30},
+			},
+		}
+	}
+	inside := []int{0, 0, 0, 0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name     string
+		grid     [][]string
+		tilemaps map[string]map[string]interface{}
+		want     []string // in the report
+		ok       bool
+	}{
+		{
+			name:     "valid",
+			grid:     [][]string{{"a", "a"}},
+			tilemaps: map[string]map[string]interface{}{"a": validationTilemap(enemies(0.5, inside))},
+			want:     []string{"tilemap a (", "2 zones): ok", "0 errors, 0 warnings"},
+			ok:       true,
+		},
+		{
+			name:     "missing map",
+			grid:     [][]string{{"a", "gone"}},
+			tilemaps: map[string]map[string]interface{}{"a": validationTilemap()},
+			want:     []string{"tilemap gone (1 zone):", "error: no tilemap gone.json or gone.tmx"},
+		},
+		{
+			name:     "property type",
+			grid:     [][]string{{"a"}},
+			tilemaps: map[string]map[string]interface{}{"a": validationTilemap(enemies("often", inside))},
+			want:     []string{`error: layer enemies: spawnChance is "often" of type float, not of type float`, "enemy layer enemies spawns nothing: spawnChance must be a positive number"},
+		},
+		{
+			name:     "unknown tile",
+			grid:     [][]string{{"a"}},
+			tilemaps: map[string]map[string]interface{}{"a": validationTilemap(enemies(0.5, []int{0, 0, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))},
+			want:     []string{"error: layer enemies: 1 tile in no tileset, like tile 9"},
+		},
+		{
+			name: "portal onto a wall",
+			grid: [][]string{{"a"}},
+			tilemaps: map[string]map[string]interface{}{"a": validationTilemap(map[string]interface{}{
+				"name": "portal", "type": "tilelayer", "width": 4, "height": 4, "data": inside,
+				"properties": []interface{}{
+					map[string]interface{}{"name": "targetZone", "type": "int", "value": 1},
+					map[string]interface{}{"name": "targetX", "type": "float", "value": 16},
+					map[string]interface{}{"name": "targetY", "type": "float", "value": 16},
+				},
+			})},
+			want: []string{"error: portal portal goes nowhere: target 16,16 is on a wall"},
+		},
+		{
+			name:     "warnings only",
+			grid:     [][]string{{"a"}},
+			tilemaps: map[string]map[string]interface{}{"a": validationTilemap(enemies(0.5, []int{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))},
+			want:     []string{"warning: enemy layer enemies: 1 of its 2 tiles are walls", "0 errors, 1 warning"},
+			ok:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			worldFile := validationFixture(t, test.grid, test.tilemaps)
+			var out bytes.Buffer
+			ok := validateWorld(&out, worldFile)
+			if ok != test.ok {
+				t.Errorf("validateWorld returned %v, want %v", ok, test.ok)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("report lacks %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
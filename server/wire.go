@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Wire formats
+//
+// Clients pick an encoding through Sec-WebSocket-Protocol. A client offering
+// SubprotocolMsgpack gets every server frame as a binary MessagePack document
+// and may send binary MessagePack frames itself; anything else (including
+// offering no subprotocol at all) gets the original JSON text frames. Both
+// formats carry the same documents, field names included. The Phaser client
+// offers both and decodes MessagePack frames with client/src/phaser/Msgpack.ts,
+// it always sends JSON text frames.
+const (
+	SubprotocolMsgpack = "mmorpg.msgpack.v1"
+	SubprotocolJSON    = "mmorpg.json.v1"
+)
+
+// supportedSubprotocols is in order of preference
+var supportedSubprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+
+// WireCodec encodes outbound batches for one wire format
+type WireCodec interface {
+	Name() string
+	// FrameType is the websocket message type frames are written as
+	FrameType() int
+	EncodeBatch(batch []Message) ([]byte, error)
+	// AppendBatch appends a batch of the messages followed by the already
+	// encoded ones, see ZoneSnapshot.encoded
+	AppendBatch(buf []byte, batch []Message, encoded [][]byte) ([]byte, error)
+	// AppendMessages appends the messages back to back as they would appear
+	// in a batch, returning the offset in buf where each one ends
+	AppendMessages(buf []byte, messages []Message) ([]byte, []int, error)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string   { return "json" }
+func (jsonCodec) FrameType() int { return websocket.TextMessage }
+
+func (c jsonCodec) EncodeBatch(batch []Message) ([]byte, error) {
+	return c.AppendBatch(nil, batch, nil)
+}
+
+func (jsonCodec) AppendBatch(buf []byte, batch []Message, encoded [][]byte) ([]byte, error) {
+	// an Encoder writes straight into buf where Marshal would copy
+	out := bytes.NewBuffer(buf)
+	encoder := json.NewEncoder(out)
+	out.WriteByte('[')
+	for i := range batch {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		if err := encodeJSONMessage(out, encoder, &batch[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i, chunk := range encoded {
+		if i > 0 || len(batch) > 0 {
+			out.WriteByte(',')
+		}
+		out.Write(chunk)
+	}
+	out.WriteByte(']')
+	return out.Bytes(), nil
+}
+
+func (jsonCodec) AppendMessages(buf []byte, messages []Message) ([]byte, []int, error) {
+	out := bytes.NewBuffer(buf)
+	encoder := json.NewEncoder(out)
+	ends := make([]int, 0, len(messages))
+	for i := range messages {
+		if err := encodeJSONMessage(out, encoder, &messages[i]); err != nil {
+			return nil, nil, err
+		}
+		ends = append(ends, out.Len())
+	}
+	return out.Bytes(), ends, nil
+}
+
+// encodeJSONMessage takes a pointer, boxing a Message would allocate
+func encodeJSONMessage(out *bytes.Buffer, encoder *json.Encoder, msg *Message) error {
+	if err := encoder.Encode(msg); err != nil {
+		return fmt.Errorf("encoding %s: %w", msg.Type, err)
+	}
+	out.Truncate(out.Len() - 1) // Encode ends with a newline
+	return nil
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string   { return "msgpack" }
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (c msgpackCodec) EncodeBatch(batch []Message) ([]byte, error) {
+	return c.AppendBatch(make([]byte, 0, 256), batch, nil)
+}
+
+func (msgpackCodec) AppendBatch(buf []byte, batch []Message, encoded [][]byte) ([]byte, error) {
+	buf = appendMsgpackArrayHeader(buf, len(batch)+len(encoded))
+	for _, msg := range batch {
+		var err error
+		if buf, err = appendMsgpackMessage(buf, msg); err != nil {
+			return nil, err
+		}
+	}
+	for _, chunk := range encoded {
+		buf = append(buf, chunk...)
+	}
+	return buf, nil
+}
+
+func (msgpackCodec) AppendMessages(buf []byte, messages []Message) ([]byte, []int, error) {
+	ends := make([]int, 0, len(messages))
+	for _, msg := range messages {
+		var err error
+		if buf, err = appendMsgpackMessage(buf, msg); err != nil {
+			return nil, nil, err
+		}
+		ends = append(ends, len(buf))
+	}
+	return buf, ends, nil
+}
+
+func appendMsgpackMessage(buf []byte, msg Message) ([]byte, error) {
+	// Message is encoded by hand, its routing fields never leave the server
+	buf = appendMsgpackMapHeader(buf, 2)
+	buf = appendMsgpackString(buf, "type")
+	buf = appendMsgpackString(buf, msg.Type)
+	buf = appendMsgpackString(buf, "data")
+	buf, err := appendMsgpack(buf, msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", msg.Type, err)
+	}
+	return buf, nil
+}
+
+// Outbound frames are assembled in pooled buffers, handed back once written
+var framePool = sync.Pool{New: func() any {
+	buf := make([]byte, 0, 4096)
+	return &buf
+}}
+
+// maxPooledFrame keeps the odd huge frame, like a welcome, from pinning its
+// buffer in the pool
+const maxPooledFrame = 256 * 1024
+
+func getFrameBuffer() []byte {
+	return (*framePool.Get().(*[]byte))[:0]
+}
+
+func putFrameBuffer(buf []byte) {
+	if cap(buf) > maxPooledFrame {
+		return
+	}
+	framePool.Put(&buf)
+}
+
+// codecForSubprotocol returns the codec for a negotiated subprotocol
+func codecForSubprotocol(subprotocol string) WireCodec {
+	if subprotocol == SubprotocolMsgpack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+// decodeClientFrame decodes one frame read from a client. Text frames are
+// always JSON; binary frames are MessagePack and only accepted from clients
+// that negotiated it.
+func decodeClientFrame(codec WireCodec, frameType int, raw []byte) (Message, *ProtocolError) {
+	if frameType != websocket.BinaryMessage {
+		return decodeClientMessage(raw)
+	}
+	if _, ok := codec.(msgpackCodec); !ok {
+		return Message{}, &ProtocolError{Code: ErrCodeMalformedMessage, Reason: "binary frames require the " + SubprotocolMsgpack + " subprotocol"}
+	}
+
+	// re-encode as JSON so both formats go through the same strict decoding
+	value, err := decodeMsgpack(raw)
+	if err != nil {
+		return Message{}, &ProtocolError{Code: ErrCodeMalformedMessage, Reason: err.Error()}
+	}
+	asJSON, err := json.Marshal(value)
+	if err != nil {
+		return Message{}, &ProtocolError{Code: ErrCodeMalformedMessage, Reason: err.Error()}
+	}
+	return decodeClientMessage(asJSON)
+}
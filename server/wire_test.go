@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+// BenchmarkWireEncode compares the wire formats, see benchmarkWireEncode
+func BenchmarkWireEncode(b *testing.B) {
+	b.Run("json", func(b *testing.B) { benchmarkWireEncode(b, jsonCodec{}) })
+	b.Run("msgpack", func(b *testing.B) { benchmarkWireEncode(b, msgpackCodec{}) })
+}
+
+// benchmarkWireEncode measures encoding one player's tick with a codec,
+// reporting the frame size as bytes/tick
+func benchmarkWireEncode(b *testing.B, codec WireCodec) {
+	batch := benchmarkTickBatch()
+	payload, err := codec.EncodeBatch(batch)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.EncodeBatch(batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(payload)), "bytes/tick")
+}
@@ -2,7 +2,7 @@
 package main
 
 import (
-	// "fmt"
+	"fmt"
 	"log"
 	// "strconv"
 )
@@ -21,6 +21,11 @@ type ZoneConfig struct {
 	GridY      int
 	WorldX     float32
 	WorldY     float32
+
+	// enemy population, the world defaults unless the zone overrides them
+	Enemies        int
+	DifficultyTier int
+	SpawnPoints    []SpawnPoint
 }
 
 // WorldConfig holds the global world configuration
@@ -31,42 +36,48 @@ type WorldConfig struct {
 	ZoneGrid    [][]int      // 2D array representing the logical layout, 0 for no zone
 	TileSize    int          // Size of each tile in pixels (32)
 	ZoneSize    int          // Number of tiles per zone side (256)
+
+	// where new players appear, in world pixels
+	PlayerSpawnX float32
+	PlayerSpawnY float32
+
+	Tilemaps map[string]*Tilemap // by tilemap ref, see LoadTilemaps
 }
 
-// IMPORTANT. zoneId 0 is reserved for a NULL/void zone
-var World = WorldConfig{
-	// this is a string 2d array of all the tilemap "keys" we will be
-	// using on the client side for the tilemap
-	// for logic code on the server side we will append the ".json" and parse
-	TilemapGrid: [][]string{
-		{"", "default", "default", "default"},
-		{"default", "yield_fields_1", "default", "default"},
-		{"default", "default", "default", "default"},
-		{"default", "default", "default", "default"},
-	},
-
-	// ZoneConfigs contains all the unique ZoneID
-	ZoneConfigs: []ZoneConfig{},
-	ZoneGrid:    [][]int{},
-
-	TileSize: 32,
-	ZoneSize: 256,
+// IMPORTANT. zoneId 0 is reserved for a NULL/void zone. World is empty until
+// InitializeWorld loads a world definition, see world.json
+var World WorldConfig
+
+// emptyTilemapGridNames are the tilemap refs that mean there is no zone
+var emptyTilemapGridNames = map[string]struct{}{
+	"":      {},
+	"empty": {},
+	"null":  {},
+	"nil":   {},
+	"void":  {},
 }
 
 func IsEmptyTilemapGridName(tilemapGridName string) bool {
-	emptyValues := map[string]struct{}{
-		"":      {},
-		"empty": {},
-		"null":  {},
-		"nil":   {},
-		"void":  {},
-	}
-	_, exists := emptyValues[tilemapGridName]
+	_, exists := emptyTilemapGridNames[tilemapGridName]
 	return exists
 }
 
-// InitializeWorld sets up the world configuration and calculates grid positions and neighbors
-func InitializeWorld() {
+// InitializeWorld sets up the world configuration from a definition and
+// calculates grid positions and neighbors
+func InitializeWorld(definition WorldDefinition) error {
+	if err := definition.Validate(); err != nil {
+		return err
+	}
+
+	World = WorldConfig{
+		TilemapGrid: definition.Grid,
+		TileSize:    definition.TileSize,
+		ZoneSize:    definition.ZoneSize,
+	}
+	TileSize = definition.TileSize
+	ZoneWidthPixels = definition.ZoneSize * definition.TileSize
+	ZoneHeightPixels = ZoneWidthPixels
+	NumEnemiesPerZone = definition.EnemiesPerZone
 
 	// determine max columns
 	maxCols := 0
@@ -86,6 +97,11 @@ func InitializeWorld() {
 		}
 	}
 
+	overrides := make(map[[2]int]ZoneOverrides, len(definition.Zones))
+	for _, zone := range definition.Zones {
+		overrides[[2]int{zone.Row, zone.Col}] = zone
+	}
+
 	// Create zone configs using tilemap refs and the tilemap grid
 	for i, row := range World.TilemapGrid {
 		for j, tilemapRef := range row {
@@ -93,14 +109,26 @@ func InitializeWorld() {
 			zoneID := (i * maxCols) + j + 1 // Adding 1 to avoid ID 0
 
 			// make a new zone config
-			World.ZoneConfigs = append(World.ZoneConfigs, ZoneConfig{
-				ID:         zoneID,
-				TilemapRef: tilemapRef,
-				GridX:      j,
-				GridY:      i,
-				WorldX:     float32(j * ZoneWidthPixels),
-				WorldY:     float32(i * ZoneWidthPixels),
-			})
+			zoneConfig := ZoneConfig{
+				ID:             zoneID,
+				TilemapRef:     tilemapRef,
+				GridX:          j,
+				GridY:          i,
+				WorldX:         float32(j * ZoneWidthPixels),
+				WorldY:         float32(i * ZoneHeightPixels),
+				Enemies:        definition.EnemiesPerZone,
+				DifficultyTier: definition.DifficultyTier,
+			}
+			if override, exists := overrides[[2]int{i, j}]; exists {
+				if override.Enemies != nil {
+					zoneConfig.Enemies = *override.Enemies
+				}
+				if override.DifficultyTier != 0 {
+					zoneConfig.DifficultyTier = override.DifficultyTier
+				}
+				zoneConfig.SpawnPoints = override.SpawnPoints
+			}
+			World.ZoneConfigs = append(World.ZoneConfigs, zoneConfig)
 
 			// assign this zone id to the corresponding zone grid
 			World.ZoneGrid[i][j] = zoneID
@@ -109,6 +137,19 @@ func InitializeWorld() {
 		}
 	}
 
+	// players spawn in the middle of the first zone unless told otherwise
+	if definition.PlayerSpawn != nil {
+		World.PlayerSpawnX, World.PlayerSpawnY = definition.PlayerSpawn.X, definition.PlayerSpawn.Y
+	} else {
+		for _, zoneConfig := range World.ZoneConfigs {
+			if !IsEmptyTilemapGridName(zoneConfig.TilemapRef) {
+				World.PlayerSpawnX = zoneConfig.WorldX + float32(ZoneWidthPixels)/2
+				World.PlayerSpawnY = zoneConfig.WorldY + float32(ZoneHeightPixels)/2
+				break
+			}
+		}
+	}
+
 	// Automatically calculate neighbors for each zone
 	for i := range World.ZoneConfigs {
 		zoneConfig := &World.ZoneConfigs[i]
@@ -159,7 +200,7 @@ func InitializeWorld() {
 				}
 			}
 			if !found {
-				log.Fatalf("Zone ID %d at position [%d][%d] not found in Zones", zoneID, i, j)
+				return fmt.Errorf("zone ID %d at position [%d][%d] not found in zones", zoneID, i, j)
 			}
 		}
 	}
@@ -176,10 +217,11 @@ func InitializeWorld() {
 					}
 				}
 				if !found {
-					log.Fatalf("Neighbor ID %d for zone %d not found in Zones", neighborID, zone.ID)
+					return fmt.Errorf("neighbor ID %d for zone %d not found in zones", neighborID, zone.ID)
 				}
 			}
 		}
 	}
 	log.Println("World configuration initialized with", len(World.ZoneConfigs), "zones")
+	return nil
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// World definition files
+//
+// The layout of the world lives in a JSON file given with -world, world.json
+// by default, so maps can change without a rebuild:
+//
+//	{
+//	  "tileSize": 32,          // pixels per tile
+//	  "zoneSize": 256,         // tiles per zone side
+//	  "enemiesPerZone": 100,   // default enemy density
+//	  "difficultyTier": 3,     // default tier, see EnemyTiers
+//	  "playerSpawn": {"x": 12288, "y": 12288},
+//	  "grid": [["", "default"], ["default", "yield_fields_1"]],
+//	  "zones": [
+//	    {"row": 1, "col": 1, "enemies": 20, "difficultyTier": 1,
+//	     "spawnPoints": [{"x": 1024, "y": 2048, "radius": 256}]}
+//	  ]
+//	}
+//
+// The grid holds a tilemap ref per zone, row by row, with an empty ref
+// (or "empty", "null", "nil", "void") where there is no zone. Entries of
+// zones override the defaults for the zone at a grid position; spawn points
+// are in pixels from the zone's top left corner, and enemies spawn anywhere
+// in the zone outside its central safe area when there are none. Zones whose
+// tilemap has enemy layers spawn from those instead, see spawner.go.
+
+// WorldDefinition is the content of a world file
+type WorldDefinition struct {
+	TileSize       int             `json:"tileSize"`
+	ZoneSize       int             `json:"zoneSize"`
+	EnemiesPerZone int             `json:"enemiesPerZone"`
+	DifficultyTier int             `json:"difficultyTier"`
+	PlayerSpawn    *WorldPoint     `json:"playerSpawn,omitempty"` // centre of the first zone if unset
+	Grid           [][]string      `json:"grid"`
+	Zones          []ZoneOverrides `json:"zones,omitempty"`
+}
+
+// WorldPoint is a position in world pixels
+type WorldPoint struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+// ZoneOverrides changes the defaults for the zone at Row, Col of the grid.
+// Unset fields keep the world's defaults.
+type ZoneOverrides struct {
+	Row            int          `json:"row"`
+	Col            int          `json:"col"`
+	Enemies        *int         `json:"enemies,omitempty"`
+	DifficultyTier int          `json:"difficultyTier,omitempty"`
+	SpawnPoints    []SpawnPoint `json:"spawnPoints,omitempty"`
+}
+
+// SpawnPoint is a spot enemies spawn around, within Radius pixels
+type SpawnPoint struct {
+	X      float32 `json:"x"`
+	Y      float32 `json:"y"`
+	Radius float32 `json:"radius"`
+}
+
+// LoadWorldDefinition reads and validates a world file
+func LoadWorldDefinition(path string) (WorldDefinition, error) {
+	definition, err := readWorldDefinition(path)
+	if err != nil {
+		return definition, err
+	}
+	if err := definition.Validate(); err != nil {
+		return definition, fmt.Errorf("world file %s: %w", path, err)
+	}
+	return definition, nil
+}
+
+// readWorldDefinition reads a world file without validating it
+func readWorldDefinition(path string) (WorldDefinition, error) {
+	var definition WorldDefinition
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return definition, fmt.Errorf("reading world file: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&definition); err != nil {
+		return definition, fmt.Errorf("world file %s: %w", path, err)
+	}
+	return definition, nil
+}
+
+// Validate reports every problem with the definition at once
+func (d *WorldDefinition) Validate() error {
+	var problems []error
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if d.TileSize <= 0 {
+		fail("tileSize must be positive, got %d", d.TileSize)
+	}
+	if d.ZoneSize <= 0 {
+		fail("zoneSize must be positive, got %d", d.ZoneSize)
+	}
+	if d.EnemiesPerZone < 0 {
+		fail("enemiesPerZone can't be negative, got %d", d.EnemiesPerZone)
+	}
+	if d.DifficultyTier < 1 || d.DifficultyTier > len(EnemyTiers) {
+		fail("difficultyTier must be 1 to %d, got %d", len(EnemyTiers), d.DifficultyTier)
+	}
+
+	zones := 0
+	if len(d.Grid) == 0 {
+		fail("grid has no rows")
+	}
+	for row, refs := range d.Grid {
+		if len(refs) == 0 {
+			fail("grid row %d is empty", row)
+		}
+		for _, ref := range refs {
+			if !IsEmptyTilemapGridName(ref) {
+				zones++
+			}
+		}
+	}
+	if len(d.Grid) > 0 && zones == 0 {
+		fail("grid has no zones, every tilemap ref is empty")
+	}
+
+	zonePixels := float32(d.ZoneSize * d.TileSize)
+	overridden := make(map[[2]int]bool)
+	for i, zone := range d.Zones {
+		at := fmt.Sprintf("zones[%d] (row %d, col %d)", i, zone.Row, zone.Col)
+		if zone.Row < 0 || zone.Row >= len(d.Grid) || zone.Col < 0 || zone.Col >= len(d.Grid[zone.Row]) {
+			fail("%s is outside the grid", at)
+			continue
+		}
+		if IsEmptyTilemapGridName(d.Grid[zone.Row][zone.Col]) {
+			fail("%s overrides a position with no zone", at)
+		}
+		if overridden[[2]int{zone.Row, zone.Col}] {
+			fail("%s overrides the same zone as an earlier entry", at)
+		}
+		overridden[[2]int{zone.Row, zone.Col}] = true
+
+		if zone.Enemies != nil && *zone.Enemies < 0 {
+			fail("%s: enemies can't be negative, got %d", at, *zone.Enemies)
+		}
+		if zone.DifficultyTier != 0 && (zone.DifficultyTier < 1 || zone.DifficultyTier > len(EnemyTiers)) {
+			fail("%s: difficultyTier must be 1 to %d, got %d", at, len(EnemyTiers), zone.DifficultyTier)
+		}
+		for j, point := range zone.SpawnPoints {
+			if point.X < 0 || point.Y < 0 || point.X > zonePixels || point.Y > zonePixels {
+				fail("%s: spawnPoints[%d] at %g,%g is outside the zone's %gx%g pixels", at, j, point.X, point.Y, zonePixels, zonePixels)
+			}
+			if point.Radius < 0 {
+				fail("%s: spawnPoints[%d] radius can't be negative, got %g", at, j, point.Radius)
+			}
+		}
+	}
+
+	if d.PlayerSpawn != nil && zonePixels > 0 {
+		row := int(d.PlayerSpawn.Y / zonePixels)
+		col := int(d.PlayerSpawn.X / zonePixels)
+		if d.PlayerSpawn.X < 0 || d.PlayerSpawn.Y < 0 || row >= len(d.Grid) || col >= len(d.Grid[row]) || IsEmptyTilemapGridName(d.Grid[row][col]) {
+			fail("playerSpawn at %g,%g is not inside a zone", d.PlayerSpawn.X, d.PlayerSpawn.Y)
+		}
+	}
+
+	return errors.Join(problems...)
+}
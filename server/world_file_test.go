@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWorldDefinitionValidate checks that each kind of mistake in a world
+// file is reported
+func TestWorldDefinitionValidate(t *testing.T) {
+	valid := func() WorldDefinition {
+		return WorldDefinition{TileSize: 32, ZoneSize: 8, DifficultyTier: 1, Grid: [][]string{{"", "a"}, {"b", "c"}}}
+	}
+	negative := -1
+
+	tests := []struct {
+		name   string
+		change func(d *WorldDefinition)
+		want   string // in the error, none if empty
+	}{
+		{"valid", func(d *WorldDefinition) {}, ""},
+		{"no rows", func(d *WorldDefinition) { d.Grid = nil }, "grid has no rows"},
+		{"empty row", func(d *WorldDefinition) { d.Grid = append(d.Grid, []string{}) }, "grid row 2 is empty"},
+		{"no zones", func(d *WorldDefinition) { d.Grid = [][]string{{"", "void"}} }, "grid has no zones"},
+		{"tile size", func(d *WorldDefinition) { d.TileSize = 0 }, "tileSize must be positive"},
+		{"tier", func(d *WorldDefinition) { d.DifficultyTier = len(EnemyTiers) + 1 }, "difficultyTier must be 1"},
+		{"override outside", func(d *WorldDefinition) { d.Zones = []ZoneOverrides{{Row: 2, Col: 0}} }, "is outside the grid"},
+		{"override empty", func(d *WorldDefinition) { d.Zones = []ZoneOverrides{{Row: 0, Col: 0}} }, "overrides a position with no zone"},
+		{"override twice", func(d *WorldDefinition) { d.Zones = []ZoneOverrides{{Row: 0, Col: 1}, {Row: 0, Col: 1}} }, "same zone as an earlier entry"},
+		{"override enemies", func(d *WorldDefinition) { d.Zones = []ZoneOverrides{{Row: 1, Col: 1, Enemies: &negative}} }, "enemies can't be negative"},
+		{"spawn point", func(d *WorldDefinition) {
+			d.Zones = []ZoneOverrides{{Row: 1, Col: 0, SpawnPoints: []SpawnPoint{{X: 300, Y: 10}}}}
+		}, "spawnPoints[0] at 300,10 is outside the zone"},
+		{"player spawn", func(d *WorldDefinition) { d.PlayerSpawn = &WorldPoint{X: 10, Y: 10} }, "playerSpawn at 10,10 is not inside a zone"},
+	}
+	for _, test := range tests {
+		definition := valid()
+		test.change(&definition)
+		err := definition.Validate()
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+		}
+	}
+
+	// every problem at once
+	definition := valid()
+	definition.TileSize, definition.ZoneSize = 0, 0
+	if err := definition.Validate(); err == nil || strings.Count(err.Error(), "must be positive") != 2 {
+		t.Errorf("two bad sizes reported as %v", err)
+	}
+}
+
+// TestReadWorldDefinitionStrict checks that misspelt keys in a world file
+// are errors rather than silently ignored
+func TestReadWorldDefinitionStrict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "world.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"tileSize": 32, "zoneSize": 8, "difficultyTier": 1, "grid": [["a"]], "zones": [{"row": 0, "col": 0, "enemies": 3}]}`)
+	definition, err := LoadWorldDefinition(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overrides := definition.Zones; len(overrides) != 1 || overrides[0].Enemies == nil || *overrides[0].Enemies != 3 {
+		t.Errorf("zone overrides read as %+v", overrides)
+	}
+
+	write(`{"tileSize": 32, "zoneSize": 8, "difficultyTier": 1, "grid": [["a"]], "zones": [{"row": 0, "col": 0, "enemy": 3}]}`)
+	if _, err := readWorldDefinition(path); err == nil || !strings.Contains(err.Error(), `unknown field "enemy"`) {
+		t.Errorf("misspelt override read with %v", err)
+	}
+	write(`{"tileSize": 32, "grid": [["a"]]`)
+	if _, err := readWorldDefinition(path); err == nil {
+		t.Error("truncated world file read")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// Zone spatial indexes
+//
+// Next to its Players and Enemies maps every zone keeps the same entities in
+// spatial hashes, so enemy perception and ability hit tests only look at the
+// cells around them instead of the whole zone. The maps stay the record of
+// what the zone owns; addPlayer, removePlayer, addEnemy and removeEnemy keep
+// both in step, and the zone worker moves an entity in its hash as soon as
+// it has updated the entity. Only the zone's worker touches them.
+
+// ZoneCellSize is the cell size of the zone spatial indexes, about the
+// distance enemies notice players from
+const ZoneCellSize float32 = 256
+
+// MaxColliderReach is how far past its indexed position, the feet, an
+// entity's collider can reach. Hit tests are against a collider centred half
+// a sprite up and at most 0.6 sprites wide, the tallest sprite is 64 pixels.
+const MaxColliderReach float32 = 1.1 * 64
+
+func (z *Zone) addPlayer(player *Player) {
+	z.Players[player.ID] = player
+	z.PlayerGrid.Insert(player, player.X, player.Y)
+}
+
+func (z *Zone) removePlayer(playerID string) {
+	if player, exists := z.Players[playerID]; exists {
+		delete(z.Players, playerID)
+		z.PlayerGrid.Remove(player)
+	}
+}
+
+func (z *Zone) addEnemy(enemy *Enemy) {
+	z.Enemies[enemy.ID] = enemy
+	z.EnemyGrid.Insert(enemy, enemy.X, enemy.Y)
+}
+
+func (z *Zone) removeEnemy(enemyID string) {
+	if enemy, exists := z.Enemies[enemyID]; exists {
+		delete(z.Enemies, enemyID)
+		z.EnemyGrid.Remove(enemy)
+	}
+}
+
+// playerMoved brings the index up to date with a player the worker has just
+// updated. Players handed to another zone meanwhile are left alone.
+func (z *Zone) playerMoved(player *Player) {
+	if z.Players[player.ID] == player {
+		z.PlayerGrid.Move(player, player.X, player.Y)
+	}
+}
+
+func (z *Zone) enemyMoved(enemy *Enemy) {
+	z.EnemyGrid.Move(enemy, enemy.X, enemy.Y)
+}
+
+// playersWithin calls visit for every player in the zone within radius of
+// x, y, passing its squared distance
+func (z *Zone) playersWithin(x, y, radius float32, visit func(player *Player, distanceSquared float32)) {
+	z.PlayerGrid.QueryRadius(x, y, radius, visit)
+}
+
+// enemiesWithin calls visit for every enemy in the zone within radius of
+// x, y, passing its squared distance
+func (z *Zone) enemiesWithin(x, y, radius float32, visit func(enemy *Enemy, distanceSquared float32)) {
+	z.EnemyGrid.QueryRadius(x, y, radius, visit)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// BenchmarkZoneQueries compares walking the zone's maps with its spatial
+// hashes, see benchmarkZoneQueries
+func BenchmarkZoneQueries(b *testing.B) {
+	b.Run("scan/1000", func(b *testing.B) { benchmarkZoneQueries(b, 1000, false) })
+	b.Run("grid/1000", func(b *testing.B) { benchmarkZoneQueries(b, 1000, true) })
+	b.Run("scan/4000", func(b *testing.B) { benchmarkZoneQueries(b, 4000, false) })
+	b.Run("grid/4000", func(b *testing.B) { benchmarkZoneQueries(b, 4000, true) })
+}
+
+// benchmarkZoneQueries measures a tick's worth of the queries a zone makes on
+// its entities: every enemy looks for the nearest player, every player checks
+// for enemies on screen and swings at the enemies around it. The grid variant
+// also pays for moving every enemy in the index. Compare ns/op of scan, the
+// full map walks the zone made before it was indexed, with grid.
+func benchmarkZoneQueries(b *testing.B, enemies int, indexed bool) {
+	const players = 50
+	const swingRadius float32 = 64
+	zone := benchmarkZone(rand.New(rand.NewSource(1)), 1, players, enemies)
+	gs := &GameServer{Zones: map[int]*Zone{zone.ID: zone}}
+	hits := 0
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, enemy := range zone.Enemies {
+			if indexed {
+				zone.enemyMoved(enemy)
+				enemy.findNearestPlayer(gs, zone)
+				continue
+			}
+			var nearest *Player
+			var minDistSq float32 = math.MaxFloat32
+			for _, player := range zone.Players {
+				dx, dy := player.X-enemy.X, player.Y-enemy.Y
+				if distSq := dx*dx + dy*dy; distSq < minDistSq {
+					nearest, minDistSq = player, distSq
+				}
+			}
+			_ = nearest
+		}
+
+		for _, player := range zone.Players {
+			if indexed {
+				if isEnemiesOnScreen(player, zone) {
+					hits++
+				}
+				zone.enemiesWithin(player.X, player.Y, swingRadius+MaxColliderReach, func(enemy *Enemy, _ float32) {
+					hits++
+				})
+				continue
+			}
+			for _, enemy := range zone.Enemies {
+				if enemy.X >= player.X-640 && enemy.X <= player.X+640 && enemy.Y >= player.Y-400 && enemy.Y <= player.Y+400 {
+					hits++
+					break
+				}
+			}
+			for _, enemy := range zone.Enemies {
+				dx, dy := enemy.X-player.X, enemy.Y-player.Y
+				if reach := swingRadius + MaxColliderReach; dx*dx+dy*dy <= reach*reach {
+					hits++
+				}
+			}
+		}
+	}
+	benchmarkSink = hits
+}